@@ -0,0 +1,423 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/khanghh/vscode-server/internal/core"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix prefixes the environment variable of every setting, e.g. VSCODE_SERVER_ROOTDIR.
+const envPrefix = "VSCODE_SERVER_"
+
+// Where the value of a setting comes from, in order of precedence.
+const (
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+	sourceDefault = "default"
+)
+
+var configFlag = &cli.StringFlag{
+	Name:    "config",
+	Usage:   "YAML or TOML file with settings keyed by flag name, see docs/configuration.md",
+	EnvVars: []string{envPrefix + "CONFIG"},
+}
+
+// config is the effective configuration: the settings of a context with where each was set.
+type config struct {
+	*cli.Context
+	sources map[string]string // setting name to source
+}
+
+// envVarName returns the environment variable of a setting.
+func envVarName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// loadConfig fills the settings not given as flags from the environment, then from the
+// config file. Settings set neither way keep their defaults.
+func loadConfig(cCtx *cli.Context) (*config, error) {
+	cfg := &config{Context: cCtx, sources: make(map[string]string, len(settingFlags))}
+	var fileValues map[string]any
+	path := cCtx.String(configFlag.Name)
+	if path != "" {
+		var err error
+		if fileValues, err = readConfigFile(path); err != nil {
+			return nil, err
+		}
+		for key := range fileValues {
+			if findSetting(key) == nil {
+				msg := fmt.Sprintf("config file %s: unknown setting %q", path, key)
+				if suggestion := cli.SuggestFlag(settingFlags, key, true); suggestion != "" {
+					msg += fmt.Sprintf(", did you mean %q?", strings.TrimLeft(suggestion, "-"))
+				}
+				return nil, errors.New(msg)
+			}
+		}
+	}
+
+	for _, f := range settingFlags {
+		name := f.Names()[0]
+		if cCtx.IsSet(name) {
+			cfg.sources[name] = sourceFlag
+			continue
+		}
+		if value, ok := os.LookupEnv(envVarName(name)); ok {
+			values := []string{value}
+			if _, isSlice := f.(*cli.StringSliceFlag); isSlice {
+				values = strings.Split(value, ",")
+			}
+			if err := setConfigValues(cCtx, f, values); err != nil {
+				return nil, fmt.Errorf("environment variable %s: %w", envVarName(name), err)
+			}
+			cfg.sources[name] = sourceEnv
+			continue
+		}
+		if value, ok := fileValues[name]; ok {
+			values, err := configFileValues(f, value)
+			if err == nil {
+				err = setConfigValues(cCtx, f, values)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("config file %s: %s: %w", path, name, err)
+			}
+			cfg.sources[name] = sourceFile
+			continue
+		}
+		cfg.sources[name] = sourceDefault
+	}
+	return cfg, nil
+}
+
+// reloadConfig loads the configuration again from the command line, the environment and
+// the config file, into a new context.
+func reloadConfig() (*config, error) {
+	cCtx, err := newFlagContext(os.Args[1:])
+	if err != nil {
+		return nil, err
+	}
+	return loadConfig(cCtx)
+}
+
+// newFlagContext parses the flags of args into a new context of the app.
+func newFlagContext(args []string) (*cli.Context, error) {
+	set := flag.NewFlagSet(app.Name, flag.ContinueOnError)
+	set.SetOutput(io.Discard)
+	for _, f := range app.Flags {
+		if err := f.Apply(set); err != nil {
+			return nil, err
+		}
+	}
+	if err := set.Parse(args); err != nil {
+		return nil, err
+	}
+	return cli.NewContext(app, set, nil), nil
+}
+
+// readConfigFile reads a TOML file when its name ends in .toml, otherwise a YAML file.
+func readConfigFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	values := make(map[string]any)
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		err = toml.Unmarshal(data, &values)
+	} else {
+		var nodes map[string]yaml.Node
+		err = yaml.Unmarshal(data, &nodes)
+		for key, node := range nodes {
+			values[key] = yamlValue(&node)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// yamlValue returns scalars of a YAML value as written, so e.g. socket-mode: 0660 is not
+// read as the octal number 432, and sequences as lists of them.
+func yamlValue(node *yaml.Node) any {
+	switch {
+	case node.Kind == yaml.ScalarNode && node.Tag != "!!null":
+		return node.Value
+	case node.Kind == yaml.SequenceNode:
+		items := make([]any, len(node.Content))
+		for i, item := range node.Content {
+			items[i] = yamlValue(item)
+		}
+		return items
+	}
+	return nil
+}
+
+// configFileValues converts a value of the config file to the flag values of a setting.
+func configFileValues(f cli.Flag, value any) ([]string, error) {
+	items, isList := value.([]any)
+	if _, isSlice := f.(*cli.StringSliceFlag); isSlice {
+		if !isList {
+			items = []any{value}
+		}
+		values := make([]string, 0, len(items))
+		for _, item := range items {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected %s, got %v", settingKind(f), value)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	}
+	switch value.(type) {
+	case string, bool, int, int64, uint64, float64:
+		return []string{fmt.Sprint(value)}, nil
+	}
+	return nil, fmt.Errorf("expected %s, got %v", settingKind(f), value)
+}
+
+// setConfigValues sets a setting as if it was given as flag once per value.
+func setConfigValues(cCtx *cli.Context, f cli.Flag, values []string) error {
+	for _, value := range values {
+		if err := cCtx.Set(f.Names()[0], strings.TrimSpace(value)); err != nil {
+			return fmt.Errorf("invalid value %q, expected %s", value, settingKind(f))
+		}
+	}
+	return nil
+}
+
+// settingKind describes the values a setting accepts.
+func settingKind(f cli.Flag) string {
+	switch f.(type) {
+	case *cli.BoolFlag:
+		return "true or false"
+	case *cli.IntFlag, *cli.Int64Flag:
+		return "an integer"
+	case *cli.Float64Flag:
+		return "a number"
+	case *cli.DurationFlag:
+		return "a duration such as 90s, 10m or 1h30m"
+	case *cli.StringSliceFlag:
+		return "a list of strings"
+	}
+	return "a string"
+}
+
+// findSetting returns the flag of a setting by name.
+func findSetting(name string) cli.Flag {
+	for _, f := range settingFlags {
+		for _, n := range f.Names() {
+			if n == name {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// validateConfig checks the settings for values the server would fail on, reporting every
+// invalid setting with where it was set.
+func validateConfig(cfg *config) error {
+	var errs []error
+	invalid := func(name string, format string, args ...any) {
+		setting := name
+		switch cfg.sources[name] {
+		case sourceFlag:
+			setting += " (from --" + name + ")"
+		case sourceEnv:
+			setting += " (from " + envVarName(name) + ")"
+		case sourceFile:
+			setting += " (from config file " + cfg.String(configFlag.Name) + ")"
+		}
+		errs = append(errs, fmt.Errorf("%s: %s", setting, fmt.Sprintf(format, args...)))
+	}
+
+	if len(cfg.StringSlice(listenFlag.Name)) == 0 {
+		invalid(listenFlag.Name, "must not be empty")
+	}
+	for _, f := range []*cli.StringSliceFlag{listenFlag, adminListenFlag} {
+		for _, addr := range cfg.StringSlice(f.Name) {
+			if err := validateListenAddr(addr); err != nil {
+				invalid(f.Name, "%v", err)
+			}
+		}
+	}
+	if addr := cfg.String(metricsListenFlag.Name); addr != "" {
+		if err := validateListenAddr(addr); err != nil {
+			invalid(metricsListenFlag.Name, "%v", err)
+		}
+	}
+	if _, err := parseSocketMode(cfg.String(socketModeFlag.Name)); err != nil {
+		invalid(socketModeFlag.Name, "%v", err)
+	}
+	for _, f := range []*cli.StringFlag{rootDirFlag, webDirFlag} {
+		if cfg.String(f.Name) == "" {
+			invalid(f.Name, "must not be empty")
+		}
+	}
+	for _, f := range []*cli.Int64Flag{quotaBytesFlag, quotaFilesFlag} {
+		if cfg.Int64(f.Name) < 0 {
+			invalid(f.Name, "must not be negative")
+		}
+	}
+	for _, f := range []*cli.DurationFlag{shutdownTimeoutFlag, quotaScanIntervalFlag, terminalIdleTimeoutFlag, lspIdleTimeoutFlag, portScanIntervalFlag, hostMetricsIntervalFlag, recordingRetentionFlag} {
+		if cfg.Duration(f.Name) < 0 {
+			invalid(f.Name, "must not be negative")
+		}
+	}
+	for _, f := range []*cli.IntFlag{terminalScrollbackFlag, hostMetricsHistoryFlag} {
+		if cfg.Int(f.Name) <= 0 {
+			invalid(f.Name, "must be positive")
+		}
+	}
+	certFile, keyFile := cfg.String(tlsCertFlag.Name), cfg.String(tlsKeyFlag.Name)
+	switch {
+	case cfg.Bool(tlsSelfSignedFlag.Name):
+		for _, name := range []string{tlsCertFlag.Name, tlsKeyFlag.Name} {
+			if cfg.String(name) != "" {
+				invalid(name, "cannot be combined with --%s", tlsSelfSignedFlag.Name)
+			}
+		}
+	case certFile != "" && keyFile == "":
+		invalid(tlsKeyFlag.Name, "is required by --%s", tlsCertFlag.Name)
+	case keyFile != "" && certFile == "":
+		invalid(tlsCertFlag.Name, "is required by --%s", tlsKeyFlag.Name)
+	case certFile == "" && cfg.String(tlsClientCAFlag.Name) != "":
+		invalid(tlsClientCAFlag.Name, "requires --%s or --%s", tlsCertFlag.Name, tlsSelfSignedFlag.Name)
+	}
+	for _, proxy := range cfg.StringSlice(trustedProxiesFlag.Name) {
+		if err := validateTrustedProxy(proxy); err != nil {
+			invalid(trustedProxiesFlag.Name, "%v", err)
+		}
+	}
+	if err := validateOrigins(cfg.String(corsOriginsFlag.Name)); err != nil {
+		invalid(corsOriginsFlag.Name, "%v", err)
+	}
+	if _, err := parseCommands("language server", nil, cfg.StringSlice(lspServerFlag.Name)); err != nil {
+		invalid(lspServerFlag.Name, "%v", err)
+	}
+	if _, err := parseCommands("debug adapter", nil, cfg.StringSlice(debugAdapterFlag.Name)); err != nil {
+		invalid(debugAdapterFlag.Name, "%v", err)
+	}
+	if _, err := core.ParsePortAllowlist(cfg.String(proxyPortsFlag.Name)); err != nil {
+		invalid(proxyPortsFlag.Name, "%v", err)
+	}
+	if policy := cfg.String(recordingPolicyFlag.Name); policy != core.RecordOptIn && policy != core.RecordAlways {
+		invalid(recordingPolicyFlag.Name, "must be %q or %q, got %q", core.RecordOptIn, core.RecordAlways, policy)
+	}
+	switch exporter := cfg.String(traceExporterFlag.Name); exporter {
+	case traceExporterNone, traceExporterOTLP, traceExporterStdout:
+	case traceExporterFile:
+		if cfg.String(traceFileFlag.Name) == "" {
+			invalid(traceFileFlag.Name, "is required by the file trace exporter")
+		}
+	default:
+		invalid(traceExporterFlag.Name, "must be %q, %q or %q, got %q", traceExporterOTLP, traceExporterStdout, traceExporterFile, exporter)
+	}
+	if ratio := cfg.Float64(traceSampleRatioFlag.Name); ratio < 0 || ratio > 1 {
+		invalid(traceSampleRatioFlag.Name, "must be between 0 and 1, got %v", ratio)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// validateOrigins checks a comma separated list of CORS origins such as
+// "https://ide.example.com,https://*.example.com".
+func validateOrigins(origins string) error {
+	if origins == "*" {
+		return nil
+	}
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSpace(origin)
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.Contains(u.Host, "*") || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("invalid origin %q, expected \"*\" or origins such as https://ide.example.com", origin)
+		}
+	}
+	return nil
+}
+
+// trustedProxyUnix trusts the peers of unix sockets as proxies.
+const trustedProxyUnix = "unix"
+
+// validateTrustedProxy checks an IP address, CIDR range or "unix" of --trusted-proxies.
+func validateTrustedProxy(proxy string) error {
+	if proxy == trustedProxyUnix || net.ParseIP(proxy) != nil {
+		return nil
+	}
+	if _, _, err := net.ParseCIDR(proxy); err == nil {
+		return nil
+	}
+	return fmt.Errorf("invalid proxy %q, expected an IP address, a CIDR range or \"unix\"", proxy)
+}
+
+// settingValue returns the value of a setting as printed by config print.
+func settingValue(cCtx *cli.Context, f cli.Flag) any {
+	name := f.Names()[0]
+	switch f.(type) {
+	case *cli.StringFlag:
+		return cCtx.String(name)
+	case *cli.BoolFlag:
+		return cCtx.Bool(name)
+	case *cli.IntFlag:
+		return cCtx.Int(name)
+	case *cli.Int64Flag:
+		return cCtx.Int64(name)
+	case *cli.Float64Flag:
+		return cCtx.Float64(name)
+	case *cli.DurationFlag:
+		return cCtx.Duration(name).String()
+	case *cli.StringSliceFlag:
+		if values := cCtx.StringSlice(name); values != nil {
+			return values
+		}
+		return []string{}
+	}
+	return nil
+}
+
+// printConfig prints the effective settings as a config file, commenting where each was set.
+func printConfig(cCtx *cli.Context) error {
+	cfg, err := loadConfig(cCtx)
+	if err != nil {
+		return err
+	}
+	doc := &yaml.Node{Kind: yaml.MappingNode}
+	for _, f := range settingFlags {
+		name := f.Names()[0]
+		var node yaml.Node
+		if err := node.Encode(settingValue(cCtx, f)); err != nil {
+			return err
+		}
+		if node.Kind == yaml.SequenceNode {
+			node.Style = yaml.FlowStyle
+		}
+		node.LineComment = cfg.sources[name]
+		if node.LineComment == sourceEnv {
+			node.LineComment += " " + envVarName(name)
+		}
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, &node)
+	}
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return validateConfig(cfg)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testConfig loads the configuration of args and env, with file as config file when not empty.
+// The name of the file decides its format.
+func testConfig(t *testing.T, args []string, env map[string]string, name, file string) (*config, error) {
+	t.Helper()
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	if file != "" {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"--config", path}, args...)
+	}
+	cCtx, err := newFlagContext(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loadConfig(cCtx)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		file       string
+		wantValue  string
+		wantSource string
+	}{
+		{
+			name:       "default",
+			wantValue:  "0660",
+			wantSource: sourceDefault,
+		},
+		{
+			name:       "file over default",
+			file:       "socket-mode: \"0600\"\n",
+			wantValue:  "0600",
+			wantSource: sourceFile,
+		},
+		{
+			name:       "env over file",
+			env:        map[string]string{"VSCODE_SERVER_SOCKET_MODE": "0640"},
+			file:       "socket-mode: \"0600\"\n",
+			wantValue:  "0640",
+			wantSource: sourceEnv,
+		},
+		{
+			name:       "flag over env and file",
+			args:       []string{"--socket-mode", "0620"},
+			env:        map[string]string{"VSCODE_SERVER_SOCKET_MODE": "0640"},
+			file:       "socket-mode: \"0600\"\n",
+			wantValue:  "0620",
+			wantSource: sourceFlag,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := testConfig(t, tt.args, tt.env, "config.yaml", tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.String(socketModeFlag.Name); got != tt.wantValue {
+				t.Errorf("socket-mode = %q, want %q", got, tt.wantValue)
+			}
+			if got := cfg.sources[socketModeFlag.Name]; got != tt.wantSource {
+				t.Errorf("source = %q, want %q", got, tt.wantSource)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		setting string
+		want    any
+	}{
+		{"yaml octal mode stays as written", "config.yaml", "socket-mode: 0660\n", "socket-mode", "0660"},
+		{"yaml list", "config.yaml", "listen:\n  - \":8080\"\n  - unix:/run/ide.sock\n", "listen", []string{":8080", "unix:/run/ide.sock"}},
+		{"yaml scalar as list", "config.yaml", "listen: \":8080\"\n", "listen", []string{":8080"}},
+		{"yaml integer", "config.yaml", "terminal-scrollback: 2048\n", "terminal-scrollback", 2048},
+		{"yaml bool", "config.yaml", "debug: true\n", "debug", true},
+		{"yaml duration", "config.yaml", "shutdown-timeout: 1m30s\n", "shutdown-timeout", "1m30s"},
+		{"toml string", "config.toml", "socket-mode = \"0600\"\n", "socket-mode", "0600"},
+		{"toml list", "config.toml", "listen = [\":8080\", \":8443\"]\n", "listen", []string{":8080", ":8443"}},
+		{"toml integer", "config.toml", "quota-bytes = 1073741824\n", "quota-bytes", int64(1 << 30)},
+		{"toml float", "config.toml", "trace-sample-ratio = 0.25\n", "trace-sample-ratio", 0.25},
+		{"toml extension is case insensitive", "config.TOML", "debug = true\n", "debug", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := testConfig(t, nil, nil, tt.file, tt.content)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := settingValue(cfg.Context, findSetting(tt.setting)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s = %#v, want %#v", tt.setting, got, tt.want)
+			}
+			if got := cfg.sources[tt.setting]; got != sourceFile {
+				t.Errorf("source = %q, want %q", got, sourceFile)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		file    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "unknown setting",
+			file:    "config.yaml",
+			content: "sockt-mode: \"0600\"\n",
+			wantErr: `unknown setting "sockt-mode", did you mean "socket-mode"?`,
+		},
+		{
+			name:    "invalid integer in file",
+			file:    "config.yaml",
+			content: "terminal-scrollback: lots\n",
+			wantErr: `terminal-scrollback: invalid value "lots", expected an integer`,
+		},
+		{
+			name:    "invalid duration in file",
+			file:    "config.toml",
+			content: "shutdown-timeout = \"soon\"\n",
+			wantErr: `shutdown-timeout: invalid value "soon", expected a duration such as 90s, 10m or 1h30m`,
+		},
+		{
+			name:    "mapping for a list",
+			file:    "config.yaml",
+			content: "listen:\n  port: 8080\n",
+			wantErr: "listen: expected a list of strings",
+		},
+		{
+			name:    "list for a scalar",
+			file:    "config.toml",
+			content: "rootdir = [\"/srv\", \"/home\"]\n",
+			wantErr: "rootdir: expected a string",
+		},
+		{
+			name:    "malformed file",
+			file:    "config.yaml",
+			content: "listen: [\n",
+			wantErr: "config file ",
+		},
+		{
+			name:    "invalid bool in env",
+			env:     map[string]string{"VSCODE_SERVER_DEBUG": "maybe"},
+			wantErr: `environment variable VSCODE_SERVER_DEBUG: invalid value "maybe", expected true or false`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := testConfig(t, nil, tt.env, tt.file, tt.content)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		env      map[string]string
+		file     string
+		wantErrs []string // empty for a valid configuration
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name: "valid listeners",
+			args: []string{"--listen", "127.0.0.1:8080", "--listen", "unix:/run/ide.sock", "--listen", "systemd:web"},
+		},
+		{
+			name:     "invalid listen flag",
+			args:     []string{"--listen", "localhost"},
+			wantErrs: []string{`listen (from --listen): invalid address "localhost"`},
+		},
+		{
+			name:     "invalid socket mode from env",
+			env:      map[string]string{"VSCODE_SERVER_SOCKET_MODE": "0999"},
+			wantErrs: []string{`socket-mode (from VSCODE_SERVER_SOCKET_MODE): invalid permissions "0999"`},
+		},
+		{
+			name:     "negative quota from file",
+			file:     "quota-bytes: -1\n",
+			wantErrs: []string{"quota-bytes (from config file ", "): must not be negative"},
+		},
+		{
+			name:     "tls key without cert",
+			args:     []string{"--tls-key", "key.pem"},
+			wantErrs: []string{"tls-cert: is required by --tls-key"},
+		},
+		{
+			name:     "every invalid setting is reported",
+			args:     []string{"--terminal-scrollback", "0", "--trace-sample-ratio", "2"},
+			wantErrs: []string{"terminal-scrollback (from --terminal-scrollback): must be positive", "trace-sample-ratio (from --trace-sample-ratio): must be between 0 and 1, got 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := testConfig(t, tt.args, tt.env, "config.yaml", tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = validateConfig(cfg)
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error = %v, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// ConfigReload is the outcome of reloading the configuration.
+type ConfigReload struct {
+	Applied         []string `json:"applied"`         // changed settings now in effect
+	RestartRequired []string `json:"restartRequired"` // changed settings that only take effect on restart
+}
+
+// AdminHandler serves operations on the server itself under /api/v1/admin.
+type AdminHandler struct {
+	config ConfigReloader
+}
+
+func NewAdminHandler(config ConfigReloader) *AdminHandler {
+	return &AdminHandler{config: config}
+}
+
+// POST /api/v1/admin/reload
+// Reloads the configuration file and applies the settings that can change while running,
+// like SIGHUP. An invalid configuration is rejected as a whole and leaves the running one in place.
+func (h *AdminHandler) Reload(c *fiber.Ctx) error {
+	result, err := h.config.Reload()
+	if err != nil {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+			"error": err.Error(),
+			"code":  "INVALID_CONFIG",
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(result)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/khanghh/vscode-server/internal/core"
+)
+
+// debugErrorCodes maps debug session failures to the error code returned to clients.
+var debugErrorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{core.ErrNoLaunchFile, fiber.StatusNotFound, "NO_LAUNCH_FILE"},
+	{core.ErrInvalidLaunchFile, fiber.StatusUnprocessableEntity, "INVALID_LAUNCH_FILE"},
+	{core.ErrLaunchConfigNotFound, fiber.StatusNotFound, "LAUNCH_CONFIG_NOT_FOUND"},
+	{core.ErrUnknownDebugAdapter, fiber.StatusUnprocessableEntity, "UNKNOWN_DEBUG_ADAPTER"},
+}
+
+// DebugHandler tunnels debug adapters to the workbench under /api/v1/debug.
+type DebugHandler struct {
+	svc DebugService
+}
+
+func NewDebugHandler(svc DebugService) *DebugHandler {
+	return &DebugHandler{svc: svc}
+}
+
+// GET /api/v1/debug/configurations?folder=<workspace folder>
+// Lists the launch configurations of the folder's .vscode/launch.json.
+func (h *DebugHandler) Configurations(c *fiber.Ctx) error {
+	configs, err := h.svc.Configurations(c.Query("folder"))
+	if err != nil {
+		return mapDebugError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(configs)
+}
+
+// Start starts the debug session before the WebSocket upgrade so that unknown
+// configurations are reported with a regular HTTP error.
+func (h *DebugHandler) Start(c *fiber.Ctx) error {
+	if c.Query("name") == "" {
+		return badRequest(c, "missing name")
+	}
+	session, err := h.svc.Start(core.DebugStartRequest{
+		Folder: c.Query("folder"),
+		Name:   c.Query("name"),
+		File:   c.Query("file"),
+	})
+	if err != nil {
+		return mapDebugError(c, err)
+	}
+	c.Locals("session", session)
+	if err := c.Next(); err != nil {
+		session.Close()
+		return err
+	}
+	return nil
+}
+
+// GET /api/v1/debug/session?folder=<workspace folder>&name=<configuration>&file=<active file> (WebSocket)
+// Every text frame carries one DAP message, without the Content-Length framing used by the
+// adapter. Paths use the remotefs: scheme on the socket and are rewritten to local paths for
+// the adapter. Output of the adapter process and of the preLaunchTask is sent as output
+// events. The adapter and the debuggee are terminated when the socket closes. A client that
+// falls behind is disconnected with close code 1013.
+func (h *DebugHandler) Session(conn *websocket.Conn) {
+	session := conn.Locals("session").(*core.DebugSession)
+
+	var writeMu sync.Mutex
+	defer startWriter(session.Close, func() {
+		for data := range session.Messages() {
+			writeMu.Lock()
+			err := conn.WriteMessage(websocket.TextMessage, data)
+			writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := session.Err(); err != nil {
+			closeWebSocket(conn, websocket.CloseTryAgainLater, err.Error())
+		} else {
+			closeWebSocket(conn, websocket.CloseNormalClosure, "")
+		}
+	})()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := session.Send(data); errors.Is(err, core.ErrDebugSessionClosed) {
+			return
+		}
+	}
+}
+
+func mapDebugError(c *fiber.Ctx, err error) error {
+	countError(err)
+	for _, e := range debugErrorCodes {
+		if errors.Is(err, e.err) {
+			return c.Status(e.status).JSON(fiber.Map{"error": err.Error(), "code": e.code})
+		}
+	}
+	if errors.Is(err, core.ErrPathTraversal) {
+		return c.Status(fiber.StatusForbidden).JSON(JSONErrNoPermissions)
+	}
+	return fileServiceError(c, err)
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// eventsPingInterval is how often an idle event stream is pinged to detect gone clients.
+const eventsPingInterval = 30 * time.Second
+
+// EventsHandler streams server events under /api/v1/events.
+type EventsHandler struct {
+	events EventService
+}
+
+func NewEventsHandler(events EventService) *EventsHandler {
+	return &EventsHandler{events: events}
+}
+
+// GET /api/v1/events?types=<type>,<type>
+// Streams server events, such as port-opened and port-closed, as server-sent events named
+// after the event type. The data is the event payload. Idle streams get a "ping" event every
+// 30 seconds.
+func (h *EventsHandler) Stream(c *fiber.Ctx) error {
+	var types []string
+	if q := c.Query("types"); q != "" {
+		types = strings.Split(q, ",")
+	}
+	events, unsubscribe := h.events.Subscribe()
+	return streamSSE(c, func(send sseSendFunc) {
+		defer unsubscribe()
+		ping := time.NewTicker(eventsPingInterval)
+		defer ping.Stop()
+		for {
+			var err error
+			select {
+			case ev := <-events:
+				if types != nil && !slices.Contains(types, ev.Type) {
+					continue
+				}
+				err = send(ev.Type, ev.Data)
+			case <-ping.C:
+				err = send("ping", fiber.Map{"time": time.Now()})
+			case <-shuttingDown:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/khanghh/vscode-server/internal/core"
+)
+
+// signals clients may send to a running command
+var execSignals = map[string]syscall.Signal{
+	"SIGINT":  syscall.SIGINT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// execMessage is a frame of the exec WebSocket protocol, in either direction.
+type execMessage struct {
+	Type   string `json:"type"`
+	Data   string `json:"data,omitempty"`
+	Signal string `json:"signal,omitempty"`
+	PID    int    `json:"pid,omitempty"`
+	Code   *int   `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Time   int64  `json:"time,omitempty"` // unix milliseconds
+}
+
+// execStart is the first frame sent by the client.
+type execStart struct {
+	core.ExecRequest
+	TimeoutMs int64 `json:"timeoutMs"`
+}
+
+// ExecHandler runs non-interactive commands over a WebSocket under /api/v1/exec.
+type ExecHandler struct {
+	svc ExecService
+}
+
+func NewExecHandler(svc ExecService) *ExecHandler {
+	return &ExecHandler{svc: svc}
+}
+
+// GET /api/v1/exec (WebSocket)
+// The client first sends {"argv": [...], "cwd": "<rel>", "env": {...}, "timeoutMs": <n>}.
+// The server answers with "start", then "stdout"/"stderr" frames and a final "exit" frame.
+// Afterwards the client may send "stdin" (data), "eof", "signal" (signal name) or "kill" frames.
+// Closing the socket kills the process group.
+func (h *ExecHandler) Exec(conn *websocket.Conn) {
+	var writeMu sync.Mutex
+	send := func(msg execMessage) error {
+		msg.Time = time.Now().UnixMilli()
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(msg)
+	}
+
+	var start execStart
+	if err := conn.ReadJSON(&start); err != nil {
+		send(execMessage{Type: "error", Error: "invalid start message"})
+		closeWebSocket(conn, websocket.CloseUnsupportedData, "invalid start message")
+		return
+	}
+	start.Timeout = time.Duration(start.TimeoutMs) * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	proc, err := h.svc.Start(ctx, core.ProcessKindExec, start.ExecRequest,
+		streamWriter(func(p []byte) error { return send(execMessage{Type: "stdout", Data: string(p)}) }),
+		streamWriter(func(p []byte) error { return send(execMessage{Type: "stderr", Data: string(p)}) }),
+	)
+	if err != nil {
+		countError(err)
+		send(execMessage{Type: "error", Error: err.Error()})
+		closeWebSocket(conn, websocket.CloseNormalClosure, "")
+		return
+	}
+	send(execMessage{Type: "start", PID: proc.PID})
+
+	defer startWriter(cancel, func() {
+		code, err := proc.Wait()
+		msg := execMessage{Type: "exit", Code: &code}
+		if err != nil {
+			msg.Error = err.Error()
+		}
+		send(msg)
+		writeMu.Lock()
+		closeWebSocket(conn, websocket.CloseNormalClosure, "")
+		writeMu.Unlock()
+	})()
+
+	for {
+		var msg execMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			// client went away: cancelling the context reaps the process group
+			return
+		}
+		switch msg.Type {
+		case "stdin":
+			proc.Write([]byte(msg.Data))
+		case "eof":
+			proc.CloseStdin()
+		case "signal":
+			if sig, ok := execSignals[msg.Signal]; ok {
+				proc.Signal(sig)
+			}
+		case "kill":
+			proc.Terminate()
+		}
+	}
+}
+
+// streamWriter adapts a send function to io.Writer.
+type streamWriter func(p []byte) error
+
+func (w streamWriter) Write(p []byte) (int, error) {
+	if err := w(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// startWriter runs write in its own goroutine as the single writer of a WebSocket handler.
+// The connection must not be used once the handler returns, so the handler defers the
+// returned function, which calls stop to make write return and waits for it.
+func startWriter(stop, write func()) func() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		write()
+	}()
+	return func() {
+		stop()
+		<-done
+	}
+}
+
+// closeWebSocket sends a close frame; the connection is torn down when the handler returns.
+func closeWebSocket(conn *websocket.Conn, code int, reason string) {
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(time.Second))
+}
+
+// upgradeWebSocket rejects requests that are not WebSocket upgrades.
+func upgradeWebSocket(c *fiber.Ctx) error {
+	if websocket.IsWebSocketUpgrade(c) {
+		return c.Next()
+	}
+	return fiber.ErrUpgradeRequired
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/khanghh/vscode-server/internal/core"
 
 	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var (
@@ -34,6 +35,10 @@ var (
 		"error": "directory is not empty",
 		"code":  "DIRECTORY_NOT_EMPTY",
 	}
+	JSONErrQuotaExceeded = fiber.Map{
+		"error": "storage quota exceeded",
+		"code":  "QUOTA_EXCEEDED",
+	}
 )
 
 type FileType int
@@ -47,10 +52,16 @@ const (
 // FSHandler implements the File Explorer API under /api/fs
 type FSHandler struct {
 	svc LocalFileService
+	git GitTreeService // optional, serves ?ref= reads
+}
+
+func NewFSHandler(svc LocalFileService, git GitTreeService) *FSHandler {
+	return &FSHandler{svc: svc, git: git}
 }
 
-func NewFSHandler(svc LocalFileService) *FSHandler {
-	return &FSHandler{svc: svc}
+// files returns the file service, tracing its calls under the span of the request.
+func (h *FSHandler) files(c *fiber.Ctx) LocalFileService {
+	return traceFileService(c.UserContext(), h.svc)
 }
 
 // helper: parse wildcard path from route, normalize to relative (no leading slash)
@@ -64,60 +75,110 @@ func (h *FSHandler) pathFromParam(c *fiber.Ctx) string {
 	if up, err := url.PathUnescape(p); err == nil {
 		p = up
 	}
-	p = strings.TrimPrefix(p, "/")
-	return p
+	// c.Params() points into a buffer reused by the next request, while spans outlive it
+	return strings.Clone(strings.TrimPrefix(p, "/"))
 }
 
 // GET /api/v1/fs/*path
 // - Directory: list as JSON array
 // - File: return raw content; when download=true, set Content-Disposition
 // - With stat=true: return JSON metadata for file or directory
+// - With ref=<rev>: serve the path read-only from the git tree at that revision
 func (h *FSHandler) Get(c *fiber.Ctx) error {
 	rel := h.pathFromParam(c)
-	fi, err := h.svc.Stat(rel)
+	span := startSpan(c, "FSHandler.Get", attribute.String("file.path", rel))
+	defer endSpan(c, span)
+	if ref := c.Query("ref"); ref != "" {
+		return h.getAtRef(c, rel, ref)
+	}
+	fi, err := h.files(c).Stat(rel)
 	if err != nil {
 		return mapLocalFileServiceError(c, err)
 	}
 
 	if strings.EqualFold(c.Query("stat"), "true") {
-		// Return metadata
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"type":         fileTypeOf(fi),
-			"size":         fi.Size(),
-			"lastModified": fi.ModTime().UTC().Format(time.RFC3339),
-		})
+		return sendStat(c, fi)
 	}
 
 	if fi.IsDir() {
-		items, err := h.svc.List(rel)
+		items, err := h.files(c).List(rel)
 		if err != nil {
 			return mapLocalFileServiceError(c, err)
 		}
-		// Format lastModified as RFC3339 per design doc
-		type listEntry struct {
-			Name         string   `json:"name"`
-			Type         FileType `json:"type"`
-			Size         int64    `json:"size"`
-			LastModified string   `json:"lastModified"`
-		}
-		out := make([]listEntry, 0, len(items))
-		for _, it := range items {
-			out = append(out, listEntry{
-				Name:         it.Name(),
-				Type:         fileTypeOf(it),
-				Size:         it.Size(),
-				LastModified: it.ModTime().UTC().Format(time.RFC3339),
-			})
-		}
-		return c.Status(fiber.StatusOK).JSON(out)
+		return sendListing(c, items)
 	}
 
 	// File
-	data, err := h.svc.ReadFile(rel)
+	data, err := h.files(c).ReadFile(rel)
 	if err != nil {
 		return mapLocalFileServiceError(c, err)
 	}
-	mime, _ := h.svc.DetectMIMEType(rel)
+	mime, _ := h.files(c).DetectMIMEType(rel)
+	return sendFile(c, rel, data, mime)
+}
+
+// getAtRef serves stat, listing and file contents from a git revision using the same shapes as Get.
+func (h *FSHandler) getAtRef(c *fiber.Ctx, rel, ref string) error {
+	if h.git == nil {
+		return badRequest(c, "git revisions are not supported")
+	}
+	ctx := c.UserContext()
+	fi, err := h.git.StatAt(ctx, rel, ref)
+	if err != nil {
+		return mapGitError(c, err)
+	}
+
+	if strings.EqualFold(c.Query("stat"), "true") {
+		return sendStat(c, fi)
+	}
+
+	if fi.IsDir() {
+		items, err := h.git.ListAt(ctx, rel, ref)
+		if err != nil {
+			return mapGitError(c, err)
+		}
+		return sendListing(c, items)
+	}
+
+	data, err := h.git.ReadFileAt(ctx, rel, ref)
+	if err != nil {
+		return mapGitError(c, err)
+	}
+	return sendFile(c, rel, data, detectBlobMIMEType(rel, data))
+}
+
+// sendStat writes JSON metadata for a file or directory.
+func sendStat(c *fiber.Ctx, fi fs.FileInfo) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"type":         fileTypeOf(fi),
+		"size":         fi.Size(),
+		"lastModified": fi.ModTime().UTC().Format(time.RFC3339),
+	})
+}
+
+// sendListing writes a directory listing as a JSON array.
+func sendListing(c *fiber.Ctx, items []fs.FileInfo) error {
+	// Format lastModified as RFC3339 per design doc
+	type listEntry struct {
+		Name         string   `json:"name"`
+		Type         FileType `json:"type"`
+		Size         int64    `json:"size"`
+		LastModified string   `json:"lastModified"`
+	}
+	out := make([]listEntry, 0, len(items))
+	for _, it := range items {
+		out = append(out, listEntry{
+			Name:         it.Name(),
+			Type:         fileTypeOf(it),
+			Size:         it.Size(),
+			LastModified: it.ModTime().UTC().Format(time.RFC3339),
+		})
+	}
+	return c.Status(fiber.StatusOK).JSON(out)
+}
+
+// sendFile writes raw file content; when download=true, set Content-Disposition.
+func sendFile(c *fiber.Ctx, rel string, data []byte, mime string) error {
 	if mime != "" {
 		c.Set(fiber.HeaderContentType, mime)
 	}
@@ -144,11 +205,13 @@ func fileTypeOf(info fs.FileInfo) FileType {
 // POST /api/v1/fs/*parent { path: <child_path>, type: "file"|"directory", "create": <bool>, "overwrite": <bool> }
 func (h *FSHandler) Post(ctx *fiber.Ctx) error {
 	rel := h.pathFromParam(ctx)
+	span := startSpan(ctx, "FSHandler.Post", attribute.String("file.path", rel))
+	defer endSpan(ctx, span)
 
 	// Check that target directory exists
-	st, err := h.svc.Stat(rel)
+	st, err := h.files(ctx).Stat(rel)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if isNotFound(err) {
 			return ctx.Status(fiber.StatusNotFound).JSON(errorMsg("target path not found"))
 		}
 		return mapLocalFileServiceError(ctx, err)
@@ -203,9 +266,9 @@ func (h *FSHandler) handleUploadFile(ctx *fiber.Ctx, rel string) error {
 
 	// If overwrite is false, check existence and return 409 with code
 	if !overwrite {
-		if _, err := h.svc.Stat(destRel); err == nil {
+		if _, err := h.files(ctx).Stat(destRel); err == nil {
 			return ctx.Status(fiber.StatusConflict).JSON(JSONErrFileExists)
-		} else if !os.IsNotExist(err) {
+		} else if !isNotFound(err) {
 			return mapLocalFileServiceError(ctx, err)
 		}
 	}
@@ -214,7 +277,7 @@ func (h *FSHandler) handleUploadFile(ctx *fiber.Ctx, rel string) error {
 	if err != nil {
 		return mapLocalFileServiceError(ctx, err)
 	}
-	if err := h.svc.SaveStream(destRel, src, overwrite); err != nil {
+	if err := h.files(ctx).SaveStream(destRel, src, overwrite); err != nil {
 		_ = src.Close()
 		return mapLocalFileServiceError(ctx, err)
 	}
@@ -225,11 +288,11 @@ func (h *FSHandler) handleUploadFile(ctx *fiber.Ctx, rel string) error {
 // handleCreateDirectories creates all directories in the given path under parent dir.
 func (h *FSHandler) handleCreateDirectories(ctx *fiber.Ctx, parentPath, path string) error {
 	fullpath := filepath.Join(parentPath, path)
-	if _, err := h.svc.Stat(fullpath); err == nil {
+	if _, err := h.files(ctx).Stat(fullpath); err == nil {
 		return ctx.Status(fiber.StatusConflict).JSON(JSONErrFileExists)
 	}
 
-	if err := h.svc.MkdirAll(fullpath); err != nil {
+	if err := h.files(ctx).MkdirAll(fullpath); err != nil {
 		return mapLocalFileServiceError(ctx, err)
 	}
 	return ctx.SendStatus(fiber.StatusCreated)
@@ -238,13 +301,13 @@ func (h *FSHandler) handleCreateDirectories(ctx *fiber.Ctx, parentPath, path str
 func (h *FSHandler) handlerCreateFile(ctx *fiber.Ctx, rel, name string, overwrite bool) error {
 	destRel := filepath.Join(rel, name)
 	if !overwrite {
-		if _, err := h.svc.Stat(destRel); err == nil {
+		if _, err := h.files(ctx).Stat(destRel); err == nil {
 			return ctx.Status(fiber.StatusConflict).JSON(JSONErrFileExists)
-		} else if !os.IsNotExist(err) {
+		} else if !isNotFound(err) {
 			return mapLocalFileServiceError(ctx, err)
 		}
 	}
-	if err := h.svc.WriteFile(destRel, nil, true); err != nil {
+	if err := h.files(ctx).WriteFile(destRel, nil, true); err != nil {
 		return mapLocalFileServiceError(ctx, err)
 	}
 	return ctx.SendStatus(fiber.StatusCreated)
@@ -252,13 +315,15 @@ func (h *FSHandler) handlerCreateFile(ctx *fiber.Ctx, rel, name string, overwrit
 
 func (h *FSHandler) Put(ctx *fiber.Ctx) error {
 	rel := h.pathFromParam(ctx)
+	span := startSpan(ctx, "FSHandler.Put", attribute.String("file.path", rel), attribute.Int("file.size", len(ctx.Body())))
+	defer endSpan(ctx, span)
 	overwrite := strings.EqualFold(ctx.Query("overwrite"), "true")
 
 	if ctx.Get(fiber.HeaderContentType) != "application/octet-stream" {
 		return badRequest(ctx, "expected application/octet-stream")
 	}
 
-	err := h.svc.SaveStream(rel, bytes.NewReader(ctx.Body()), overwrite)
+	err := h.files(ctx).SaveStream(rel, bytes.NewReader(ctx.Body()), overwrite)
 	if err != nil {
 		return mapLocalFileServiceError(ctx, err)
 	}
@@ -270,6 +335,8 @@ func (h *FSHandler) Put(ctx *fiber.Ctx) error {
 // - Rename file or directory with body {"name": <new_name>}
 func (h *FSHandler) Patch(c *fiber.Ctx) error {
 	relPath := h.pathFromParam(c)
+	span := startSpan(c, "FSHandler.Patch", attribute.String("file.path", relPath))
+	defer endSpan(c, span)
 	var body struct {
 		NewPath   string `json:"newPath"`
 		Overwrite bool   `json:"overwrite"`
@@ -281,7 +348,7 @@ func (h *FSHandler) Patch(c *fiber.Ctx) error {
 		return badRequest(c, "missing new path")
 	}
 	// Rename file or directory
-	if err := h.svc.Rename(relPath, body.NewPath, body.Overwrite); err != nil {
+	if err := h.files(c).Rename(relPath, body.NewPath, body.Overwrite); err != nil {
 		return mapLocalFileServiceError(c, err)
 	}
 	return c.SendStatus(fiber.StatusOK)
@@ -290,14 +357,16 @@ func (h *FSHandler) Patch(c *fiber.Ctx) error {
 // DELETE /api/v1/fs/*path
 func (h *FSHandler) Delete(c *fiber.Ctx) error {
 	rel := h.pathFromParam(c)
+	span := startSpan(c, "FSHandler.Delete", attribute.String("file.path", rel))
+	defer endSpan(c, span)
 	recursive := strings.EqualFold(c.Query("recursive"), "true")
 	if recursive {
-		if err := h.svc.DeleteRecursive(rel); err != nil {
+		if err := h.files(c).DeleteRecursive(rel); err != nil {
 			return mapLocalFileServiceError(c, err)
 		}
 		return c.SendStatus(fiber.StatusOK)
 	}
-	if err := h.svc.Delete(rel); err != nil {
+	if err := h.files(c).Delete(rel); err != nil {
 		return mapLocalFileServiceError(c, err)
 	}
 	return c.SendStatus(fiber.StatusOK)
@@ -305,7 +374,14 @@ func (h *FSHandler) Delete(c *fiber.Ctx) error {
 
 // Helper functions
 func mapLocalFileServiceError(c *fiber.Ctx, err error) error {
-	if os.IsNotExist(err) || errors.Is(err, core.ErrNotFound) {
+	countError(err)
+	return fileServiceError(c, err)
+}
+
+// fileServiceError responds with the status of a file service error without counting it, for
+// the error mappers of other services that count the error themselves.
+func fileServiceError(c *fiber.Ctx, err error) error {
+	if isNotFound(err) {
 		return c.Status(fiber.StatusNotFound).JSON(JSONErrFileNotFound)
 	}
 	if os.IsPermission(err) {
@@ -314,9 +390,17 @@ func mapLocalFileServiceError(c *fiber.Ctx, err error) error {
 	if errors.Is(err, core.ErrDirNotEmpty) {
 		return c.Status(fiber.StatusBadRequest).JSON(JSONErrDirectoryNotEmpty)
 	}
+	if errors.Is(err, core.ErrQuotaExceeded) {
+		return c.Status(fiber.StatusInsufficientStorage).JSON(JSONErrQuotaExceeded)
+	}
 	return c.Status(fiber.StatusInternalServerError).JSON(errorMsg(err.Error()))
 }
 
+// isNotFound reports whether err means the path does not exist, either from the os or the file service.
+func isNotFound(err error) bool {
+	return os.IsNotExist(err) || errors.Is(err, core.ErrNotFound)
+}
+
 func badRequest(c *fiber.Ctx, msg string) error {
 	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": msg})
 }
@@ -0,0 +1,451 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/khanghh/vscode-server/internal/core"
+)
+
+var (
+	JSONErrNotRepository = fiber.Map{
+		"error": "not a git repository",
+		"code":  "NOT_A_REPOSITORY",
+	}
+	JSONErrRevisionNotFound = fiber.Map{
+		"error": "revision or path not found",
+		"code":  "REVISION_NOT_FOUND",
+	}
+)
+
+// gitErrorCodes maps structured git failures to the error code returned to clients.
+var gitErrorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{core.ErrGitConflict, fiber.StatusConflict, "GIT_CONFLICT"},
+	{core.ErrGitHookFailed, fiber.StatusUnprocessableEntity, "GIT_HOOK_FAILED"},
+	{core.ErrGitIndexLocked, fiber.StatusConflict, "GIT_INDEX_LOCKED"},
+	{core.ErrNothingToCommit, fiber.StatusConflict, "NOTHING_TO_COMMIT"},
+	{core.ErrBranchExists, fiber.StatusConflict, "BRANCH_EXISTS"},
+	{core.ErrNoStashToRestore, fiber.StatusNotFound, "NO_STASH_ENTRIES"},
+	{core.ErrInvalidRefName, fiber.StatusBadRequest, "INVALID_REF_NAME"},
+	{core.ErrOutsideWorkTree, fiber.StatusForbidden, "NO_PERMISSIONS"},
+	{core.ErrMissingPaths, fiber.StatusBadRequest, "MISSING_PATHS"},
+	{core.ErrMissingMessage, fiber.StatusBadRequest, "MISSING_MESSAGE"},
+	{core.ErrNoOperationInProgress, fiber.StatusConflict, "NO_OPERATION_IN_PROGRESS"},
+	{core.ErrNotConflicted, fiber.StatusConflict, "NOT_CONFLICTED"},
+	{core.ErrAlreadyExists, fiber.StatusConflict, "FILE_EXISTS"},
+}
+
+// GitHandler implements the source control API under /api/v1/git.
+// The repository is given by the "repo" query parameter relative to the root directory,
+// file paths are relative to the repository.
+type GitHandler struct {
+	svc  GitService
+	jobs JobService
+}
+
+func NewGitHandler(svc GitService, jobs JobService) *GitHandler {
+	return &GitHandler{svc: svc, jobs: jobs}
+}
+
+// GET /api/v1/git/status?repo=<repo>
+func (h *GitHandler) Status(c *fiber.Ctx) error {
+	st, err := h.svc.Status(c.UserContext(), c.Query("repo"))
+	if err != nil {
+		return mapGitError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(st)
+}
+
+// GET /api/v1/git/diff?repo=<repo>&path=<path>&against=index|head|staged
+// Returns a unified diff as text/plain.
+func (h *GitHandler) Diff(c *fiber.Ctx) error {
+	out, err := h.svc.Diff(c.UserContext(), c.Query("repo"), c.Query("path"), c.Query("against"))
+	if err != nil {
+		return mapGitError(c, err)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+	return c.Send(out)
+}
+
+// GET /api/v1/git/show?repo=<repo>&path=<path>&ref=<rev>[&stage=0..3]
+// Returns raw file contents at a revision (default HEAD) or from an index stage.
+func (h *GitHandler) Show(c *fiber.Ctx) error {
+	path := c.Query("path")
+	if path == "" {
+		return badRequest(c, "missing path")
+	}
+	stage := -1
+	if s := c.Query("stage"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return badRequest(c, "invalid stage")
+		}
+		stage = n
+	}
+	data, err := h.svc.Show(c.UserContext(), c.Query("repo"), c.Query("ref"), path, stage)
+	if err != nil {
+		return mapGitError(c, err)
+	}
+	c.Set(fiber.HeaderContentType, detectBlobMIMEType(path, data))
+	return c.Send(data)
+}
+
+// GET /api/v1/git/log?repo=<repo>&ref=<rev>&path=<path>&skip=<n>&limit=<n>
+// With path set, history follows renames of that file.
+func (h *GitHandler) Log(c *fiber.Ctx) error {
+	page, err := h.svc.Log(c.UserContext(), c.Query("repo"), c.Query("ref"), c.Query("path"), c.QueryInt("skip"), c.QueryInt("limit"))
+	if err != nil {
+		return mapGitError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(page)
+}
+
+// GET /api/v1/git/blame?repo=<repo>&path=<path>&ref=<rev>
+// Without ref the working tree version is blamed; uncommitted lines have an all-zero commit id.
+func (h *GitHandler) Blame(c *fiber.Ctx) error {
+	ranges, err := h.svc.Blame(c.UserContext(), c.Query("repo"), c.Query("ref"), c.Query("path"))
+	if err != nil {
+		return mapGitError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(ranges)
+}
+
+// gitRequest is the common JSON body of mutating git endpoints.
+type gitRequest struct {
+	Repo             string   `json:"repo"`
+	Paths            []string `json:"paths"`
+	Patch            string   `json:"patch"`
+	Message          string   `json:"message"`
+	Amend            bool     `json:"amend"`
+	Name             string   `json:"name"`
+	StartPoint       string   `json:"startPoint"`
+	Checkout         bool     `json:"checkout"`
+	Untracked        bool     `json:"untracked"`
+	IncludeUntracked bool     `json:"includeUntracked"`
+	Index            int      `json:"index"`
+	Path             string   `json:"path"`
+	Branch           string   `json:"branch"`
+	NewBranch        bool     `json:"newBranch"`
+	InitialBranch    string   `json:"initialBranch"`
+	Source           string   `json:"source"`
+	Bare             bool     `json:"bare"`
+}
+
+// POST /api/v1/git/stage {repo, paths} or {repo, patch}
+func (h *GitHandler) Stage(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if err := h.svc.Stage(c.UserContext(), body.Repo, body.Paths, body.Patch); err != nil {
+		return mapGitError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// POST /api/v1/git/unstage {repo, paths} or {repo, patch}
+func (h *GitHandler) Unstage(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if err := h.svc.Unstage(c.UserContext(), body.Repo, body.Paths, body.Patch); err != nil {
+		return mapGitError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// POST /api/v1/git/discard {repo, paths, untracked}
+func (h *GitHandler) Discard(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if err := h.svc.Discard(c.UserContext(), body.Repo, body.Paths, body.Untracked); err != nil {
+		return mapGitError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// POST /api/v1/git/commit {repo, message, amend}
+// The identity is taken from the X-Forwarded-User and X-Forwarded-Email headers set by an
+// authenticating proxy listed in --trusted-proxies, falling back to the repository configuration.
+func (h *GitHandler) Commit(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	commit, err := h.svc.Commit(c.UserContext(), body.Repo, body.Message, requestIdentity(c), body.Amend)
+	if err != nil {
+		return mapGitError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"commit": commit})
+}
+
+// POST /api/v1/git/branches {repo, name, startPoint, checkout}
+func (h *GitHandler) CreateBranch(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if err := h.svc.CreateBranch(c.UserContext(), body.Repo, body.Name, body.StartPoint, body.Checkout); err != nil {
+		return mapGitError(c, err)
+	}
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// POST /api/v1/git/checkout {repo, name}
+func (h *GitHandler) SwitchBranch(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if err := h.svc.SwitchBranch(c.UserContext(), body.Repo, body.Name); err != nil {
+		return mapGitError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// DELETE /api/v1/git/branches/*name?repo=<repo>&force=true
+func (h *GitHandler) DeleteBranch(c *fiber.Ctx) error {
+	name := c.Params("*")
+	if up, err := url.PathUnescape(name); err == nil {
+		name = up
+	}
+	force := strings.EqualFold(c.Query("force"), "true")
+	if err := h.svc.DeleteBranch(c.UserContext(), c.Query("repo"), name, force); err != nil {
+		return mapGitError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// POST /api/v1/git/stash {repo, message, includeUntracked}
+func (h *GitHandler) Stash(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if err := h.svc.Stash(c.UserContext(), body.Repo, body.Message, body.IncludeUntracked); err != nil {
+		return mapGitError(c, err)
+	}
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// POST /api/v1/git/stash/pop {repo, index}
+func (h *GitHandler) StashPop(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if err := h.svc.StashPop(c.UserContext(), body.Repo, body.Index); err != nil {
+		return mapGitError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// GET /api/v1/git/conflicts?repo=<repo>
+// Lists unresolved paths with the blob ids of their base/ours/theirs stages, which can be read
+// through /api/v1/git/show with stage=1, 2 or 3.
+func (h *GitHandler) Conflicts(c *fiber.Ctx) error {
+	state, err := h.svc.Conflicts(c.UserContext(), c.Query("repo"))
+	if err != nil {
+		return mapGitError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(state)
+}
+
+// POST /api/v1/git/conflicts/resolve?repo=<repo>&path=<path>
+// The raw request body is the resolved file content; the path is written atomically and marked resolved.
+func (h *GitHandler) ResolveConflict(c *fiber.Ctx) error {
+	path := c.Query("path")
+	if path == "" {
+		return badRequest(c, "missing path")
+	}
+	if err := h.svc.ResolveConflict(c.UserContext(), c.Query("repo"), path, bytes.NewReader(c.Body())); err != nil {
+		return mapGitError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// POST /api/v1/git/conflicts/mark {repo, paths}
+func (h *GitHandler) MarkResolved(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if err := h.svc.MarkResolved(c.UserContext(), body.Repo, body.Paths); err != nil {
+		return mapGitError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// POST /api/v1/git/continue {repo}
+// Continues the merge, rebase, cherry-pick or revert in progress.
+func (h *GitHandler) Continue(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if err := h.svc.ContinueOperation(c.UserContext(), body.Repo); err != nil {
+		return mapGitError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// POST /api/v1/git/abort {repo}
+// Aborts the merge, rebase, cherry-pick or revert in progress.
+func (h *GitHandler) Abort(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if err := h.svc.AbortOperation(c.UserContext(), body.Repo); err != nil {
+		return mapGitError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// GET /api/v1/git/repos?depth=<n>
+// Discovers the repositories under the root directory.
+func (h *GitHandler) Repos(c *fiber.Ctx) error {
+	repos, err := h.svc.DiscoverRepos(c.UserContext(), c.QueryInt("depth"))
+	if err != nil {
+		return mapGitError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(repos)
+}
+
+// POST /api/v1/git/init {path, initialBranch}
+// Runs as a background job, see /api/v1/jobs.
+func (h *GitHandler) Init(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	return startJob(c, h.jobs, "git-init", "git init "+body.Path, func(ctx context.Context, out io.Writer) (any, error) {
+		return nil, h.svc.Init(ctx, body.Path, body.InitialBranch, out)
+	})
+}
+
+// POST /api/v1/git/clone {source, path, bare}
+// Clones a repository or bundle under the root into path. Runs as a background job. With a
+// storage quota it answers 507 when the quota is used up, and the job fails and leaves
+// nothing behind when the clone does not fit.
+func (h *GitHandler) Clone(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if body.Source == "" || body.Path == "" {
+		return badRequest(c, "missing source or path")
+	}
+	if err := h.svc.CheckQuota(); err != nil {
+		return mapGitError(c, err)
+	}
+	return startJob(c, h.jobs, "git-clone", "git clone "+body.Source+" "+body.Path, func(ctx context.Context, out io.Writer) (any, error) {
+		return nil, h.svc.Clone(ctx, body.Source, body.Path, body.Bare, out)
+	})
+}
+
+// GET /api/v1/git/worktrees?repo=<repo>
+func (h *GitHandler) Worktrees(c *fiber.Ctx) error {
+	worktrees, err := h.svc.ListWorktrees(c.UserContext(), c.Query("repo"))
+	if err != nil {
+		return mapGitError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(worktrees)
+}
+
+// POST /api/v1/git/worktrees {repo, branch, newBranch, path}
+// Runs as a background job whose result is {"path": <worktree path>}. The storage quota is
+// enforced as for clones.
+func (h *GitHandler) AddWorktree(c *fiber.Ctx) error {
+	var body gitRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if err := h.svc.CheckQuota(); err != nil {
+		return mapGitError(c, err)
+	}
+	return startJob(c, h.jobs, "git-worktree-add", "git worktree add "+body.Branch, func(ctx context.Context, out io.Writer) (any, error) {
+		path, err := h.svc.AddWorktree(ctx, body.Repo, body.Path, body.Branch, body.NewBranch, out)
+		if err != nil {
+			return nil, err
+		}
+		return fiber.Map{"path": path}, nil
+	})
+}
+
+// DELETE /api/v1/git/worktrees?repo=<repo>&path=<path>&force=true
+// Runs as a background job.
+func (h *GitHandler) RemoveWorktree(c *fiber.Ctx) error {
+	repo, path := c.Query("repo"), c.Query("path")
+	if path == "" {
+		return badRequest(c, "missing path")
+	}
+	force := strings.EqualFold(c.Query("force"), "true")
+	return startJob(c, h.jobs, "git-worktree-remove", "git worktree remove "+path, func(ctx context.Context, out io.Writer) (any, error) {
+		return nil, h.svc.RemoveWorktree(ctx, repo, path, force, out)
+	})
+}
+
+// requestIdentity returns the commit identity of the authenticated user, if a trusted proxy
+// provided one. Without trusted proxies any client could set the headers, so they are ignored.
+func requestIdentity(c *fiber.Ctx) *core.GitIdentity {
+	if !c.App().Config().EnableTrustedProxyCheck || !c.IsProxyTrusted() {
+		return nil
+	}
+	name, email := c.Get("X-Forwarded-User"), c.Get("X-Forwarded-Email")
+	if name == "" || email == "" {
+		return nil
+	}
+	return &core.GitIdentity{Name: name, Email: email}
+}
+
+// detectBlobMIMEType infers a MIME type by extension, falling back to sniffing content.
+func detectBlobMIMEType(path string, data []byte) string {
+	if mt := mime.TypeByExtension(filepath.Ext(path)); mt != "" {
+		return mt
+	}
+	return http.DetectContentType(data)
+}
+
+func mapGitError(c *fiber.Ctx, err error) error {
+	countError(err)
+	if errors.Is(err, core.ErrNotRepository) {
+		return c.Status(fiber.StatusNotFound).JSON(JSONErrNotRepository)
+	}
+	if errors.Is(err, core.ErrRevNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(JSONErrRevisionNotFound)
+	}
+	if errors.Is(err, core.ErrPathTraversal) {
+		return c.Status(fiber.StatusForbidden).JSON(JSONErrNoPermissions)
+	}
+	if errors.Is(err, core.ErrInvalidRevision) {
+		return badRequest(c, err.Error())
+	}
+	for _, e := range gitErrorCodes {
+		if errors.Is(err, e.err) {
+			resp := fiber.Map{"error": e.err.Error(), "code": e.code}
+			var gitErr *core.GitError
+			if errors.As(err, &gitErr) {
+				resp["output"] = strings.TrimSpace(gitErr.Stdout + gitErr.Stderr)
+			}
+			return c.Status(e.status).JSON(resp)
+		}
+	}
+	return fileServiceError(c, err)
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// HostHandler reports the resources of the host under /api/v1/host.
+type HostHandler struct {
+	svc HostMetricsService
+}
+
+func NewHostHandler(svc HostMetricsService) *HostHandler {
+	return &HostHandler{svc: svc}
+}
+
+// GET /api/v1/host
+// Returns the latest sample of CPU, load, memory, swap and root filesystem usage.
+func (h *HostHandler) Latest(c *fiber.Ctx) error {
+	sample, err := h.svc.Latest()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(errorMsg(err.Error()))
+	}
+	return c.Status(fiber.StatusOK).JSON(sample)
+}
+
+// GET /api/v1/host/history
+// Returns the recent samples, oldest first.
+func (h *HostHandler) History(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.svc.History())
+}
+
+// GET /api/v1/host/stream?interval=<duration>&history=true
+// Streams samples as server-sent "sample" events, the recent samples first when history is
+// set. With interval, at most one sample is sent per interval; by default every sample is.
+// Idle streams get a "ping" event every 30 seconds, e.g. when sampling is disabled.
+func (h *HostHandler) Stream(c *fiber.Ctx) error {
+	var interval time.Duration
+	if q := c.Query("interval"); q != "" {
+		d, err := time.ParseDuration(q)
+		if err != nil || d < 0 {
+			return badRequest(c, "invalid interval")
+		}
+		interval = d
+	}
+	withHistory := c.QueryBool("history")
+	samples, unsubscribe := h.svc.Subscribe()
+	return streamSSE(c, func(send sseSendFunc) {
+		defer unsubscribe()
+		var last time.Time
+		if withHistory {
+			for _, s := range h.svc.History() {
+				if err := send("sample", s); err != nil {
+					return
+				}
+				last = s.Time
+			}
+		}
+		// tolerate the jitter of the sampling ticker
+		minGap := interval - interval/10
+		ping := time.NewTicker(eventsPingInterval)
+		defer ping.Stop()
+		for {
+			select {
+			case s, ok := <-samples:
+				if !ok {
+					return
+				}
+				if s.Time.Sub(last) < minGap || !s.Time.After(last) {
+					continue
+				}
+				if err := send("sample", s); err != nil {
+					return
+				}
+				last = s.Time
+			case <-ping.C:
+				if err := send("ping", fiber.Map{"time": time.Now()}); err != nil {
+					return
+				}
+			case <-shuttingDown:
+				return
+			}
+		}
+	})
+}
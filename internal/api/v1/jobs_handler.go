@@ -0,0 +1,93 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/khanghh/vscode-server/internal/core"
+)
+
+var JSONErrJobNotFound = fiber.Map{
+	"error": "job not found",
+	"code":  "JOB_NOT_FOUND",
+}
+
+// JobsHandler exposes background jobs under /api/v1/jobs.
+type JobsHandler struct {
+	jobs JobService
+}
+
+func NewJobsHandler(jobs JobService) *JobsHandler {
+	return &JobsHandler{jobs: jobs}
+}
+
+// GET /api/v1/jobs
+func (h *JobsHandler) List(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.jobs.List())
+}
+
+// GET /api/v1/jobs/:id
+func (h *JobsHandler) Get(c *fiber.Ctx) error {
+	job, err := h.jobs.Get(c.Params("id"))
+	if err != nil {
+		return mapJobError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(job.Info())
+}
+
+// DELETE /api/v1/jobs/:id
+// Cancels a running job.
+func (h *JobsHandler) Cancel(c *fiber.Ctx) error {
+	if err := h.jobs.Cancel(c.Params("id")); err != nil {
+		return mapJobError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// GET /api/v1/jobs/:id/output
+// Streams the job output as server-sent "output" events ({"data": "<chunk>"}), replaying what was
+// produced so far, followed by a single "done" event carrying the final job info.
+func (h *JobsHandler) Output(c *fiber.Ctx) error {
+	job, err := h.jobs.Get(c.Params("id"))
+	if err != nil {
+		return mapJobError(c, err)
+	}
+	return streamSSE(c, func(send sseSendFunc) {
+		backlog, ch, unsubscribe := job.Subscribe()
+		defer unsubscribe()
+		if len(backlog) > 0 {
+			if err := send("output", fiber.Map{"data": string(backlog)}); err != nil {
+				return
+			}
+		}
+		for {
+			select {
+			case chunk, ok := <-ch:
+				if !ok {
+					<-job.Done()
+					send("done", job.Info())
+					return
+				}
+				if err := send("output", fiber.Map{"data": string(chunk)}); err != nil {
+					return
+				}
+			case <-shuttingDown:
+				return
+			}
+		}
+	})
+}
+
+// startJob runs fn as a background job and responds with 202 and the job info.
+func startJob(c *fiber.Ctx, jobs JobService, kind, description string, fn core.JobFunc) error {
+	job := jobs.Start(kind, description, fn)
+	return c.Status(fiber.StatusAccepted).JSON(job.Info())
+}
+
+func mapJobError(c *fiber.Ctx, err error) error {
+	countError(err)
+	if errors.Is(err, core.ErrJobNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(JSONErrJobNotFound)
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(errorMsg(err.Error()))
+}
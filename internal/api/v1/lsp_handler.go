@@ -0,0 +1,84 @@
+package api
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/khanghh/vscode-server/internal/core"
+)
+
+var JSONErrUnknownLanguageServer = fiber.Map{
+	"error": "unknown language server",
+	"code":  "UNKNOWN_LANGUAGE_SERVER",
+}
+
+// LSPHandler bridges language servers to the workbench under /api/v1/lsp.
+type LSPHandler struct {
+	svc LSPService
+}
+
+func NewLSPHandler(svc LSPService) *LSPHandler {
+	return &LSPHandler{svc: svc}
+}
+
+// GET /api/v1/lsp
+// Lists the configured language servers and the workspace folders they run for.
+func (h *LSPHandler) Servers(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.svc.Servers())
+}
+
+// Exists rejects connections to unknown language servers before the WebSocket upgrade.
+func (h *LSPHandler) Exists(c *fiber.Ctx) error {
+	for _, s := range h.svc.Servers() {
+		if s.ID == c.Params("server") {
+			return c.Next()
+		}
+	}
+	return c.Status(fiber.StatusNotFound).JSON(JSONErrUnknownLanguageServer)
+}
+
+// GET /api/v1/lsp/:server?folder=<workspace folder> (WebSocket)
+// Every text frame carries one JSON-RPC message, without the Content-Length framing used on
+// the server's stdio. URIs use the remotefs: scheme on the socket and are rewritten to file
+// URIs under the root for the server. The server is shared by every client of the same
+// folder and keeps running for a while after the last client disconnects.
+func (h *LSPHandler) Connect(conn *websocket.Conn) {
+	client, err := h.svc.Connect(conn.Params("server"), conn.Query("folder"))
+	if err != nil {
+		countError(err)
+		reason := err.Error()
+		if errors.Is(err, core.ErrUnknownLanguageServer) || errors.Is(err, core.ErrPathTraversal) || errors.Is(err, core.ErrNotDirectory) {
+			closeWebSocket(conn, websocket.ClosePolicyViolation, reason)
+		} else {
+			closeWebSocket(conn, websocket.CloseInternalServerErr, reason)
+		}
+		return
+	}
+
+	var writeMu sync.Mutex
+	defer startWriter(client.Close, func() {
+		for data := range client.Messages() {
+			writeMu.Lock()
+			err := conn.WriteMessage(websocket.TextMessage, data)
+			writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+		writeMu.Lock()
+		closeWebSocket(conn, websocket.CloseNormalClosure, "")
+		writeMu.Unlock()
+	})()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := client.Send(data); errors.Is(err, core.ErrLSPClientClosed) {
+			return
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/khanghh/vscode-server/internal/core"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "vscode_server"
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status.",
+	}, []string{"method", "route", "status"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by method, route and status. WebSocket requests end at the upgrade.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+	websocketConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "websocket_connections",
+		Help:      "Open WebSocket connections by route.",
+	}, []string{"route"})
+	apiErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "errors_total",
+		Help:      "Errors returned to clients by core error.",
+	}, []string{"error"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		httpRequests,
+		httpRequestDuration,
+		websocketConnections,
+		apiErrors,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// coreErrors names the core sentinel errors for the errors_total metric.
+var coreErrors = []struct {
+	err  error
+	name string
+}{
+	{core.ErrPathTraversal, "ErrPathTraversal"},
+	{core.ErrNotFound, "ErrNotFound"},
+	{core.ErrIsDirectory, "ErrIsDirectory"},
+	{core.ErrNotDirectory, "ErrNotDirectory"},
+	{core.ErrAlreadyExists, "ErrAlreadyExists"},
+	{core.ErrDirNotEmpty, "ErrDirNotEmpty"},
+	{core.ErrMissingNewName, "ErrMissingNewName"},
+	{core.ErrQuotaExceeded, "ErrQuotaExceeded"},
+	{core.ErrGitConflict, "ErrGitConflict"},
+	{core.ErrGitHookFailed, "ErrGitHookFailed"},
+	{core.ErrGitIndexLocked, "ErrGitIndexLocked"},
+	{core.ErrNothingToCommit, "ErrNothingToCommit"},
+	{core.ErrBranchExists, "ErrBranchExists"},
+	{core.ErrMissingPaths, "ErrMissingPaths"},
+	{core.ErrMissingMessage, "ErrMissingMessage"},
+	{core.ErrInvalidRefName, "ErrInvalidRefName"},
+	{core.ErrOutsideWorkTree, "ErrOutsideWorkTree"},
+	{core.ErrNoStashToRestore, "ErrNoStashToRestore"},
+	{core.ErrInvalidCloneSource, "ErrInvalidCloneSource"},
+	{core.ErrMainWorktree, "ErrMainWorktree"},
+	{core.ErrWorktreePathNeeded, "ErrWorktreePathNeeded"},
+	{core.ErrNotRepository, "ErrNotRepository"},
+	{core.ErrInvalidRevision, "ErrInvalidRevision"},
+	{core.ErrRevNotFound, "ErrRevNotFound"},
+	{core.ErrNoOperationInProgress, "ErrNoOperationInProgress"},
+	{core.ErrNotConflicted, "ErrNotConflicted"},
+	{core.ErrJobNotFound, "ErrJobNotFound"},
+	{core.ErrMissingCommand, "ErrMissingCommand"},
+	{core.ErrNoTasksFile, "ErrNoTasksFile"},
+	{core.ErrInvalidTasksFile, "ErrInvalidTasksFile"},
+	{core.ErrTaskNotFound, "ErrTaskNotFound"},
+	{core.ErrTaskCycle, "ErrTaskCycle"},
+	{core.ErrTaskFailed, "ErrTaskFailed"},
+	{core.ErrUnsupportedTask, "ErrUnsupportedTask"},
+	{core.ErrUnknownMatcher, "ErrUnknownMatcher"},
+	{core.ErrTerminalNotFound, "ErrTerminalNotFound"},
+	{core.ErrTerminalExited, "ErrTerminalExited"},
+	{core.ErrTerminalReadOnly, "ErrTerminalReadOnly"},
+	{core.ErrInvalidGuestAccess, "ErrInvalidGuestAccess"},
+	{core.ErrRecordingNotFound, "ErrRecordingNotFound"},
+	{core.ErrInvalidRecordingMode, "ErrInvalidRecordingMode"},
+	{core.ErrRecordingRequired, "ErrRecordingRequired"},
+	{core.ErrUnknownLanguageServer, "ErrUnknownLanguageServer"},
+	{core.ErrLSPClientClosed, "ErrLSPClientClosed"},
+	{core.ErrNoLaunchFile, "ErrNoLaunchFile"},
+	{core.ErrInvalidLaunchFile, "ErrInvalidLaunchFile"},
+	{core.ErrLaunchConfigNotFound, "ErrLaunchConfigNotFound"},
+	{core.ErrUnknownDebugAdapter, "ErrUnknownDebugAdapter"},
+	{core.ErrDebugSessionClosed, "ErrDebugSessionClosed"},
+	{core.ErrProcessNotFound, "ErrProcessNotFound"},
+	{core.ErrInvalidSignal, "ErrInvalidSignal"},
+	{core.ErrProtectedProcess, "ErrProtectedProcess"},
+	{core.ErrInvalidConfirmation, "ErrInvalidConfirmation"},
+}
+
+// errorName returns the name of the core sentinel an error wraps, "other" if there is none.
+func errorName(err error) string {
+	for _, e := range coreErrors {
+		if errors.Is(err, e.err) {
+			return e.name
+		}
+	}
+	return "other"
+}
+
+// countError records an error returned to a client under the name of its core sentinel.
+func countError(err error) {
+	apiErrors.WithLabelValues(errorName(err)).Inc()
+}
+
+// MetricsMiddleware records the count and latency of requests by route and status.
+func MetricsMiddleware(c *fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+	status := responseStatus(c, err)
+	// c.Method() points into a buffer reused by the next request
+	labels := []string{strings.Clone(c.Method()), c.Route().Path, strconv.Itoa(status)}
+	httpRequests.WithLabelValues(labels...).Inc()
+	httpRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// trackWebSocket counts the open connections of a WebSocket handler and closes them on Shutdown.
+func trackWebSocket(route string, handler func(*websocket.Conn)) func(*websocket.Conn) {
+	gauge := websocketConnections.WithLabelValues(route)
+	return func(conn *websocket.Conn) {
+		gauge.Inc()
+		defer gauge.Dec()
+		defer registerWebSocket(conn)()
+		handler(conn)
+	}
+}
+
+// SetupMetricsRoutes serves the Prometheus metrics at /metrics. files reports the bytes read
+// and written through the file service, sessions the number of active sessions by kind.
+func SetupMetricsRoutes(router fiber.Router, files FileIOStats, sessions map[string]SessionCounter) error {
+	collectors := []prometheus.Collector{
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "file_read_bytes_total",
+			Help:      "Bytes read from files through the file service.",
+		}, func() float64 {
+			read, _ := files.IOStats()
+			return float64(read)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "file_written_bytes_total",
+			Help:      "Bytes written to files through the file service.",
+		}, func() float64 {
+			_, written := files.IOStats()
+			return float64(written)
+		}),
+	}
+	for kind, counter := range sessions {
+		collectors = append(collectors, prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace:   metricsNamespace,
+			Name:        "active_sessions",
+			Help:        "Active sessions by kind.",
+			ConstLabels: prometheus.Labels{"kind": kind},
+		}, func() float64 { return float64(counter.Active()) }))
+	}
+	for _, c := range collectors {
+		if err := metricsRegistry.Register(c); err != nil {
+			return err
+		}
+	}
+	router.Get("/metrics", adaptor.HTTPHandler(promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})))
+	return nil
+}
@@ -0,0 +1,21 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+// PortsHandler reports the ports listened on by session processes under /api/v1/ports.
+type PortsHandler struct {
+	svc PortService
+}
+
+func NewPortsHandler(svc PortService) *PortsHandler {
+	return &PortsHandler{svc: svc}
+}
+
+// GET /api/v1/ports
+// Lists the TCP ports listened on by processes started from terminals, tasks and other
+// sessions, with the command line of the listening process.
+func (h *PortsHandler) List(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.svc.List())
+}
@@ -0,0 +1,104 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/khanghh/vscode-server/internal/core"
+)
+
+// processErrorCodes maps process management failures to the error code returned to clients.
+var processErrorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{core.ErrProcessNotFound, fiber.StatusNotFound, "PROCESS_NOT_FOUND"},
+	{core.ErrInvalidSignal, fiber.StatusBadRequest, "INVALID_SIGNAL"},
+	{core.ErrProtectedProcess, fiber.StatusForbidden, "PROTECTED_PROCESS"},
+	{core.ErrInvalidConfirmation, fiber.StatusConflict, "INVALID_CONFIRMATION"},
+}
+
+// ProcessesHandler lists and signals processes under /api/v1/processes.
+type ProcessesHandler struct {
+	svc ProcessService
+}
+
+func NewProcessesHandler(svc ProcessService) *ProcessesHandler {
+	return &ProcessesHandler{svc: svc}
+}
+
+// GET /api/v1/processes?session=<session id>
+// Lists the processes of the server's user and of the sessions it spawned. CPU usage is
+// measured over the last 2 seconds.
+func (h *ProcessesHandler) List(c *fiber.Ctx) error {
+	procs, err := h.svc.List(c.Query("session"))
+	if err != nil {
+		return mapProcessError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(procs)
+}
+
+// GET /api/v1/processes/tree
+// Returns the process tree of every terminal, task and other session.
+func (h *ProcessesHandler) Trees(c *fiber.Ctx) error {
+	trees, err := h.svc.Trees()
+	if err != nil {
+		return mapProcessError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(trees)
+}
+
+// GET /api/v1/processes/:pid
+func (h *ProcessesHandler) Get(c *fiber.Ctx) error {
+	pid, err := strconv.Atoi(c.Params("pid"))
+	if err != nil {
+		return badRequest(c, "invalid pid")
+	}
+	proc, err := h.svc.Get(pid)
+	if err != nil {
+		return mapProcessError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(proc)
+}
+
+type signalRequest struct {
+	Signal  string `json:"signal"`
+	Confirm string `json:"confirmToken"`
+}
+
+// POST /api/v1/processes/:pid/signal {signal, confirmToken}
+// Without confirmToken nothing is sent: the response is 202 with a confirmation describing the
+// target process. Repeating the request with its confirmToken within 30 seconds sends the signal.
+func (h *ProcessesHandler) Signal(c *fiber.Ctx) error {
+	pid, err := strconv.Atoi(c.Params("pid"))
+	if err != nil {
+		return badRequest(c, "invalid pid")
+	}
+	var body signalRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if body.Signal == "" {
+		body.Signal = "SIGTERM"
+	}
+	confirmation, err := h.svc.Signal(pid, body.Signal, body.Confirm)
+	if err != nil {
+		return mapProcessError(c, err)
+	}
+	if confirmation != nil {
+		return c.Status(fiber.StatusAccepted).JSON(confirmation)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func mapProcessError(c *fiber.Ctx, err error) error {
+	countError(err)
+	for _, e := range processErrorCodes {
+		if errors.Is(err, e.err) {
+			return c.Status(e.status).JSON(fiber.Map{"error": err.Error(), "code": e.code})
+		}
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(errorMsg(err.Error()))
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	fasthttpws "github.com/fasthttp/websocket"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/proxy"
+	"github.com/valyala/fasthttp"
+)
+
+var (
+	JSONErrPortNotAllowed = fiber.Map{
+		"error": "port is not allowed for forwarding",
+		"code":  "PORT_NOT_ALLOWED",
+	}
+	JSONErrBadGateway = fiber.Map{
+		"error": "nothing is listening on the forwarded port",
+		"code":  "BAD_GATEWAY",
+	}
+)
+
+// websocketForwardedHeaders are the request headers passed on to forwarded WebSocket servers.
+// The credentials are left out under /proxy/<port>/, see credentialHeaders.
+var websocketForwardedHeaders = []string{
+	fiber.HeaderAuthorization,
+	fiber.HeaderCookie,
+	fiber.HeaderOrigin,
+	fiber.HeaderUserAgent,
+}
+
+// credentialHeaders are not forwarded under /proxy/<port>/: that shares the origin of the IDE,
+// so they are the credentials of the IDE and not of the forwarded server.
+var credentialHeaders = []string{
+	fiber.HeaderAuthorization,
+	fiber.HeaderCookie,
+}
+
+// ProxyHandler forwards HTTP and WebSocket requests to servers listening on local ports,
+// either under /proxy/<port>/ or on <port>.<domain>. Subdomains are the safe mode: every port
+// gets an origin of its own, while the forwarded servers under /proxy/<port>/ share the origin
+// of the IDE and, with it, can script the IDE and read its storage.
+type ProxyHandler struct {
+	ports  PortPolicy
+	domain string
+	client *fasthttp.Client
+}
+
+func NewProxyHandler(ports PortPolicy, domain string) *ProxyHandler {
+	return &ProxyHandler{
+		ports:  ports,
+		domain: strings.ToLower(strings.TrimPrefix(domain, ".")),
+		client: &fasthttp.Client{
+			NoDefaultUserAgentHeader: true,
+			DisablePathNormalizing:   true,
+			// dev servers use long-lived responses for live reload
+			StreamResponseBody: true,
+		},
+	}
+}
+
+// ALL /proxy/:port/*
+// The server sees the request path without the /proxy/<port> prefix, which is sent in
+// X-Forwarded-Prefix. Redirects and cookie paths are rewritten to stay under the prefix. The
+// Cookie and Authorization headers, which belong to the IDE on its origin, are not forwarded.
+func (h *ProxyHandler) Path(c *fiber.Ctx) error {
+	port, err := strconv.Atoi(c.Params("port"))
+	if err != nil {
+		return badRequest(c, "invalid port")
+	}
+	prefix := "/proxy/" + c.Params("port")
+	path := strings.TrimPrefix(string(c.Request().URI().PathOriginal()), prefix)
+	if path == "" {
+		// relative links of the forwarded app only resolve below the trailing slash
+		target := prefix + "/"
+		if q := c.Request().URI().QueryString(); len(q) > 0 {
+			target += "?" + string(q)
+		}
+		return c.Redirect(target, fiber.StatusPermanentRedirect)
+	}
+	return h.forward(c, port, path, prefix)
+}
+
+// Subdomain forwards requests for <port>.<domain> and passes every other request on.
+func (h *ProxyHandler) Subdomain(c *fiber.Ctx) error {
+	host := strings.ToLower(c.Hostname())
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	label, ok := strings.CutSuffix(host, "."+h.domain)
+	if !ok || strings.Contains(label, ".") {
+		return c.Next()
+	}
+	port, err := strconv.Atoi(label)
+	if err != nil {
+		return c.Next()
+	}
+	return h.forward(c, port, string(c.Request().URI().PathOriginal()), "")
+}
+
+func (h *ProxyHandler) forward(c *fiber.Ctx, port int, path, prefix string) error {
+	if !h.ports.Allowed(port) {
+		return c.Status(fiber.StatusForbidden).JSON(JSONErrPortNotAllowed)
+	}
+	target := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	uri := path
+	if q := c.Request().URI().QueryString(); len(q) > 0 {
+		uri += "?" + string(q)
+	}
+	if websocket.IsWebSocketUpgrade(c) {
+		return h.forwardWebSocket(c, target, uri, prefix)
+	}
+
+	req := &c.Request().Header
+	if prefix != "" {
+		for _, name := range credentialHeaders {
+			req.Del(name)
+		}
+	}
+	req.Set(fiber.HeaderXForwardedHost, c.Hostname())
+	req.Set(fiber.HeaderXForwardedProto, c.Protocol())
+	if prev := c.Get(fiber.HeaderXForwardedFor); prev != "" {
+		req.Set(fiber.HeaderXForwardedFor, prev+", "+c.IP())
+	} else {
+		req.Set(fiber.HeaderXForwardedFor, c.IP())
+	}
+	if prefix != "" {
+		req.Set("X-Forwarded-Prefix", prefix)
+	}
+	if err := proxy.Do(c, "http://"+target+uri, h.client); err != nil {
+		c.Response().Reset()
+		return c.Status(fiber.StatusBadGateway).JSON(JSONErrBadGateway)
+	}
+	rewriteProxyResponse(&c.Response().Header, port, prefix)
+	return nil
+}
+
+// rewriteProxyResponse makes the redirects and cookies of a forwarded server point at the
+// proxy rather than at the local port.
+func rewriteProxyResponse(header *fasthttp.ResponseHeader, port int, prefix string) {
+	if loc := string(header.Peek(fiber.HeaderLocation)); loc != "" {
+		header.Set(fiber.HeaderLocation, rewriteProxyLocation(loc, port, prefix))
+	}
+
+	var cookies []*fasthttp.Cookie
+	header.VisitAllCookie(func(_, value []byte) {
+		cookie := fasthttp.AcquireCookie()
+		if err := cookie.ParseBytes(value); err != nil {
+			fasthttp.ReleaseCookie(cookie)
+			return
+		}
+		cookies = append(cookies, cookie)
+	})
+	for _, cookie := range cookies {
+		// the domain of the local server is never the domain the browser sees
+		cookie.SetDomain("")
+		if p := string(cookie.Path()); prefix != "" && strings.HasPrefix(p, "/") {
+			cookie.SetPath(prefix + p)
+		}
+		header.SetCookie(cookie)
+		fasthttp.ReleaseCookie(cookie)
+	}
+}
+
+// rewriteProxyLocation maps a Location pointing at the forwarded server to the proxy.
+func rewriteProxyLocation(loc string, port int, prefix string) string {
+	u, err := url.Parse(loc)
+	if err != nil {
+		return loc
+	}
+	if u.IsAbs() {
+		if u.Port() != strconv.Itoa(port) || !isLoopbackHost(u.Hostname()) {
+			return loc
+		}
+		u.Scheme, u.Host = "", ""
+	} else if !strings.HasPrefix(u.Path, "/") {
+		// relative to the current path, which already carries the prefix
+		return loc
+	}
+	return prefix + u.String()
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// forwardWebSocket connects to the forwarded server first so failures are reported with an
+// HTTP status, then upgrades the client and relays messages in both directions.
+func (h *ProxyHandler) forwardWebSocket(c *fiber.Ctx, target, uri, prefix string) error {
+	header := http.Header{}
+	for _, name := range websocketForwardedHeaders {
+		if v := c.Get(name); v != "" && (prefix == "" || !slices.Contains(credentialHeaders, name)) {
+			header.Set(name, v)
+		}
+	}
+	header.Set(fiber.HeaderXForwardedHost, c.Hostname())
+	header.Set(fiber.HeaderXForwardedFor, c.IP())
+	if prefix != "" {
+		header.Set("X-Forwarded-Prefix", prefix)
+	}
+	dialer := fasthttpws.Dialer{HandshakeTimeout: 10 * time.Second}
+	for _, p := range strings.Split(c.Get(fiber.HeaderSecWebSocketProtocol), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			dialer.Subprotocols = append(dialer.Subprotocols, p)
+		}
+	}
+	backend, _, err := dialer.Dial("ws://"+target+uri, header)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(JSONErrBadGateway)
+	}
+
+	var config websocket.Config
+	if p := backend.Subprotocol(); p != "" {
+		config.Subprotocols = []string{p}
+	}
+	err = websocket.New(trackWebSocket("proxy", func(conn *websocket.Conn) {
+		// either direction failing closes both connections to unblock the other one
+		var closeOnce sync.Once
+		closeBoth := func() {
+			closeOnce.Do(func() {
+				backend.Close()
+				conn.Conn.NetConn().Close()
+			})
+		}
+		defer startWriter(closeBoth, func() {
+			relayWebSocket(conn.Conn, backend)
+			closeBoth()
+		})()
+		relayWebSocket(backend, conn.Conn)
+	}), config)(c)
+	if err != nil {
+		backend.Close()
+	}
+	return err
+}
+
+// relayWebSocket copies messages from src to dst until src fails, then passes the close
+// frame on to dst.
+func relayWebSocket(dst, src *fasthttpws.Conn) {
+	for {
+		typ, data, err := src.ReadMessage()
+		if err != nil {
+			code, text := fasthttpws.CloseGoingAway, ""
+			var closeErr *fasthttpws.CloseError
+			if errors.As(err, &closeErr) && closeErr.Code != fasthttpws.CloseAbnormalClosure {
+				code, text = closeErr.Code, closeErr.Text
+			}
+			dst.WriteControl(fasthttpws.CloseMessage, fasthttpws.FormatCloseMessage(code, text), time.Now().Add(time.Second))
+			return
+		}
+		if err := dst.WriteMessage(typ, data); err != nil {
+			return
+		}
+	}
+}
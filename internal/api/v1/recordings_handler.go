@@ -0,0 +1,73 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/khanghh/vscode-server/internal/core"
+)
+
+var JSONErrRecordingNotFound = fiber.Map{
+	"error": "recording not found",
+	"code":  "RECORDING_NOT_FOUND",
+}
+
+var JSONErrRecordingRequired = fiber.Map{
+	"error": "recordings cannot be deleted when every session is recorded",
+	"code":  "RECORDING_REQUIRED",
+}
+
+// RecordingsHandler serves terminal recordings under /api/v1/recordings.
+type RecordingsHandler struct {
+	svc RecordingService
+}
+
+func NewRecordingsHandler(svc RecordingService) *RecordingsHandler {
+	return &RecordingsHandler{svc: svc}
+}
+
+// GET /api/v1/recordings
+func (h *RecordingsHandler) List(c *fiber.Ctx) error {
+	recordings, err := h.svc.List()
+	if err != nil {
+		return mapRecordingError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(recordings)
+}
+
+// GET /api/v1/recordings/:id[?download=true]
+// Streams the asciicast v2 file, which can be fed to an asciicast player as is.
+// Recordings of running sessions grow while they are read.
+func (h *RecordingsHandler) Get(c *fiber.Ctx) error {
+	f, info, err := h.svc.Open(c.Params("id"))
+	if err != nil {
+		return mapRecordingError(c, err)
+	}
+	c.Set(fiber.HeaderContentType, "application/x-asciicast")
+	if strings.EqualFold(c.Query("download"), "true") {
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", info.ID+".cast"))
+	}
+	// fasthttp closes the file once the body has been sent
+	return c.Status(fiber.StatusOK).SendStream(f, int(info.Size))
+}
+
+// DELETE /api/v1/recordings/:id
+func (h *RecordingsHandler) Delete(c *fiber.Ctx) error {
+	if err := h.svc.Delete(c.Params("id")); err != nil {
+		return mapRecordingError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func mapRecordingError(c *fiber.Ctx, err error) error {
+	countError(err)
+	if errors.Is(err, core.ErrRecordingNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(JSONErrRecordingNotFound)
+	}
+	if errors.Is(err, core.ErrRecordingRequired) {
+		return c.Status(fiber.StatusForbidden).JSON(JSONErrRecordingRequired)
+	}
+	return fileServiceError(c, err)
+}
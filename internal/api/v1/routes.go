@@ -1,16 +1,19 @@
 package api
 
 import (
+	"context"
 	"io"
 	"os"
 
+	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/khanghh/vscode-server/internal/core"
 )
 
 type LocalFileService interface {
 	Stat(relPath string) (os.FileInfo, error)
 	List(relPath string) ([]os.FileInfo, error)
-	Open(relPath string) (*os.File, os.FileInfo, error)
+	Open(relPath string) (io.ReadSeekCloser, os.FileInfo, error)
 	ReadFile(relPath string) ([]byte, error)
 	WriteFile(relPath string, data []byte, create bool) error
 	SaveStream(relPath string, reader io.Reader, overwrite bool) error
@@ -21,8 +24,120 @@ type LocalFileService interface {
 	DetectMIMEType(relPath string) (string, error)
 }
 
-func SetupRoutes(router fiber.Router, lfs LocalFileService) error {
-	fsHandler := NewFSHandler(lfs)
+type GitService interface {
+	Status(ctx context.Context, repo string) (*core.GitStatus, error)
+	Diff(ctx context.Context, repo, path, against string) ([]byte, error)
+	Show(ctx context.Context, repo, ref, path string, stage int) ([]byte, error)
+	Stage(ctx context.Context, repo string, paths []string, patch string) error
+	Unstage(ctx context.Context, repo string, paths []string, patch string) error
+	Discard(ctx context.Context, repo string, paths []string, untracked bool) error
+	Commit(ctx context.Context, repo, message string, author *core.GitIdentity, amend bool) (string, error)
+	CreateBranch(ctx context.Context, repo, name, startPoint string, checkout bool) error
+	SwitchBranch(ctx context.Context, repo, name string) error
+	DeleteBranch(ctx context.Context, repo, name string, force bool) error
+	Stash(ctx context.Context, repo, message string, includeUntracked bool) error
+	StashPop(ctx context.Context, repo string, index int) error
+	Log(ctx context.Context, repo, ref, path string, skip, limit int) (*core.GitLogPage, error)
+	Blame(ctx context.Context, repo, ref, path string) ([]core.GitBlameRange, error)
+	Conflicts(ctx context.Context, repo string) (*core.GitConflictState, error)
+	ResolveConflict(ctx context.Context, repo, path string, content io.Reader) error
+	MarkResolved(ctx context.Context, repo string, paths []string) error
+	ContinueOperation(ctx context.Context, repo string) error
+	AbortOperation(ctx context.Context, repo string) error
+	DiscoverRepos(ctx context.Context, maxDepth int) ([]core.GitRepoInfo, error)
+	Init(ctx context.Context, path, initialBranch string, out io.Writer) error
+	Clone(ctx context.Context, source, dest string, bare bool, out io.Writer) error
+	CheckQuota() error
+	ListWorktrees(ctx context.Context, repo string) ([]core.GitWorktree, error)
+	AddWorktree(ctx context.Context, repo, path, branch string, newBranch bool, out io.Writer) (string, error)
+	RemoveWorktree(ctx context.Context, repo, path string, force bool, out io.Writer) error
+}
+
+type ExecService interface {
+	Start(ctx context.Context, kind string, req core.ExecRequest, stdout, stderr io.Writer) (*core.ExecProcess, error)
+}
+
+type TerminalService interface {
+	Create(opts core.TerminalOptions) (*core.TerminalSession, error)
+	Get(id string) (*core.TerminalSession, error)
+	List() []core.TerminalInfo
+	Kill(id string) error
+}
+
+type RecordingService interface {
+	List() ([]core.RecordingInfo, error)
+	Open(id string) (*os.File, *core.RecordingInfo, error)
+	Delete(id string) error
+}
+
+type TaskService interface {
+	List(folder string) ([]core.TaskInfo, error)
+	Check(folder, label string) error
+	Run(ctx context.Context, req core.TaskRunRequest, out io.Writer) (*core.TaskResult, error)
+}
+
+type LSPService interface {
+	Servers() []core.LanguageServerInfo
+	Connect(server, folder string) (*core.LSPClient, error)
+}
+
+type DebugService interface {
+	Configurations(folder string) ([]core.LaunchConfigInfo, error)
+	Start(req core.DebugStartRequest) (*core.DebugSession, error)
+}
+
+type PortPolicy interface {
+	Allowed(port int) bool
+}
+
+type EventService interface {
+	Subscribe() (<-chan core.ServerEvent, func())
+}
+
+type PortService interface {
+	List() []core.ListeningPort
+}
+
+type ConfigReloader interface {
+	Reload() (*ConfigReload, error)
+}
+
+type ProcessService interface {
+	List(sessionID string) ([]*core.ProcessInfo, error)
+	Get(pid int) (*core.ProcessInfo, error)
+	Trees() ([]core.ProcessTree, error)
+	Signal(pid int, signal, confirm string) (*core.SignalConfirmation, error)
+}
+
+type HostMetricsService interface {
+	Latest() (core.HostSample, error)
+	History() []core.HostSample
+	Subscribe() (<-chan core.HostSample, func())
+}
+
+type FileIOStats interface {
+	IOStats() (read, written int64)
+}
+
+type SessionCounter interface {
+	Active() int
+}
+
+type JobService interface {
+	Start(kind, description string, fn core.JobFunc) *core.Job
+	Get(id string) (*core.Job, error)
+	List() []core.JobInfo
+	Cancel(id string) error
+}
+
+type GitTreeService interface {
+	StatAt(ctx context.Context, relPath, ref string) (os.FileInfo, error)
+	ListAt(ctx context.Context, relPath, ref string) ([]os.FileInfo, error)
+	ReadFileAt(ctx context.Context, relPath, ref string) ([]byte, error)
+}
+
+func SetupRoutes(router fiber.Router, lfs LocalFileService, git GitTreeService) error {
+	fsHandler := NewFSHandler(lfs, git)
 	api := router.Group("/api/v1")
 	// File system
 	api.Get("/fs/*", fsHandler.Get)
@@ -31,3 +146,144 @@ func SetupRoutes(router fiber.Router, lfs LocalFileService) error {
 	api.Delete("/fs/*", fsHandler.Delete)
 	return nil
 }
+
+func SetupGitRoutes(router fiber.Router, svc GitService, jobs JobService) error {
+	gitHandler := NewGitHandler(svc, jobs)
+	api := router.Group("/api/v1/git")
+	api.Get("/status", gitHandler.Status)
+	api.Get("/diff", gitHandler.Diff)
+	api.Get("/show", gitHandler.Show)
+	api.Get("/log", gitHandler.Log)
+	api.Get("/blame", gitHandler.Blame)
+	api.Post("/stage", gitHandler.Stage)
+	api.Post("/unstage", gitHandler.Unstage)
+	api.Post("/discard", gitHandler.Discard)
+	api.Post("/commit", gitHandler.Commit)
+	api.Post("/branches", gitHandler.CreateBranch)
+	api.Delete("/branches/*", gitHandler.DeleteBranch)
+	api.Post("/checkout", gitHandler.SwitchBranch)
+	api.Post("/stash", gitHandler.Stash)
+	api.Post("/stash/pop", gitHandler.StashPop)
+	api.Get("/conflicts", gitHandler.Conflicts)
+	api.Post("/conflicts/resolve", gitHandler.ResolveConflict)
+	api.Post("/conflicts/mark", gitHandler.MarkResolved)
+	api.Post("/continue", gitHandler.Continue)
+	api.Post("/abort", gitHandler.Abort)
+	api.Get("/repos", gitHandler.Repos)
+	api.Post("/init", gitHandler.Init)
+	api.Post("/clone", gitHandler.Clone)
+	api.Get("/worktrees", gitHandler.Worktrees)
+	api.Post("/worktrees", gitHandler.AddWorktree)
+	api.Delete("/worktrees", gitHandler.RemoveWorktree)
+	return nil
+}
+
+func SetupJobRoutes(router fiber.Router, jobs JobService) error {
+	jobsHandler := NewJobsHandler(jobs)
+	api := router.Group("/api/v1/jobs")
+	api.Get("/", jobsHandler.List)
+	api.Get("/:id", jobsHandler.Get)
+	api.Get("/:id/output", jobsHandler.Output)
+	api.Delete("/:id", jobsHandler.Cancel)
+	return nil
+}
+
+func SetupExecRoutes(router fiber.Router, svc ExecService) error {
+	execHandler := NewExecHandler(svc)
+	router.Get("/api/v1/exec", upgradeWebSocket, websocket.New(trackWebSocket("exec", execHandler.Exec)))
+	return nil
+}
+
+func SetupTerminalRoutes(router fiber.Router, svc TerminalService) error {
+	terminalHandler := NewTerminalHandler(svc)
+	api := router.Group("/api/v1/terminals")
+	api.Get("/", terminalHandler.List)
+	api.Post("/", terminalHandler.Create)
+	api.Get("/:id", terminalHandler.Get)
+	api.Patch("/:id", terminalHandler.Update)
+	api.Delete("/:id", terminalHandler.Kill)
+	api.Get("/:id/attach", upgradeWebSocket, terminalHandler.Exists, websocket.New(trackWebSocket("terminal", terminalHandler.Attach), websocket.Config{Subprotocols: []string{terminalSubprotocol}}))
+	return nil
+}
+
+func SetupRecordingRoutes(router fiber.Router, svc RecordingService) error {
+	recordingsHandler := NewRecordingsHandler(svc)
+	api := router.Group("/api/v1/recordings")
+	api.Get("/", recordingsHandler.List)
+	api.Get("/:id", recordingsHandler.Get)
+	api.Delete("/:id", recordingsHandler.Delete)
+	return nil
+}
+
+func SetupTaskRoutes(router fiber.Router, svc TaskService, jobs JobService) error {
+	tasksHandler := NewTasksHandler(svc, jobs)
+	api := router.Group("/api/v1/tasks")
+	api.Get("/", tasksHandler.List)
+	api.Post("/run", tasksHandler.Run)
+	return nil
+}
+
+func SetupLSPRoutes(router fiber.Router, svc LSPService) error {
+	lspHandler := NewLSPHandler(svc)
+	api := router.Group("/api/v1/lsp")
+	api.Get("/", lspHandler.Servers)
+	api.Get("/:server", upgradeWebSocket, lspHandler.Exists, websocket.New(trackWebSocket("lsp", lspHandler.Connect)))
+	return nil
+}
+
+func SetupDebugRoutes(router fiber.Router, svc DebugService) error {
+	debugHandler := NewDebugHandler(svc)
+	api := router.Group("/api/v1/debug")
+	api.Get("/configurations", debugHandler.Configurations)
+	api.Get("/session", upgradeWebSocket, debugHandler.Start, websocket.New(trackWebSocket("debug", debugHandler.Session)))
+	return nil
+}
+
+// SetupProxyRoutes forwards /proxy/<port>/ and, when domain is set, <port>.<domain> to local
+// ports. It must be set up before the static frontend so that subdomain requests reach it.
+func SetupProxyRoutes(router fiber.Router, ports PortPolicy, domain string) error {
+	proxyHandler := NewProxyHandler(ports, domain)
+	if domain != "" {
+		router.Use(proxyHandler.Subdomain)
+	}
+	router.All("/proxy/:port/*", proxyHandler.Path)
+	return nil
+}
+
+func SetupEventRoutes(router fiber.Router, events EventService) error {
+	eventsHandler := NewEventsHandler(events)
+	router.Get("/api/v1/events", eventsHandler.Stream)
+	return nil
+}
+
+func SetupPortRoutes(router fiber.Router, svc PortService) error {
+	portsHandler := NewPortsHandler(svc)
+	router.Get("/api/v1/ports", portsHandler.List)
+	return nil
+}
+
+func SetupProcessRoutes(router fiber.Router, svc ProcessService) error {
+	processesHandler := NewProcessesHandler(svc)
+	api := router.Group("/api/v1/processes")
+	api.Get("/", processesHandler.List)
+	api.Get("/tree", processesHandler.Trees)
+	api.Get("/:pid", processesHandler.Get)
+	api.Post("/:pid/signal", processesHandler.Signal)
+	return nil
+}
+
+func SetupHostRoutes(router fiber.Router, svc HostMetricsService) error {
+	hostHandler := NewHostHandler(svc)
+	api := router.Group("/api/v1/host")
+	api.Get("/", hostHandler.Latest)
+	api.Get("/history", hostHandler.History)
+	api.Get("/stream", hostHandler.Stream)
+	return nil
+}
+
+func SetupAdminRoutes(router fiber.Router, config ConfigReloader) error {
+	adminHandler := NewAdminHandler(config)
+	api := router.Group("/api/v1/admin")
+	api.Post("/reload", adminHandler.Reload)
+	return nil
+}
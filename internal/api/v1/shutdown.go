@@ -0,0 +1,55 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+)
+
+var (
+	shutdownOnce sync.Once
+	// shuttingDown is closed when the server starts shutting down, ending server-sent event streams.
+	shuttingDown = make(chan struct{})
+
+	openSocketsMu sync.Mutex
+	openSockets   = make(map[*websocket.Conn]struct{})
+)
+
+// Shutdown tells the WebSocket clients that the server is going away and ends the server-sent
+// event streams, so they do not hold up draining the server. WebSocket connections opened
+// afterwards are closed right away.
+func Shutdown() {
+	shutdownOnce.Do(func() {
+		openSocketsMu.Lock()
+		defer openSocketsMu.Unlock()
+		close(shuttingDown)
+		for conn := range openSockets {
+			goAway(conn)
+		}
+	})
+}
+
+// goAway sends a going away close frame and gives the handler a second to see the client's
+// close frame before its reads fail.
+func goAway(conn *websocket.Conn) {
+	closeWebSocket(conn, websocket.CloseGoingAway, "server shutting down")
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+}
+
+// registerWebSocket tracks an open connection until the returned function is called.
+func registerWebSocket(conn *websocket.Conn) func() {
+	openSocketsMu.Lock()
+	defer openSocketsMu.Unlock()
+	select {
+	case <-shuttingDown:
+		goAway(conn)
+	default:
+	}
+	openSockets[conn] = struct{}{}
+	return func() {
+		openSocketsMu.Lock()
+		delete(openSockets, conn)
+		openSocketsMu.Unlock()
+	}
+}
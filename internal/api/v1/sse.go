@@ -0,0 +1,45 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+var errServerShuttingDown = errors.New("server shutting down")
+
+// sseSendFunc sends one server-sent event. It fails once the client has gone away.
+type sseSendFunc func(event string, data any) error
+
+// streamSSE responds with a text/event-stream and runs fn to produce events.
+// fn runs after the handler returns and must not use c. It must return once shuttingDown is
+// closed, which send reports as an error as well.
+func streamSSE(c *fiber.Ctx, fn func(send sseSendFunc)) error {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		fn(func(event string, data any) error {
+			select {
+			case <-shuttingDown:
+				return errServerShuttingDown
+			default:
+			}
+			payload, err := json.Marshal(data)
+			if err != nil {
+				return err
+			}
+			if event != "" {
+				fmt.Fprintf(w, "event: %s\n", event)
+			}
+			fmt.Fprintf(w, "data: %s\n\n", payload)
+			return w.Flush()
+		})
+	}))
+	return nil
+}
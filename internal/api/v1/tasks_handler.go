@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/khanghh/vscode-server/internal/core"
+)
+
+// taskErrorCodes maps task runner failures to the error code returned to clients.
+var taskErrorCodes = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{core.ErrNoTasksFile, fiber.StatusNotFound, "NO_TASKS_FILE"},
+	{core.ErrInvalidTasksFile, fiber.StatusUnprocessableEntity, "INVALID_TASKS_FILE"},
+	{core.ErrTaskNotFound, fiber.StatusNotFound, "TASK_NOT_FOUND"},
+	{core.ErrTaskCycle, fiber.StatusUnprocessableEntity, "TASK_CYCLE"},
+}
+
+// TasksHandler runs tasks.json tasks under /api/v1/tasks.
+type TasksHandler struct {
+	svc  TaskService
+	jobs JobService
+}
+
+func NewTasksHandler(svc TaskService, jobs JobService) *TasksHandler {
+	return &TasksHandler{svc: svc, jobs: jobs}
+}
+
+// GET /api/v1/tasks?folder=<workspace folder>
+func (h *TasksHandler) List(c *fiber.Ctx) error {
+	tasks, err := h.svc.List(c.Query("folder"))
+	if err != nil {
+		return mapTaskError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(tasks)
+}
+
+// POST /api/v1/tasks/run {folder, label, file}
+// Runs the task and its dependencies as a background job. The task output is the job output,
+// the job result is {"tasks": [...], "diagnostics": [...]} and is set even if a task fails.
+func (h *TasksHandler) Run(c *fiber.Ctx) error {
+	var body core.TaskRunRequest
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if body.Label == "" {
+		return badRequest(c, "missing label")
+	}
+	// report unknown tasks and dependency cycles synchronously
+	if err := h.svc.Check(body.Folder, body.Label); err != nil {
+		return mapTaskError(c, err)
+	}
+	return startJob(c, h.jobs, "task", body.Label, func(ctx context.Context, out io.Writer) (any, error) {
+		return h.svc.Run(ctx, body, out)
+	})
+}
+
+func mapTaskError(c *fiber.Ctx, err error) error {
+	countError(err)
+	for _, e := range taskErrorCodes {
+		if errors.Is(err, e.err) {
+			return c.Status(e.status).JSON(fiber.Map{"error": err.Error(), "code": e.code})
+		}
+	}
+	if errors.Is(err, core.ErrPathTraversal) {
+		return c.Status(fiber.StatusForbidden).JSON(JSONErrNoPermissions)
+	}
+	return fileServiceError(c, err)
+}
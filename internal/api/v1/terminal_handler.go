@@ -0,0 +1,259 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/khanghh/vscode-server/internal/core"
+)
+
+var (
+	JSONErrTerminalNotFound = fiber.Map{
+		"error": "terminal not found",
+		"code":  "TERMINAL_NOT_FOUND",
+	}
+	JSONErrNotTerminalOwner = fiber.Map{
+		"error": "only the owner of the terminal may do this",
+		"code":  "NOT_TERMINAL_OWNER",
+	}
+)
+
+// terminalTokenHeader carries the owner token. Browsers cannot set headers on WebSocket
+// requests, so attaching clients may instead offer the terminalSubprotocol together with the
+// token as a subprotocol prefixed with terminalTokenProtocol. The token is never taken from the
+// query, which ends up in access logs.
+const (
+	terminalTokenHeader   = "X-Terminal-Token"
+	terminalSubprotocol   = "vscode-terminal"
+	terminalTokenProtocol = "terminal-token."
+)
+
+// attachToken returns the owner token of an attach request.
+func attachToken(c *fiber.Ctx) string {
+	if token := c.Get(terminalTokenHeader); token != "" {
+		return token
+	}
+	for _, p := range strings.Split(c.Get(fiber.HeaderSecWebSocketProtocol), ",") {
+		if token, ok := strings.CutPrefix(strings.TrimSpace(p), terminalTokenProtocol); ok {
+			return token
+		}
+	}
+	return ""
+}
+
+// terminalMessage is a control frame of the terminal WebSocket protocol sent by the client,
+// or an "exit" or "error" frame sent by the server.
+type terminalMessage struct {
+	Type     string `json:"type"`
+	Data     string `json:"data,omitempty"`
+	Cols     uint16 `json:"cols,omitempty"`
+	Rows     uint16 `json:"rows,omitempty"`
+	ExitCode *int   `json:"exitCode,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// terminalCreated is the response to creating a terminal. The owner token is only disclosed here.
+type terminalCreated struct {
+	core.TerminalInfo
+	OwnerToken string `json:"ownerToken"`
+}
+
+// TerminalHandler manages persistent terminal sessions under /api/v1/terminals.
+type TerminalHandler struct {
+	svc TerminalService
+}
+
+func NewTerminalHandler(svc TerminalService) *TerminalHandler {
+	return &TerminalHandler{svc: svc}
+}
+
+// GET /api/v1/terminals
+func (h *TerminalHandler) List(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(h.svc.List())
+}
+
+// POST /api/v1/terminals
+// Body: {"command": [...], "cwd": "<rel>", "env": {...}, "cols": <n>, "rows": <n>, "record": <bool>,
+// "guestAccess": "read"|"write"}
+// Starts a new session; the command defaults to the login shell. The response carries the
+// owner token, which grants the owner role when attaching and is required to change or kill the session.
+func (h *TerminalHandler) Create(c *fiber.Ctx) error {
+	var body core.TerminalOptions
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return badRequest(c, "invalid json")
+		}
+	}
+	t, err := h.svc.Create(body)
+	if err != nil {
+		return mapTerminalError(c, err)
+	}
+	return c.Status(fiber.StatusCreated).JSON(terminalCreated{TerminalInfo: t.Info(), OwnerToken: t.OwnerToken()})
+}
+
+// GET /api/v1/terminals/:id
+func (h *TerminalHandler) Get(c *fiber.Ctx) error {
+	t, err := h.svc.Get(c.Params("id"))
+	if err != nil {
+		return mapTerminalError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(t.Info())
+}
+
+// PATCH /api/v1/terminals/:id {"guestAccess": "read"|"write"}
+// Changes whether guests may type. Requires the owner token in the X-Terminal-Token header.
+func (h *TerminalHandler) Update(c *fiber.Ctx) error {
+	t, err := h.svc.Get(c.Params("id"))
+	if err != nil {
+		return mapTerminalError(c, err)
+	}
+	if !t.Authorize(c.Get(terminalTokenHeader)) {
+		return c.Status(fiber.StatusForbidden).JSON(JSONErrNotTerminalOwner)
+	}
+	var body struct {
+		GuestAccess string `json:"guestAccess"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return badRequest(c, "invalid json")
+	}
+	if err := t.SetGuestAccess(body.GuestAccess); err != nil {
+		return mapTerminalError(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(t.Info())
+}
+
+// DELETE /api/v1/terminals/:id
+// Terminates the session. Requires the owner token in the X-Terminal-Token header.
+func (h *TerminalHandler) Kill(c *fiber.Ctx) error {
+	t, err := h.svc.Get(c.Params("id"))
+	if err != nil {
+		return mapTerminalError(c, err)
+	}
+	if !t.Authorize(c.Get(terminalTokenHeader)) {
+		return c.Status(fiber.StatusForbidden).JSON(JSONErrNotTerminalOwner)
+	}
+	if err := h.svc.Kill(c.Params("id")); err != nil {
+		return mapTerminalError(c, err)
+	}
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// Exists rejects attach requests for unknown sessions before the WebSocket upgrade and keeps
+// the attach options, which are not reachable from the WebSocket connection.
+func (h *TerminalHandler) Exists(c *fiber.Ctx) error {
+	if _, err := h.svc.Get(c.Params("id")); err != nil {
+		return mapTerminalError(c, err)
+	}
+	name := c.Query("name")
+	if name == "" {
+		name = c.Get("X-Forwarded-User")
+	}
+	c.Locals("attach", core.TerminalAttachOptions{
+		Name:     name,
+		Token:    attachToken(c),
+		ReadOnly: c.QueryBool("readonly"),
+		Cols:     uint16(c.QueryInt("cols")),
+		Rows:     uint16(c.QueryInt("rows")),
+	})
+	return c.Next()
+}
+
+// GET /api/v1/terminals/:id/attach?name=<name>&readonly=<bool>&cols=<n>&rows=<n> (WebSocket)
+// Any number of clients may attach to a session. With the owner token in the X-Terminal-Token
+// header, or the subprotocols "vscode-terminal" and "terminal-token.<owner token>", the client
+// joins as owner (read-write), otherwise as guest with the access granted by the session.
+// The server first replays the scrollback, then streams terminal output as binary frames.
+// Session changes are sent as JSON frames: "participants" (the current participants and who the
+// client is), "join", "leave", "update" (a participant's access changed) and "resize" (the
+// negotiated size, the smallest among the viewers). When the process exits it sends
+// {"type": "exit", "exitCode": <n>} and closes the socket.
+// The client sends {"type": "input", "data": "..."} or binary frames for input and
+// {"type": "resize", "cols": <n>, "rows": <n>} with its own window size. Closing the socket
+// detaches without stopping the session.
+func (h *TerminalHandler) Attach(conn *websocket.Conn) {
+	t, err := h.svc.Get(conn.Params("id"))
+	if err != nil {
+		closeWebSocket(conn, websocket.ClosePolicyViolation, err.Error())
+		return
+	}
+	opts, _ := conn.Locals("attach").(core.TerminalAttachOptions)
+	scrollback, client := t.Attach(opts)
+
+	var writeMu sync.Mutex
+	write := func(messageType int, data []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(messageType, data)
+	}
+
+	defer startWriter(client.Detach, func() {
+		if len(scrollback) > 0 {
+			if err := write(websocket.BinaryMessage, scrollback); err != nil {
+				return
+			}
+		}
+		for ev := range client.Events() {
+			var err error
+			if ev.Type == "output" {
+				err = write(websocket.BinaryMessage, ev.Data)
+			} else {
+				data, _ := json.Marshal(ev)
+				err = write(websocket.TextMessage, data)
+			}
+			if err != nil {
+				return
+			}
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		select {
+		case <-t.Done():
+			code := t.Info().ExitCode
+			conn.WriteJSON(terminalMessage{Type: "exit", ExitCode: &code})
+			closeWebSocket(conn, websocket.CloseNormalClosure, "")
+		default:
+			// detached for falling behind or going away: the client may reattach
+			closeWebSocket(conn, websocket.CloseTryAgainLater, "client too slow")
+		}
+	})()
+
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg terminalMessage
+		if messageType == websocket.BinaryMessage {
+			msg = terminalMessage{Type: "input", Data: string(data)}
+		} else if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		switch msg.Type {
+		case "input":
+			if _, err := client.Write([]byte(msg.Data)); errors.Is(err, core.ErrTerminalReadOnly) {
+				resp, _ := json.Marshal(terminalMessage{Type: "error", Error: err.Error()})
+				write(websocket.TextMessage, resp)
+			}
+		case "resize":
+			client.Resize(msg.Cols, msg.Rows)
+		}
+	}
+}
+
+func mapTerminalError(c *fiber.Ctx, err error) error {
+	countError(err)
+	if errors.Is(err, core.ErrTerminalNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(JSONErrTerminalNotFound)
+	}
+	if errors.Is(err, core.ErrPathTraversal) {
+		return c.Status(fiber.StatusForbidden).JSON(JSONErrNoPermissions)
+	}
+	if errors.Is(err, core.ErrNotDirectory) || errors.Is(err, core.ErrInvalidGuestAccess) {
+		return badRequest(c, err.Error())
+	}
+	return fileServiceError(c, err)
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records to the global tracer provider, a no-op unless tracing is configured.
+var tracer = otel.Tracer("github.com/khanghh/vscode-server/internal/api/v1")
+
+// requestHeaderCarrier reads and writes trace context in fasthttp request headers.
+type requestHeaderCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (c requestHeaderCarrier) Get(key string) string {
+	return string(c.header.Peek(key))
+}
+
+func (c requestHeaderCarrier) Set(key, value string) {
+	c.header.Set(key, value)
+}
+
+func (c requestHeaderCarrier) Keys() []string {
+	var keys []string
+	c.header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// responseStatus returns the status of a response. The error handler sets the status of
+// errors only after the middleware chain has returned.
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	var e *fiber.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// TracingMiddleware starts a server span for each request, continuing the trace of the
+// incoming traceparent header if there is one. The span is the user context of the request.
+func TracingMiddleware(c *fiber.Ctx) error {
+	ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), requestHeaderCarrier{&c.Request().Header})
+	// c.Method() and c.Path() point into buffers reused by the next request
+	method := strings.Clone(c.Method())
+	ctx, span := tracer.Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", method),
+			attribute.String("url.path", strings.Clone(c.Path())),
+		),
+	)
+	defer span.End()
+	c.SetUserContext(ctx)
+
+	err := c.Next()
+	status := responseStatus(c, err)
+	route := c.Route().Path
+	span.SetName(method + " " + route)
+	span.SetAttributes(
+		attribute.String("http.route", route),
+		attribute.Int("http.response.status_code", status),
+	)
+	if status >= fiber.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	return err
+}
+
+// startSpan starts a span for a handler under the request span and makes it the user context
+// of the request, so that the services the handler calls nest under it.
+func startSpan(c *fiber.Ctx, name string, attrs ...attribute.KeyValue) trace.Span {
+	ctx, span := tracer.Start(c.UserContext(), name, trace.WithAttributes(attrs...))
+	c.SetUserContext(ctx)
+	return span
+}
+
+// endSpan ends the span of a handler with the status of its response.
+func endSpan(c *fiber.Ctx, span trace.Span) {
+	status := c.Response().StatusCode()
+	span.SetAttributes(attribute.Int("http.response.status_code", status))
+	if status >= fiber.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// tracedFileService records a span for each call to the file service under the span of ctx.
+type tracedFileService struct {
+	svc LocalFileService
+	ctx context.Context
+}
+
+// traceFileService returns svc recording spans under the span of ctx, or svc itself when
+// that span is not recorded.
+func traceFileService(ctx context.Context, svc LocalFileService) LocalFileService {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return svc
+	}
+	return &tracedFileService{svc: svc, ctx: ctx}
+}
+
+func (t *tracedFileService) start(op, relPath string) trace.Span {
+	_, span := tracer.Start(t.ctx, "LocalFileService."+op, trace.WithAttributes(attribute.String("file.path", relPath)))
+	return span
+}
+
+// endFileSpan records the result of a file service call and ends its span.
+func endFileSpan(span trace.Span, err error) {
+	result := "ok"
+	if err != nil {
+		result = errorName(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.SetAttributes(attribute.String("result", result))
+	span.End()
+}
+
+func (t *tracedFileService) Stat(relPath string) (os.FileInfo, error) {
+	span := t.start("Stat", relPath)
+	fi, err := t.svc.Stat(relPath)
+	if err == nil {
+		span.SetAttributes(attribute.Int64("file.size", fi.Size()), attribute.Bool("file.directory", fi.IsDir()))
+	}
+	endFileSpan(span, err)
+	return fi, err
+}
+
+func (t *tracedFileService) List(relPath string) ([]os.FileInfo, error) {
+	span := t.start("List", relPath)
+	items, err := t.svc.List(relPath)
+	span.SetAttributes(attribute.Int("file.entries", len(items)))
+	endFileSpan(span, err)
+	return items, err
+}
+
+func (t *tracedFileService) Open(relPath string) (io.ReadSeekCloser, os.FileInfo, error) {
+	span := t.start("Open", relPath)
+	f, fi, err := t.svc.Open(relPath)
+	if err == nil {
+		span.SetAttributes(attribute.Int64("file.size", fi.Size()))
+	}
+	endFileSpan(span, err)
+	return f, fi, err
+}
+
+func (t *tracedFileService) ReadFile(relPath string) ([]byte, error) {
+	span := t.start("ReadFile", relPath)
+	data, err := t.svc.ReadFile(relPath)
+	span.SetAttributes(attribute.Int("file.size", len(data)))
+	endFileSpan(span, err)
+	return data, err
+}
+
+func (t *tracedFileService) WriteFile(relPath string, data []byte, create bool) error {
+	span := t.start("WriteFile", relPath)
+	span.SetAttributes(attribute.Int("file.size", len(data)), attribute.Bool("create", create))
+	err := t.svc.WriteFile(relPath, data, create)
+	endFileSpan(span, err)
+	return err
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (t *tracedFileService) SaveStream(relPath string, reader io.Reader, overwrite bool) error {
+	span := t.start("SaveStream", relPath)
+	counter := &countingReader{r: reader}
+	err := t.svc.SaveStream(relPath, counter, overwrite)
+	span.SetAttributes(attribute.Int64("file.size", counter.n), attribute.Bool("overwrite", overwrite))
+	endFileSpan(span, err)
+	return err
+}
+
+func (t *tracedFileService) Delete(relPath string) error {
+	span := t.start("Delete", relPath)
+	err := t.svc.Delete(relPath)
+	endFileSpan(span, err)
+	return err
+}
+
+func (t *tracedFileService) DeleteRecursive(relPath string) error {
+	span := t.start("DeleteRecursive", relPath)
+	err := t.svc.DeleteRecursive(relPath)
+	endFileSpan(span, err)
+	return err
+}
+
+func (t *tracedFileService) MkdirAll(relPath string) error {
+	span := t.start("MkdirAll", relPath)
+	err := t.svc.MkdirAll(relPath)
+	endFileSpan(span, err)
+	return err
+}
+
+func (t *tracedFileService) Rename(oldRelPath, newRelPath string, overwrite bool) error {
+	span := t.start("Rename", oldRelPath)
+	span.SetAttributes(attribute.String("file.new_path", newRelPath), attribute.Bool("overwrite", overwrite))
+	err := t.svc.Rename(oldRelPath, newRelPath, overwrite)
+	endFileSpan(span, err)
+	return err
+}
+
+func (t *tracedFileService) DetectMIMEType(relPath string) (string, error) {
+	span := t.start("DetectMIMEType", relPath)
+	mime, err := t.svc.DetectMIMEType(relPath)
+	span.SetAttributes(attribute.String("file.mime_type", mime))
+	endFileSpan(span, err)
+	return mime, err
+}
@@ -0,0 +1,516 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNoLaunchFile         = errors.New("no .vscode/launch.json in workspace folder")
+	ErrInvalidLaunchFile    = errors.New("invalid launch.json")
+	ErrLaunchConfigNotFound = errors.New("launch configuration not found")
+	ErrUnknownDebugAdapter  = errors.New("no debug adapter for this configuration type")
+	ErrDebugSessionClosed   = errors.New("debug session closed")
+	ErrDebugClientTooSlow   = errors.New("debug client too slow")
+)
+
+const (
+	debugClientQueue      = 1024             // messages buffered for the client
+	debugAdapterDialLimit = 10 * time.Second // time a TCP adapter gets to start listening
+	debugDisconnectWait   = 3 * time.Second  // time the adapter gets to end the session cleanly
+	debugPortPlaceholder  = "{port}"
+	debugAdapterAttempts  = 3 // ports tried when another process takes the free port first
+)
+
+// DefaultDebugAdapters are the debug adapters known out of the box, by launch configuration
+// type. Adapters whose command contains {port} speak DAP over TCP on that port, the others
+// over stdio.
+var DefaultDebugAdapters = map[string][]string{
+	"go":      {"dlv", "dap", "--listen=127.0.0.1:" + debugPortPlaceholder},
+	"debugpy": {"python3", "-m", "debugpy.adapter"},
+}
+
+// LaunchConfigInfo describes a configuration of launch.json.
+type LaunchConfigInfo struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Request string `json:"request"`
+}
+
+// DebugStartRequest selects the launch configuration of a debug session.
+type DebugStartRequest struct {
+	Folder string // workspace folder relative to the root
+	Name   string // name of the launch configuration
+	File   string // active file relative to the root, for the ${file} variables
+}
+
+// DebugService runs debug adapters for the launch configurations of workspace folders.
+type DebugService struct {
+	fs       *LocalFileServiceImpl
+	registry *ProcessRegistry
+	tasks    *TaskRunner
+	adapters map[string][]string
+}
+
+func NewDebugService(fs *LocalFileServiceImpl, registry *ProcessRegistry, tasks *TaskRunner, adapters map[string][]string) *DebugService {
+	return &DebugService{fs: fs, registry: registry, tasks: tasks, adapters: adapters}
+}
+
+type launchFile struct {
+	Configurations []map[string]any `json:"configurations"`
+}
+
+func (s *DebugService) load(folder string) (*launchFile, string, error) {
+	dir, err := s.fs.resolve(folder)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".vscode", "launch.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", ErrNoLaunchFile
+		}
+		return nil, "", err
+	}
+	var file launchFile
+	if err := json.Unmarshal(stripJSONC(data), &file); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidLaunchFile, err)
+	}
+	return &file, dir, nil
+}
+
+// Configurations lists the launch configurations of the workspace folder.
+func (s *DebugService) Configurations(folder string) ([]LaunchConfigInfo, error) {
+	file, _, err := s.load(folder)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]LaunchConfigInfo, 0, len(file.Configurations))
+	for _, c := range file.Configurations {
+		name, _ := c["name"].(string)
+		typ, _ := c["type"].(string)
+		request, _ := c["request"].(string)
+		out = append(out, LaunchConfigInfo{Name: name, Type: typ, Request: request})
+	}
+	return out, nil
+}
+
+// Start begins a debug session for a launch configuration. The adapter is started in the
+// background; messages sent before it is ready are queued.
+func (s *DebugService) Start(req DebugStartRequest) (*DebugSession, error) {
+	file, dir, err := s.load(req.Folder)
+	if err != nil {
+		return nil, err
+	}
+	var config map[string]any
+	for _, c := range file.Configurations {
+		if c["name"] == req.Name {
+			config = c
+			break
+		}
+	}
+	if config == nil {
+		return nil, ErrLaunchConfigNotFound
+	}
+	typ, _ := config["type"].(string)
+	command, ok := s.adapters[typ]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDebugAdapter, typ)
+	}
+	vars, err := newWorkspaceVariables(s.fs, dir, req.File)
+	if err != nil {
+		return nil, err
+	}
+	root, _ := s.fs.resolve("")
+	ctx, cancel := context.WithCancel(context.Background())
+	sess := &DebugSession{
+		ID:       uuid.NewString(),
+		service:  s,
+		folder:   req.Folder,
+		dir:      dir,
+		command:  command,
+		config:   expandVariables(config, vars).(map[string]any),
+		paths:    uriRewriter{root: root},
+		ctx:      ctx,
+		cancel:   cancel,
+		messages: make(chan []byte, debugClientQueue),
+		closed:   make(chan struct{}),
+	}
+	go sess.run()
+	return sess, nil
+}
+
+// expandVariables resolves variables in every string of a launch configuration.
+func expandVariables(v any, vars *workspaceVariables) any {
+	return rewriteStrings(v, func(s string) string { return vars.expand(s) })
+}
+
+// DebugSession tunnels DAP messages between a client and a debug adapter.
+type DebugSession struct {
+	ID string
+
+	service *DebugService
+	folder  string
+	dir     string
+	command []string
+	config  map[string]any
+	paths   uriRewriter
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu       sync.Mutex
+	adapter  io.WriteCloser // nil until the adapter is connected
+	queue    []rpcMessage   // client messages waiting for the adapter
+	cmd      *exec.Cmd
+	exited   chan struct{}
+	messages chan []byte
+	closed   chan struct{}
+	once     sync.Once
+	err      error // why the session ended, if not closed by the client
+}
+
+// Messages returns the DAP messages for the client. The channel is closed when the session ends.
+func (d *DebugSession) Messages() <-chan []byte {
+	return d.messages
+}
+
+// Err returns ErrDebugClientTooSlow if the session ended because the client fell behind.
+func (d *DebugSession) Err() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.err
+}
+
+// run runs the preLaunchTask, starts the adapter and pumps its messages to the client.
+func (d *DebugSession) run() {
+	if task, _ := d.config["preLaunchTask"].(string); task != "" && d.service.tasks != nil {
+		out := &debugOutput{session: d, category: "console"}
+		if _, err := d.service.tasks.Run(d.ctx, TaskRunRequest{Folder: d.folder, Label: task}, out); err != nil {
+			d.failStart(fmt.Errorf("preLaunchTask %q failed: %w", task, err))
+			return
+		}
+	}
+	r, err := d.startAdapter()
+	if err != nil {
+		d.failStart(err)
+		return
+	}
+	d.mu.Lock()
+	for _, msg := range d.queue {
+		d.sendLocked(msg)
+	}
+	d.queue = nil
+	d.mu.Unlock()
+
+	for {
+		data, err := readRPCFrame(r)
+		if err != nil {
+			break
+		}
+		msg, err := decodeRPCMessage(data)
+		if err != nil {
+			continue
+		}
+		d.deliver(rewriteDebugPaths(msg, d.paths.fromLocalPath).(rpcMessage))
+	}
+	d.Close()
+}
+
+// startAdapter launches the adapter process and returns the stream of its messages. The port
+// of a TCP adapter may be taken by another process between freePort and the adapter binding
+// it, so the adapter is started again on a new port when it exits because of that.
+func (d *DebugSession) startAdapter() (*bufio.Reader, error) {
+	for attempt := 1; ; attempt++ {
+		r, err := d.launchAdapter()
+		if errors.Is(err, errDebugPortInUse) && attempt < debugAdapterAttempts {
+			slog.Debug("Debug adapter port taken, retrying", "id", d.ID, "attempt", attempt)
+			continue
+		}
+		return r, err
+	}
+}
+
+// errDebugPortInUse is returned by launchAdapter when the adapter could not listen on its port.
+var errDebugPortInUse = errors.New("debug adapter port is in use")
+
+func (d *DebugSession) launchAdapter() (*bufio.Reader, error) {
+	argv := append([]string(nil), d.command...)
+	port := 0
+	for i, arg := range argv {
+		if strings.Contains(arg, debugPortPlaceholder) {
+			if port == 0 {
+				var err error
+				if port, err = freePort(); err != nil {
+					return nil, err
+				}
+			}
+			argv[i] = strings.ReplaceAll(arg, debugPortPlaceholder, strconv.Itoa(port))
+		}
+	}
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = d.dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// over TCP the adapter's own output is the output of the debuggee, the start of it is kept
+	// to tell why the adapter did not listen
+	stdoutHead, stderrHead := &limitedBuffer{max: 4096}, &limitedBuffer{max: 4096}
+	cmd.Stdout = io.MultiWriter(&debugOutput{session: d, category: "stdout"}, stdoutHead)
+	cmd.Stderr = io.MultiWriter(&debugOutput{session: d, category: "stderr"}, stderrHead)
+	var stdin io.WriteCloser
+	var stdout io.Reader
+	if port == 0 {
+		cmd.Stderr = lspStderr(argv[0])
+		cmd.Stdout = nil
+		var err error
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return nil, err
+		}
+		if stdout, err = cmd.StdoutPipe(); err != nil {
+			return nil, err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	exited := make(chan struct{})
+	d.service.registry.Add(SessionProcess{
+		PID:       cmd.Process.Pid,
+		Kind:      ProcessKindDebug,
+		SessionID: d.ID,
+		Command:   argv,
+		Cwd:       d.dir,
+		StartedAt: time.Now(),
+	})
+	go func() {
+		cmd.Wait()
+		d.service.registry.Remove(cmd.Process.Pid)
+		close(exited)
+	}()
+	d.mu.Lock()
+	d.cmd, d.exited = cmd, exited
+	d.mu.Unlock()
+	select {
+	case <-d.closed:
+		// the client left while the adapter was starting
+		go terminateGroup(cmd.Process.Pid, exited, killGracePeriod)
+		return nil, ErrDebugSessionClosed
+	default:
+	}
+
+	if port != 0 {
+		conn, err := dialAdapter(d.ctx, port, exited)
+		if err != nil {
+			select {
+			case <-exited:
+				if portInUse(stdoutHead.String()) || portInUse(stderrHead.String()) {
+					return nil, errDebugPortInUse
+				}
+			default:
+			}
+			return nil, err
+		}
+		stdin, stdout = conn, conn
+	}
+	go func() {
+		<-exited
+		d.Close()
+	}()
+	d.mu.Lock()
+	d.adapter = stdin
+	d.mu.Unlock()
+	return bufio.NewReader(stdout), nil
+}
+
+// portInUse reports whether the output of an adapter says it could not listen on its port.
+func portInUse(output string) bool {
+	output = strings.ToLower(output)
+	return strings.Contains(output, "address already in use") || strings.Contains(output, "eaddrinuse")
+}
+
+// freePort asks the kernel for an unused local TCP port.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// dialAdapter connects to an adapter listening on port, retrying while it starts up.
+func dialAdapter(ctx context.Context, port int, exited <-chan struct{}) (net.Conn, error) {
+	deadline := time.Now().Add(debugAdapterDialLimit)
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("debug adapter did not listen on %s: %w", addr, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-exited:
+			return nil, errors.New("debug adapter exited before accepting connections")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// failStart reports a startup failure to the client and ends the session.
+func (d *DebugSession) failStart(err error) {
+	slog.Warn("Debug session failed to start", "id", d.ID, "error", err)
+	d.event("output", map[string]any{"category": "important", "output": err.Error() + "\n"})
+	d.event("terminated", map[string]any{})
+	d.Close()
+}
+
+// Send forwards a DAP message of the client to the adapter.
+func (d *DebugSession) Send(data []byte) error {
+	msg, err := decodeRPCMessage(data)
+	if err != nil {
+		return err
+	}
+	if msg["type"] == "request" && (msg["command"] == "launch" || msg["command"] == "attach") {
+		// the configuration of launch.json wins over what the client sent
+		args, _ := msg["arguments"].(map[string]any)
+		if args == nil {
+			args = map[string]any{}
+		}
+		for k, v := range d.config {
+			args[k] = v
+		}
+		msg["arguments"] = args
+	}
+	msg = rewriteStrings(msg, d.paths.toLocalPath).(rpcMessage)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.closed:
+		return ErrDebugSessionClosed
+	default:
+	}
+	if d.adapter == nil {
+		d.queue = append(d.queue, msg)
+		return nil
+	}
+	d.sendLocked(msg)
+	return nil
+}
+
+func (d *DebugSession) sendLocked(msg rpcMessage) {
+	if err := writeRPCFrame(d.adapter, msg); err != nil {
+		slog.Debug("failed to write to debug adapter", "id", d.ID, "error", err)
+	}
+}
+
+// event sends an event originating from the proxy to the client.
+func (d *DebugSession) event(name string, body map[string]any) {
+	d.deliver(rpcMessage{"seq": 0, "type": "event", "event": name, "body": body})
+}
+
+func (d *DebugSession) deliver(msg rpcMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.closed:
+		return
+	default:
+	}
+	select {
+	case d.messages <- data:
+	default:
+		// losing messages would desynchronize the client: end the session instead
+		slog.Warn("debug client too slow, closing session", "id", d.ID)
+		d.err = ErrDebugClientTooSlow
+		d.closeLocked()
+	}
+}
+
+// Close ends the session: the adapter is asked to disconnect and terminate the debuggee,
+// then its process group is terminated.
+func (d *DebugSession) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closeLocked()
+}
+
+func (d *DebugSession) closeLocked() {
+	d.once.Do(func() {
+		close(d.closed)
+		close(d.messages)
+		adapter, cmd, exited := d.adapter, d.cmd, d.exited
+		if adapter != nil {
+			d.sendLocked(rpcMessage{"seq": 0, "type": "request", "command": "disconnect", "arguments": map[string]any{"terminateDebuggee": true}})
+		}
+		d.cancel()
+		if cmd == nil {
+			return
+		}
+		go func() {
+			select {
+			case <-exited:
+			case <-time.After(debugDisconnectWait):
+			}
+			if adapter != nil {
+				adapter.Close()
+			}
+			terminateGroup(cmd.Process.Pid, exited, killGracePeriod)
+		}()
+	})
+}
+
+// rewriteDebugPaths applies fn to the "path" properties of DAP messages, which carry the
+// paths of sources.
+func rewriteDebugPaths(v any, fn func(string) string) any {
+	switch v := v.(type) {
+	case []any:
+		for i := range v {
+			v[i] = rewriteDebugPaths(v[i], fn)
+		}
+	case map[string]any:
+		for k, item := range v {
+			if s, ok := item.(string); ok && k == "path" {
+				v[k] = fn(s)
+			} else {
+				v[k] = rewriteDebugPaths(item, fn)
+			}
+		}
+	case rpcMessage:
+		rewriteDebugPaths(map[string]any(v), fn)
+	}
+	return v
+}
+
+// debugOutput turns what it is written into DAP output events.
+type debugOutput struct {
+	session  *DebugSession
+	category string
+}
+
+func (w *debugOutput) Write(p []byte) (int, error) {
+	w.session.event("output", map[string]any{"category": w.category, "output": string(p)})
+	return len(p), nil
+}
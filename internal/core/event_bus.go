@@ -0,0 +1,67 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// eventBusQueue is the number of events buffered per subscriber before they are dropped.
+const eventBusQueue = 64
+
+// Types of server events.
+const (
+	EventPortOpened = "port-opened"
+	EventPortClosed = "port-closed"
+)
+
+// ServerEvent is a notification pushed to every connected client.
+type ServerEvent struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// EventBus fans server events out to subscribers.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan ServerEvent]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan ServerEvent]struct{})}
+}
+
+// Publish sends an event to every subscriber. Slow subscribers miss events rather than
+// blocking the publisher. A nil bus ignores the call.
+func (b *EventBus) Publish(typ string, data any) {
+	if b == nil {
+		return
+	}
+	ev := ServerEvent{Type: typ, Time: time.Now(), Data: data}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of events published from now on. The channel is closed when
+// unsubscribe is called.
+func (b *EventBus) Subscribe() (<-chan ServerEvent, func()) {
+	ch := make(chan ServerEvent, eventBusQueue)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers, ch)
+			close(ch)
+		})
+	}
+}
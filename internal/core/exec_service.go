@@ -0,0 +1,159 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrMissingCommand = errors.New("missing command")
+
+// killGracePeriod is how long a process group gets between SIGTERM and SIGKILL.
+const killGracePeriod = 3 * time.Second
+
+// ExecRequest describes a non-interactive command to run.
+type ExecRequest struct {
+	Argv    []string          `json:"argv"`
+	Cwd     string            `json:"cwd"` // relative to the root
+	Env     map[string]string `json:"env"`
+	Timeout time.Duration     `json:"-"`
+}
+
+// ExecService runs commands inside the root directory in their own process groups.
+type ExecService struct {
+	fs       *LocalFileServiceImpl
+	registry *ProcessRegistry
+}
+
+func NewExecService(fs *LocalFileServiceImpl, registry *ProcessRegistry) *ExecService {
+	return &ExecService{fs: fs, registry: registry}
+}
+
+// ExecProcess is a running command started by ExecService.
+type ExecProcess struct {
+	ID  string
+	PID int
+
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	done     chan struct{}
+	exitCode int
+	err      error
+	termOnce sync.Once
+}
+
+// Start runs the command described by req, copying its output to stdout and stderr.
+// The process is killed when ctx is done or the timeout expires.
+func (s *ExecService) Start(ctx context.Context, kind string, req ExecRequest, stdout, stderr io.Writer) (*ExecProcess, error) {
+	if len(req.Argv) == 0 || req.Argv[0] == "" {
+		return nil, ErrMissingCommand
+	}
+	cwd, err := s.fs.resolve(req.Cwd)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(cwd); err != nil || !fi.IsDir() {
+		return nil, ErrNotDirectory
+	}
+
+	var cancel context.CancelFunc = func() {}
+	if req.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+	}
+	cmd := exec.Command(req.Argv[0], req.Argv[1:]...)
+	cmd.Dir = cwd
+	cmd.Env = os.Environ()
+	for k, v := range req.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// don't wait forever for output pipes held open by background children
+	cmd.WaitDelay = killGracePeriod
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	p := &ExecProcess{ID: uuid.NewString(), PID: cmd.Process.Pid, cmd: cmd, stdin: stdin, done: make(chan struct{})}
+	s.registry.Add(SessionProcess{
+		PID:       p.PID,
+		Kind:      kind,
+		SessionID: p.ID,
+		Command:   req.Argv,
+		Cwd:       cwd,
+		StartedAt: time.Now(),
+	})
+	go func() {
+		select {
+		case <-ctx.Done():
+			p.Terminate()
+		case <-p.done:
+		}
+	}()
+	go func() {
+		defer cancel()
+		err := cmd.Wait()
+		s.registry.Remove(p.PID)
+		p.exitCode = cmd.ProcessState.ExitCode()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			p.err = err
+		}
+		if ctx.Err() != nil {
+			// killed because of the timeout or because the caller went away
+			p.err = ctx.Err()
+		}
+		close(p.done)
+		// nothing may outlive the leader in its group
+		_ = signalGroup(p.PID, syscall.SIGKILL)
+	}()
+	return p, nil
+}
+
+// Write writes to the standard input of the process.
+func (p *ExecProcess) Write(data []byte) (int, error) {
+	return p.stdin.Write(data)
+}
+
+// CloseStdin closes the standard input of the process.
+func (p *ExecProcess) CloseStdin() error {
+	return p.stdin.Close()
+}
+
+// Signal sends sig to the whole process group.
+func (p *ExecProcess) Signal(sig syscall.Signal) error {
+	return signalGroup(p.PID, sig)
+}
+
+// Terminate stops the process group with SIGTERM, then SIGKILL after a grace period.
+func (p *ExecProcess) Terminate() {
+	p.termOnce.Do(func() {
+		go terminateGroup(p.PID, p.done, killGracePeriod)
+	})
+}
+
+// Done is closed when the process has exited.
+func (p *ExecProcess) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait blocks until the process exits and returns its exit code. A process killed by a signal
+// reports -1; the error is the context error if it was killed because of a timeout or cancellation.
+func (p *ExecProcess) Wait() (int, error) {
+	<-p.done
+	return p.exitCode, p.err
+}
@@ -8,6 +8,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 var (
@@ -18,16 +20,24 @@ var (
 	ErrAlreadyExists  = errors.New("already exists")
 	ErrDirNotEmpty    = errors.New("directory not empty")
 	ErrMissingNewName = errors.New("missing new name")
+	ErrQuotaExceeded  = errors.New("storage quota exceeded")
 )
 
 // LocalFileServiceImpl provides OS-backed file operations rooted at RootDir.
 type LocalFileServiceImpl struct {
 	RootDir string
+	Quota   *Quota // optional, nil disables quota enforcement
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+
+	stagingMu sync.Mutex
+	staging   map[string]struct{} // .part files of writes in progress
 }
 
 // NewLocalFileService constructs a LocalFileServiceImpl with a sanitized absolute root.
 func NewLocalFileService(rootDir string) *LocalFileServiceImpl {
-	return &LocalFileServiceImpl{RootDir: rootDir}
+	return &LocalFileServiceImpl{RootDir: rootDir, staging: make(map[string]struct{})}
 }
 
 // resolve joins the root and relative path, cleans it, and ensures it stays within RootDir.
@@ -97,8 +107,9 @@ func (s *LocalFileServiceImpl) List(rel string) ([]os.FileInfo, error) {
 	return out, nil
 }
 
-// Open returns an opened file for reading; caller must Close.
-func (s *LocalFileServiceImpl) Open(rel string) (*os.File, os.FileInfo, error) {
+// Open returns an opened file for reading; caller must Close. The bytes read from it count
+// towards the bytes read of the service.
+func (s *LocalFileServiceImpl) Open(rel string) (io.ReadSeekCloser, os.FileInfo, error) {
 	abs, err := s.resolve(rel)
 	if err != nil {
 		return nil, nil, err
@@ -117,7 +128,28 @@ func (s *LocalFileServiceImpl) Open(rel string) (*os.File, os.FileInfo, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	return f, fi, nil
+	return &countingFile{f: f, n: &s.bytesRead}, fi, nil
+}
+
+// countingFile adds the bytes read from a file to n. It wraps the file rather than embedding
+// it, so io.Copy cannot bypass Read through the file's WriteTo.
+type countingFile struct {
+	f *os.File
+	n *atomic.Int64
+}
+
+func (c *countingFile) Read(p []byte) (int, error) {
+	n, err := c.f.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func (c *countingFile) Seek(offset int64, whence int) (int64, error) {
+	return c.f.Seek(offset, whence)
+}
+
+func (c *countingFile) Close() error {
+	return c.f.Close()
 }
 
 // ReadFile reads entire file into memory. For large files, prefer Open and streaming.
@@ -136,7 +168,9 @@ func (s *LocalFileServiceImpl) ReadFile(rel string) ([]byte, error) {
 	if fi.IsDir() {
 		return nil, ErrIsDirectory
 	}
-	return os.ReadFile(abs)
+	data, err := os.ReadFile(abs)
+	s.bytesRead.Add(int64(len(data)))
+	return data, err
 }
 
 // WriteFile writes bytes to a file at rel. If create is false and the file doesn't exist, returns ErrNotFound.
@@ -149,15 +183,32 @@ func (s *LocalFileServiceImpl) WriteFile(rel string, data []byte, create bool) e
 	if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
 		return err
 	}
-	if !create {
-		if _, err := os.Stat(abs); err != nil {
-			if os.IsNotExist(err) {
-				return ErrNotFound
-			}
-			return err
-		}
+	var oldSize, newFiles int64
+	fi, err := os.Stat(abs)
+	switch {
+	case err == nil:
+		oldSize = fi.Size()
+	case !os.IsNotExist(err):
+		return err
+	case !create:
+		return ErrNotFound
+	default:
+		newFiles = 1
+	}
+	// growth is reserved up front, shrinking is released once written
+	delta := int64(len(data)) - oldSize
+	if err := s.Quota.Reserve(max(delta, 0), newFiles); err != nil {
+		return err
+	}
+	if err := os.WriteFile(abs, data, 0o644); err != nil {
+		s.Quota.Release(max(delta, 0), newFiles)
+		return err
 	}
-	return os.WriteFile(abs, data, 0o644)
+	s.bytesWritten.Add(int64(len(data)))
+	if delta < 0 {
+		s.Quota.Release(-delta, 0)
+	}
+	return nil
 }
 
 // SaveStream writes an io.Reader to the destination file. Overwrites when overwrite==true.
@@ -169,27 +220,69 @@ func (s *LocalFileServiceImpl) SaveStream(rel string, r io.Reader, overwrite boo
 	if err := os.MkdirAll(filepath.Dir(abs), 0o755); err != nil {
 		return err
 	}
-	if !overwrite {
-		if _, err := os.Stat(abs); err == nil {
+	var oldSize, newFiles int64 = 0, 1
+	if fi, err := os.Stat(abs); err == nil {
+		if !overwrite {
 			return ErrAlreadyExists
 		}
+		oldSize, newFiles = fi.Size(), 0
 	}
+	if err := s.Quota.Reserve(0, newFiles); err != nil {
+		return err
+	}
+	// bytes are reserved chunk by chunk while streaming, so a runaway upload stops at the limit
+	var reserved int64
+	r = s.Quota.Reader(r, &reserved)
 	tmp := abs + ".part"
 	f, err := os.Create(tmp)
 	if err != nil {
+		s.Quota.Release(0, newFiles)
 		return err
 	}
-	_, copyErr := io.Copy(f, r)
+	s.stagingMu.Lock()
+	s.staging[tmp] = struct{}{}
+	s.stagingMu.Unlock()
+	defer func() {
+		s.stagingMu.Lock()
+		delete(s.staging, tmp)
+		s.stagingMu.Unlock()
+	}()
+	n, copyErr := io.Copy(f, r)
+	s.bytesWritten.Add(n)
 	closeErr := f.Close()
+	if copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr == nil {
+		copyErr = os.Rename(tmp, abs)
+	}
 	if copyErr != nil {
 		os.Remove(tmp)
+		s.Quota.Release(reserved, newFiles)
 		return copyErr
 	}
-	if closeErr != nil {
-		os.Remove(tmp)
-		return closeErr
+	s.Quota.Release(oldSize, 0)
+	return nil
+}
+
+// RemoveStaging deletes the temporary files of the writes in progress, which then fail. It is
+// called on shutdown so aborted uploads leave no .part files behind.
+func (s *LocalFileServiceImpl) RemoveStaging() int {
+	s.stagingMu.Lock()
+	defer s.stagingMu.Unlock()
+	removed := 0
+	for tmp := range s.staging {
+		if err := os.Remove(tmp); err == nil {
+			removed++
+		}
+		delete(s.staging, tmp)
 	}
-	return os.Rename(tmp, abs)
+	return removed
+}
+
+// IOStats returns the bytes read from and written to files through the service.
+func (s *LocalFileServiceImpl) IOStats() (read, written int64) {
+	return s.bytesRead.Load(), s.bytesWritten.Load()
 }
 
 // Delete deletes a file or an empty directory.
@@ -218,7 +311,11 @@ func (s *LocalFileServiceImpl) Delete(rel string) error {
 		}
 		return os.Remove(abs)
 	}
-	return os.Remove(abs)
+	if err := os.Remove(abs); err != nil {
+		return err
+	}
+	s.Quota.Release(fi.Size(), 1)
+	return nil
 }
 
 // DeleteRecursive deletes a file or directory recursively.
@@ -233,7 +330,15 @@ func (s *LocalFileServiceImpl) DeleteRecursive(rel string) error {
 		}
 		return err
 	}
-	return os.RemoveAll(abs)
+	var bytes, files int64
+	if s.Quota != nil {
+		bytes, files, _ = diskUsage(abs)
+	}
+	if err := os.RemoveAll(abs); err != nil {
+		return err
+	}
+	s.Quota.Release(bytes, files)
+	return nil
 }
 
 // MkdirAll creates a directory (and parents) at rel.
@@ -256,7 +361,8 @@ func (s *LocalFileServiceImpl) Rename(relPath string, newPath string, overwrite
 	if err != nil {
 		return err
 	}
-	if _, err := os.Stat(absSrcPath); err != nil {
+	srcInfo, err := os.Stat(absSrcPath)
+	if err != nil {
 		if os.IsNotExist(err) {
 			return ErrNotFound
 		}
@@ -270,13 +376,24 @@ func (s *LocalFileServiceImpl) Rename(relPath string, newPath string, overwrite
 	}
 
 	// if overwrite is false, check existence and return error
-	if !overwrite {
-		if _, err := os.Stat(absDstPath); err == nil {
+	var replacedBytes, replacedFiles int64
+	if dstInfo, err := os.Stat(absDstPath); err == nil {
+		if !overwrite {
 			return ErrAlreadyExists
 		}
+		// the replaced destination no longer counts against the quota
+		if s.Quota != nil && !os.SameFile(srcInfo, dstInfo) {
+			if replacedBytes, replacedFiles, err = diskUsage(absDstPath); err != nil {
+				return err
+			}
+		}
 	}
 
-	return os.Rename(absSrcPath, absDstPath)
+	if err := os.Rename(absSrcPath, absDstPath); err != nil {
+		return err
+	}
+	s.Quota.Release(replacedBytes, replacedFiles)
+	return nil
 }
 
 // DetectMIME tries to infer MIME type by extension or content.
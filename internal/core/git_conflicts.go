@@ -0,0 +1,178 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrNoOperationInProgress = errors.New("no merge, rebase, cherry-pick or revert in progress")
+	ErrNotConflicted         = errors.New("path has no merge conflict")
+)
+
+// Operations that can stop with conflicts.
+const (
+	GitOpMerge      = "merge"
+	GitOpRebase     = "rebase"
+	GitOpCherryPick = "cherry-pick"
+	GitOpRevert     = "revert"
+)
+
+// GitBlobRef identifies a blob in one of the index stages of a conflicted path.
+type GitBlobRef struct {
+	ID   string `json:"id"`
+	Mode string `json:"mode"`
+}
+
+// GitConflict is a conflicted path with the blobs of its base (stage 1), ours (2) and theirs (3) versions.
+// A missing side means the path was added or deleted on that side.
+type GitConflict struct {
+	Path   string      `json:"path"`
+	Base   *GitBlobRef `json:"base,omitempty"`
+	Ours   *GitBlobRef `json:"ours,omitempty"`
+	Theirs *GitBlobRef `json:"theirs,omitempty"`
+}
+
+// GitConflictState describes the operation in progress and its unresolved paths.
+type GitConflictState struct {
+	Operation string        `json:"operation,omitempty"`
+	Files     []GitConflict `json:"files"`
+}
+
+// Conflicts lists the unresolved paths of the repository together with the operation in progress.
+func (s *GitService) Conflicts(ctx context.Context, repo string) (*GitConflictState, error) {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.run(ctx, dir, nil, "ls-files", "-u", "-z")
+	if err != nil {
+		return nil, err
+	}
+	byPath := map[string]*GitConflict{}
+	for _, rec := range strings.Split(string(out), "\x00") {
+		// "<mode> <oid> <stage>\t<path>"
+		meta, path, ok := strings.Cut(rec, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 3 {
+			continue
+		}
+		c := byPath[path]
+		if c == nil {
+			c = &GitConflict{Path: path}
+			byPath[path] = c
+		}
+		blob := &GitBlobRef{ID: fields[1], Mode: fields[0]}
+		switch fields[2] {
+		case "1":
+			c.Base = blob
+		case "2":
+			c.Ours = blob
+		case "3":
+			c.Theirs = blob
+		}
+	}
+	state := &GitConflictState{Operation: s.operationInProgress(ctx, dir), Files: []GitConflict{}}
+	for _, c := range byPath {
+		state.Files = append(state.Files, *c)
+	}
+	sort.Slice(state.Files, func(i, j int) bool { return state.Files[i].Path < state.Files[j].Path })
+	return state, nil
+}
+
+// ResolveConflict atomically writes the resolved content of a conflicted path and marks it
+// resolved in the index. Paths without unmerged index entries are rejected.
+func (s *GitService) ResolveConflict(ctx context.Context, repo, path string, content io.Reader) error {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return err
+	}
+	rel, err := s.repoPaths(repo, dir, []string{path})
+	if err != nil {
+		return err
+	}
+	// like mutate, but around writing the file as well
+	unlock := lockRepo(dir)
+	defer unlock()
+	unmerged, err := s.run(ctx, dir, nil, "ls-files", "--unmerged", "--", rel[0])
+	if err != nil {
+		return err
+	}
+	if len(unmerged) == 0 {
+		return ErrNotConflicted
+	}
+	if err := s.fs.SaveStream(filepath.Join(repo, rel[0]), content, true); err != nil {
+		return err
+	}
+	if _, err := s.run(ctx, dir, nil, "add", "--", rel[0]); err != nil {
+		return classifyGitError(err)
+	}
+	return nil
+}
+
+// MarkResolved stages paths as resolved with their current working tree contents.
+func (s *GitService) MarkResolved(ctx context.Context, repo string, paths []string) error {
+	return s.Stage(ctx, repo, paths, "")
+}
+
+// ContinueOperation continues the merge, rebase, cherry-pick or revert in progress.
+func (s *GitService) ContinueOperation(ctx context.Context, repo string) error {
+	return s.finishOperation(ctx, repo, "--continue")
+}
+
+// AbortOperation aborts the merge, rebase, cherry-pick or revert in progress.
+func (s *GitService) AbortOperation(ctx context.Context, repo string) error {
+	return s.finishOperation(ctx, repo, "--abort")
+}
+
+func (s *GitService) finishOperation(ctx context.Context, repo, flag string) error {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return err
+	}
+	op := s.operationInProgress(ctx, dir)
+	if op == "" {
+		return ErrNoOperationInProgress
+	}
+	args := []string{op, flag}
+	if op == GitOpMerge && flag == "--continue" {
+		// merge --continue is only a thin wrapper around commit
+		args = []string{"commit", "--no-edit"}
+	}
+	// never open an editor for commit messages
+	_, err = s.mutate(ctx, dir, nil, []string{"GIT_EDITOR=true"}, args...)
+	return err
+}
+
+// operationInProgress detects which operation stopped in the repository, if any.
+func (s *GitService) operationInProgress(ctx context.Context, dir string) string {
+	markers := []struct{ path, op string }{
+		{"rebase-merge", GitOpRebase},
+		{"rebase-apply", GitOpRebase},
+		{"MERGE_HEAD", GitOpMerge},
+		{"CHERRY_PICK_HEAD", GitOpCherryPick},
+		{"REVERT_HEAD", GitOpRevert},
+	}
+	for _, m := range markers {
+		out, err := s.run(ctx, dir, nil, "rev-parse", "--git-path", m.path)
+		if err != nil {
+			continue
+		}
+		p := strings.TrimSpace(string(out))
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		if _, err := os.Stat(p); err == nil {
+			return m.op
+		}
+	}
+	return ""
+}
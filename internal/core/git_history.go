@@ -0,0 +1,300 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultLogLimit = 50
+	MaxLogLimit     = 500
+)
+
+// GitSignature is the identity and time of a commit author or committer.
+type GitSignature struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Time  time.Time `json:"time"`
+}
+
+// GitCommit is a single entry of the commit history.
+type GitCommit struct {
+	ID        string       `json:"id"`
+	Parents   []string     `json:"parents"`
+	Author    GitSignature `json:"author"`
+	Committer GitSignature `json:"committer"`
+	Subject   string       `json:"subject"`
+	Body      string       `json:"body,omitempty"`
+	Path      string       `json:"path,omitempty"` // path of the followed file at this commit
+}
+
+// GitLogPage is one page of commit history.
+type GitLogPage struct {
+	Commits  []GitCommit `json:"commits"`
+	NextSkip int         `json:"nextSkip,omitempty"` // skip value of the next page, 0 when there is none
+}
+
+// GitBlameRange attributes a run of consecutive lines to the commit that last changed them.
+type GitBlameRange struct {
+	StartLine int       `json:"startLine"` // 1-based
+	LineCount int       `json:"lineCount"`
+	Commit    string    `json:"commit"`
+	Author    string    `json:"author"`
+	Email     string    `json:"email"`
+	Time      time.Time `json:"time"`
+	Summary   string    `json:"summary"`
+	OrigPath  string    `json:"origPath,omitempty"`
+}
+
+// notCommitted is the commit id git blame reports for lines not yet committed.
+const notCommitted = "0000000000000000000000000000000000000000"
+
+// Log returns a page of history of the repository, or of path following renames when path is set.
+func (s *GitService) Log(ctx context.Context, repo, ref, path string, skip, limit int) (*GitLogPage, error) {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	commit, err := s.resolveCommit(ctx, dir, ref)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = DefaultLogLimit
+	}
+	limit = min(limit, MaxLogLimit)
+	skip = max(skip, 0)
+	if path != "" {
+		rel, err := s.repoPaths(repo, dir, []string{path})
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.ToSlash(rel[0])
+	}
+
+	cacheKey := fmt.Sprintf("%s\x00%s\x00%s\x00%d\x00%d", dir, commit, path, skip, limit)
+	if page, ok := s.logCache.Get(cacheKey); ok {
+		return page, nil
+	}
+
+	// fetch one extra commit to know whether there is a next page
+	args := []string{"-c", "core.quotePath=false", "log", "--no-color",
+		"--format=%x1e%H%x00%P%x00%an%x00%ae%x00%at%x00%cn%x00%ce%x00%ct%x00%s%x00%b%x00"}
+	if path != "" {
+		// --skip does not combine with --follow, so skipped commits are dropped here instead
+		args = append(args, "-n", strconv.Itoa(skip+limit+1), commit, "--follow", "--name-status", "--", path)
+	} else {
+		args = append(args, "-n", strconv.Itoa(limit+1), "--skip", strconv.Itoa(skip), commit)
+	}
+	out, err := s.run(ctx, dir, nil, args...)
+	if err != nil {
+		return nil, mapGitLookupError(err)
+	}
+	commits := parseGitLog(out)
+	if path != "" {
+		commits = commits[min(skip, len(commits)):]
+	}
+	page := &GitLogPage{Commits: commits}
+	if len(commits) > limit {
+		page.Commits = commits[:limit]
+		page.NextSkip = skip + limit
+	}
+	s.logCache.Add(cacheKey, page)
+	return page, nil
+}
+
+// Blame attributes every line of path at ref (or in the working tree when ref is empty) to a commit.
+func (s *GitService) Blame(ctx context.Context, repo, ref, path string) ([]GitBlameRange, error) {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return nil, ErrMissingPaths
+	}
+	rel, err := s.repoPaths(repo, dir, []string{path})
+	if err != nil {
+		return nil, err
+	}
+	path = filepath.ToSlash(rel[0])
+
+	// cache by commit and blob id; the working tree blob is hashed from disk
+	var commit, blob string
+	if ref == "" {
+		if commit, err = s.resolveCommit(ctx, dir, "HEAD"); err != nil {
+			commit = notCommitted
+		}
+		out, err := s.run(ctx, dir, nil, "hash-object", "--", path)
+		if err != nil {
+			return nil, ErrNotFound
+		}
+		blob = strings.TrimSpace(string(out))
+	} else {
+		if commit, err = s.resolveCommit(ctx, dir, ref); err != nil {
+			return nil, err
+		}
+		out, err := s.run(ctx, dir, nil, "rev-parse", "--verify", "-q", commit+":"+path)
+		if err != nil {
+			return nil, ErrRevNotFound
+		}
+		blob = strings.TrimSpace(string(out))
+	}
+	cacheKey := dir + "\x00" + commit + "\x00" + blob + "\x00" + path
+	if ranges, ok := s.blameCache.Get(cacheKey); ok {
+		return ranges, nil
+	}
+
+	args := []string{"blame", "--porcelain"}
+	if ref != "" {
+		args = append(args, commit)
+	}
+	args = append(args, "--", path)
+	out, err := s.run(ctx, dir, nil, args...)
+	if err != nil {
+		return nil, mapGitLookupError(err)
+	}
+	ranges := parseGitBlame(out)
+	for i := range ranges {
+		if ranges[i].OrigPath == path {
+			ranges[i].OrigPath = ""
+		}
+	}
+	s.blameCache.Add(cacheKey, ranges)
+	return ranges, nil
+}
+
+// resolveCommit resolves a revision to a full commit id.
+func (s *GitService) resolveCommit(ctx context.Context, dir, ref string) (string, error) {
+	if err := validateRef(ref); err != nil {
+		return "", err
+	}
+	out, err := s.run(ctx, dir, nil, "rev-parse", "--verify", "-q", ref+"^{commit}")
+	if err != nil {
+		return "", ErrRevNotFound
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// parseGitLog parses records written with the format used by Log. Each record may be followed by
+// --name-status lines when following a path.
+func parseGitLog(out []byte) []GitCommit {
+	commits := []GitCommit{}
+	for _, rec := range strings.Split(string(out), "\x1e") {
+		fields := strings.SplitN(rec, "\x00", 11)
+		if len(fields) < 11 {
+			continue
+		}
+		c := GitCommit{
+			ID:        fields[0],
+			Parents:   strings.Fields(fields[1]),
+			Author:    GitSignature{Name: fields[2], Email: fields[3], Time: unixTime(fields[4])},
+			Committer: GitSignature{Name: fields[5], Email: fields[6], Time: unixTime(fields[7])},
+			Subject:   fields[8],
+			Body:      strings.TrimSpace(fields[9]),
+		}
+		// name-status lines: "M\tpath" or "R100\told\tnew"; the last column is the path at this commit
+		for _, line := range strings.Split(strings.TrimSpace(fields[10]), "\n") {
+			if cols := strings.Split(line, "\t"); len(cols) >= 2 {
+				c.Path = cols[len(cols)-1]
+			}
+		}
+		commits = append(commits, c)
+	}
+	return commits
+}
+
+func unixTime(s string) time.Time {
+	sec, _ := strconv.ParseInt(s, 10, 64)
+	return time.Unix(sec, 0).UTC()
+}
+
+// parseGitBlame parses `git blame --porcelain` output into ranges of consecutive lines per commit.
+func parseGitBlame(out []byte) []GitBlameRange {
+	type commitInfo struct {
+		author, email, summary, filename string
+		time                             time.Time
+	}
+	infos := map[string]*commitInfo{}
+	ranges := []GitBlameRange{}
+
+	var cur *commitInfo
+	var curCommit string
+	var curLine int
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "\t") {
+			// content line closes the current header; extend or start a range
+			if n := len(ranges); n > 0 && ranges[n-1].Commit == curCommit &&
+				ranges[n-1].StartLine+ranges[n-1].LineCount == curLine {
+				ranges[n-1].LineCount++
+				continue
+			}
+			ranges = append(ranges, GitBlameRange{
+				StartLine: curLine,
+				LineCount: 1,
+				Commit:    curCommit,
+				Author:    cur.author,
+				Email:     cur.email,
+				Time:      cur.time,
+				Summary:   cur.summary,
+				OrigPath:  cur.filename,
+			})
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		if isObjectID(key) {
+			// "<sha> <orig line> <final line> [<num lines>]"
+			fields := strings.Fields(value)
+			curCommit = key
+			if len(fields) >= 2 {
+				curLine, _ = strconv.Atoi(fields[1])
+			}
+			if cur = infos[key]; cur == nil {
+				cur = &commitInfo{}
+				infos[key] = cur
+			}
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		switch key {
+		case "author":
+			cur.author = value
+		case "author-mail":
+			cur.email = strings.Trim(value, "<>")
+		case "author-time":
+			cur.time = unixTime(value)
+		case "summary":
+			cur.summary = value
+		case "filename":
+			cur.filename = value
+		}
+	}
+	return ranges
+}
+
+// isObjectID reports whether s is a full object id of a SHA-1 or a SHA-256 repository.
+func isObjectID(s string) bool {
+	return (len(s) == 40 || len(s) == 64) && isHex(s)
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGitBlame(t *testing.T) {
+	const (
+		first   = "012284c140c553421aaad9b06554bf9d06e7104f"
+		second  = "7ccfae9338a81826a691d87c7edfe4325ac922aa"
+		sha256a = "5b0ab8e6a2ae3b7d4e2c0a0f6a2a7c1b8d0f0b8f6d0e4f5a9c3e1d2b4a6c8e0f"
+		sha256b = "9f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0"
+	)
+	// header returns the first header of a commit in git blame --porcelain output.
+	header := func(id, orig, final, lines, author, email, summary, filename string, time int) string {
+		return strings.Join([]string{
+			id + " " + orig + " " + final + " " + lines,
+			"author " + author,
+			"author-mail <" + email + ">",
+			"author-time " + strconv.Itoa(time),
+			"author-tz +0000",
+			"committer " + author,
+			"committer-mail <" + email + ">",
+			"committer-time " + strconv.Itoa(time),
+			"committer-tz +0000",
+			"summary " + summary,
+			"filename " + filename,
+		}, "\n")
+	}
+	blame := func(lines ...string) []byte {
+		return []byte(strings.Join(lines, "\n") + "\n")
+	}
+	ann := time.Unix(1700000000, 0).UTC()
+	bob := time.Unix(1700003600, 0).UTC()
+	tests := []struct {
+		name string
+		out  []byte
+		want []GitBlameRange
+	}{
+		{
+			name: "empty",
+			want: []GitBlameRange{},
+		},
+		{
+			name: "consecutive lines of a commit form one range",
+			out: blame(
+				header(first, "1", "1", "1", "Ann", "ann@example.com", "first", "f.txt", 1700000000),
+				"boundary",
+				"\tone",
+				header(second, "2", "2", "2", "Bob", "bob@example.com", "second", "f.txt", 1700003600),
+				"previous "+first+" f.txt",
+				"\tTWO",
+				second+" 3 3",
+				"\tthree",
+			),
+			want: []GitBlameRange{
+				{StartLine: 1, LineCount: 1, Commit: first, Author: "Ann", Email: "ann@example.com", Time: ann, Summary: "first", OrigPath: "f.txt"},
+				{StartLine: 2, LineCount: 2, Commit: second, Author: "Bob", Email: "bob@example.com", Time: bob, Summary: "second", OrigPath: "f.txt"},
+			},
+		},
+		{
+			name: "a commit seen again reuses its header",
+			out: blame(
+				header(first, "1", "1", "1", "Ann", "ann@example.com", "first", "f.txt", 1700000000),
+				"\tone",
+				header(second, "2", "2", "1", "Bob", "bob@example.com", "second", "f.txt", 1700003600),
+				"\ttwo",
+				first+" 2 3 1",
+				"\tthree",
+			),
+			want: []GitBlameRange{
+				{StartLine: 1, LineCount: 1, Commit: first, Author: "Ann", Email: "ann@example.com", Time: ann, Summary: "first", OrigPath: "f.txt"},
+				{StartLine: 2, LineCount: 1, Commit: second, Author: "Bob", Email: "bob@example.com", Time: bob, Summary: "second", OrigPath: "f.txt"},
+				{StartLine: 3, LineCount: 1, Commit: first, Author: "Ann", Email: "ann@example.com", Time: ann, Summary: "first", OrigPath: "f.txt"},
+			},
+		},
+		{
+			name: "lines moved from a renamed file",
+			out: blame(
+				header(first, "4", "1", "1", "Ann", "ann@example.com", "first", "old name.txt", 1700000000),
+				"\tmoved",
+			),
+			want: []GitBlameRange{
+				{StartLine: 1, LineCount: 1, Commit: first, Author: "Ann", Email: "ann@example.com", Time: ann, Summary: "first", OrigPath: "old name.txt"},
+			},
+		},
+		{
+			name: "uncommitted lines",
+			out: blame(
+				header(notCommitted, "4", "4", "1", "Not Committed Yet", "not.committed.yet", "Version of f.txt from f.txt", "f.txt", 1700003600),
+				"\tfour",
+			),
+			want: []GitBlameRange{
+				{StartLine: 4, LineCount: 1, Commit: notCommitted, Author: "Not Committed Yet", Email: "not.committed.yet", Time: bob, Summary: "Version of f.txt from f.txt", OrigPath: "f.txt"},
+			},
+		},
+		{
+			name: "sha256 repository",
+			out: blame(
+				header(sha256a, "1", "1", "2", "Ann", "ann@example.com", "first", "f.txt", 1700000000),
+				"\tone",
+				sha256a+" 2 2",
+				"\ttwo",
+				header(sha256b, "3", "3", "1", "Bob", "bob@example.com", "second", "f.txt", 1700003600),
+				"\tthree",
+			),
+			want: []GitBlameRange{
+				{StartLine: 1, LineCount: 2, Commit: sha256a, Author: "Ann", Email: "ann@example.com", Time: ann, Summary: "first", OrigPath: "f.txt"},
+				{StartLine: 3, LineCount: 1, Commit: sha256b, Author: "Bob", Email: "bob@example.com", Time: bob, Summary: "second", OrigPath: "f.txt"},
+			},
+		},
+		{
+			name: "content lines that look like headers",
+			out: blame(
+				header(first, "1", "1", "2", "Ann", "ann@example.com", "first", "f.txt", 1700000000),
+				"\tauthor Mallory",
+				first+" 2 2",
+				"\t"+second+" 1 1 1",
+			),
+			want: []GitBlameRange{
+				{StartLine: 1, LineCount: 2, Commit: first, Author: "Ann", Email: "ann@example.com", Time: ann, Summary: "first", OrigPath: "f.txt"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseGitBlame(tt.out); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGitBlame() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsObjectID(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"012284c140c553421aaad9b06554bf9d06e7104f", true},
+		{strings.Repeat("a", 64), true},
+		{"012284c", false},
+		{strings.Repeat("a", 41), false},
+		{strings.Repeat("a", 63), false},
+		{"012284C140C553421AAAD9B06554BF9D06E7104F", false},
+		{"g12284c140c553421aaad9b06554bf9d06e7104f", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isObjectID(tt.s); got != tt.want {
+			t.Errorf("isObjectID(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
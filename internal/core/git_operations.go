@@ -0,0 +1,323 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrGitConflict      = errors.New("git operation stopped because of conflicts")
+	ErrGitHookFailed    = errors.New("git hook rejected the operation")
+	ErrGitIndexLocked   = errors.New("git index is locked by another process")
+	ErrNothingToCommit  = errors.New("nothing to commit")
+	ErrBranchExists     = errors.New("branch already exists")
+	ErrMissingPaths     = errors.New("no paths given")
+	ErrMissingMessage   = errors.New("missing commit message")
+	ErrInvalidRefName   = errors.New("invalid ref name")
+	ErrOutsideWorkTree  = errors.New("path is outside the repository")
+	ErrNoStashToRestore = errors.New("no stash entries")
+)
+
+// GitIdentity is the author and committer identity used for commits.
+type GitIdentity struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// repoLocks serialises mutating operations per repository so concurrent requests don't race on index.lock.
+var repoLocks sync.Map // map[string]*sync.Mutex
+
+func lockRepo(dir string) func() {
+	mu, _ := repoLocks.LoadOrStore(dir, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// mutate runs a git command that changes the repository while holding the repository lock
+// and classifies common failures into structured errors.
+func (s *GitService) mutate(ctx context.Context, dir string, stdin []byte, env []string, args ...string) ([]byte, error) {
+	unlock := lockRepo(dir)
+	defer unlock()
+	out, err := s.runEnv(ctx, dir, stdin, env, args...)
+	if err != nil {
+		return out, classifyGitError(err)
+	}
+	return out, nil
+}
+
+// classifyGitError wraps a GitError with a sentinel describing why the command failed.
+func classifyGitError(err error) error {
+	var gitErr *GitError
+	if !errors.As(err, &gitErr) {
+		return err
+	}
+	msg := gitErr.Stdout + gitErr.Stderr
+	switch {
+	case strings.Contains(msg, "index.lock"):
+		return fmt.Errorf("%w: %w", ErrGitIndexLocked, err)
+	case strings.Contains(msg, "CONFLICT"), strings.Contains(msg, "would be overwritten"),
+		strings.Contains(msg, "needs merge"), strings.Contains(msg, "unmerged"):
+		return fmt.Errorf("%w: %w", ErrGitConflict, err)
+	case strings.Contains(msg, "already exists"):
+		return fmt.Errorf("%w: %w", ErrBranchExists, err)
+	case strings.Contains(msg, "No stash entries"):
+		return fmt.Errorf("%w: %w", ErrNoStashToRestore, err)
+	}
+	return err
+}
+
+// repoPaths confines each path to the repository and returns it relative to the repository root.
+func (s *GitService) repoPaths(repo, dir string, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return nil, ErrMissingPaths
+	}
+	out := make([]string, 0, len(paths))
+	for _, p := range paths {
+		abs, err := s.fs.resolve(filepath.Join(repo, p))
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(dir, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return nil, ErrOutsideWorkTree
+		}
+		out = append(out, rel)
+	}
+	return out, nil
+}
+
+// Stage adds paths to the index, or applies a patch of selected hunks to the index when patch is not empty.
+func (s *GitService) Stage(ctx context.Context, repo string, paths []string, patch string) error {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return err
+	}
+	if patch != "" {
+		_, err = s.mutate(ctx, dir, []byte(patch), nil, "apply", "--cached", "--recount", "-")
+		return err
+	}
+	rel, err := s.repoPaths(repo, dir, paths)
+	if err != nil {
+		return err
+	}
+	_, err = s.mutate(ctx, dir, nil, nil, append([]string{"add", "-A", "--"}, rel...)...)
+	return err
+}
+
+// Unstage removes paths from the index, or reverse-applies a patch of hunks to the index when patch is not empty.
+func (s *GitService) Unstage(ctx context.Context, repo string, paths []string, patch string) error {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return err
+	}
+	if patch != "" {
+		_, err = s.mutate(ctx, dir, []byte(patch), nil, "apply", "--cached", "--reverse", "--recount", "-")
+		return err
+	}
+	rel, err := s.repoPaths(repo, dir, paths)
+	if err != nil {
+		return err
+	}
+	if _, err := s.run(ctx, dir, nil, "rev-parse", "--verify", "-q", "HEAD"); err != nil {
+		// unborn branch: there is no HEAD to reset to
+		_, err = s.mutate(ctx, dir, nil, nil, append([]string{"rm", "--cached", "-r", "-q", "--"}, rel...)...)
+		return err
+	}
+	_, err = s.mutate(ctx, dir, nil, nil, append([]string{"reset", "-q", "--"}, rel...)...)
+	return err
+}
+
+// Discard reverts working tree changes of paths to the index. Untracked paths are removed when untracked is true.
+func (s *GitService) Discard(ctx context.Context, repo string, paths []string, untracked bool) error {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return err
+	}
+	rel, err := s.repoPaths(repo, dir, paths)
+	if err != nil {
+		return err
+	}
+	if untracked {
+		_, err = s.mutate(ctx, dir, nil, nil, append([]string{"clean", "-f", "-d", "-q", "--"}, rel...)...)
+		return err
+	}
+	_, err = s.mutate(ctx, dir, nil, nil, append([]string{"checkout", "-q", "--"}, rel...)...)
+	return err
+}
+
+// Commit records the index as a new commit and returns its id. When author is set it is used
+// for both the author and committer identity.
+func (s *GitService) Commit(ctx context.Context, repo, message string, author *GitIdentity, amend bool) (string, error) {
+	if strings.TrimSpace(message) == "" && !amend {
+		return "", ErrMissingMessage
+	}
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+	var env []string
+	if author != nil && author.Name != "" && author.Email != "" {
+		env = []string{
+			"GIT_AUTHOR_NAME=" + author.Name, "GIT_AUTHOR_EMAIL=" + author.Email,
+			"GIT_COMMITTER_NAME=" + author.Name, "GIT_COMMITTER_EMAIL=" + author.Email,
+		}
+	}
+	// not quiet: git reports "nothing to commit" on stdout
+	args := []string{"commit", "--cleanup=strip", "-F", "-"}
+	if amend {
+		args = append(args, "--amend")
+		if message == "" {
+			args = []string{"commit", "--amend", "--no-edit"}
+		}
+	}
+	if _, err := s.mutate(ctx, dir, []byte(message), env, args...); err != nil {
+		var gitErr *GitError
+		if errors.As(err, &gitErr) {
+			if combined := gitErr.Stdout + gitErr.Stderr; strings.Contains(combined, "nothing to commit") ||
+				strings.Contains(combined, "no changes added to commit") {
+				return "", fmt.Errorf("%w: %w", ErrNothingToCommit, err)
+			}
+			// git reports its own failures, an author it cannot determine, a lock or unmerged
+			// files, on "fatal:" and "error:" lines; a rejecting hook prints only its own output
+			if !gitReportedError(gitErr.Stderr) && s.hasCommitHooks(ctx, dir) {
+				return "", fmt.Errorf("%w: %w", ErrGitHookFailed, err)
+			}
+		}
+		return "", err
+	}
+	head, err := s.run(ctx, dir, nil, "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(head)), nil
+}
+
+// gitReportedError reports whether git itself printed an error, as opposed to a hook.
+func gitReportedError(stderr string) bool {
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.HasPrefix(line, "fatal: ") || strings.HasPrefix(line, "error: ") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCommitHooks reports whether the repository has an executable hook that can reject a commit.
+func (s *GitService) hasCommitHooks(ctx context.Context, dir string) bool {
+	out, err := s.run(ctx, dir, nil, "rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return false
+	}
+	hooksDir := strings.TrimSpace(string(out))
+	if !filepath.IsAbs(hooksDir) {
+		hooksDir = filepath.Join(dir, hooksDir)
+	}
+	for _, name := range []string{"pre-commit", "prepare-commit-msg", "commit-msg"} {
+		if fi, err := os.Stat(filepath.Join(hooksDir, name)); err == nil && fi.Mode()&0o111 != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateBranch creates a branch at startPoint (default HEAD) and optionally switches to it.
+func (s *GitService) CreateBranch(ctx context.Context, repo, name, startPoint string, checkout bool) error {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return err
+	}
+	if err := s.checkRefName(ctx, dir, name); err != nil {
+		return err
+	}
+	if err := validateRef(startPoint); err != nil {
+		return err
+	}
+	args := []string{"branch", name}
+	if checkout {
+		args = []string{"switch", "-q", "-c", name}
+	}
+	if startPoint != "" {
+		args = append(args, startPoint)
+	}
+	_, err = s.mutate(ctx, dir, nil, nil, args...)
+	return err
+}
+
+// SwitchBranch checks out an existing branch.
+func (s *GitService) SwitchBranch(ctx context.Context, repo, name string) error {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return err
+	}
+	if err := s.checkRefName(ctx, dir, name); err != nil {
+		return err
+	}
+	_, err = s.mutate(ctx, dir, nil, nil, "switch", "-q", name)
+	return err
+}
+
+// DeleteBranch deletes a branch. Unmerged branches are only deleted when force is true.
+func (s *GitService) DeleteBranch(ctx context.Context, repo, name string, force bool) error {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return err
+	}
+	if err := s.checkRefName(ctx, dir, name); err != nil {
+		return err
+	}
+	flag := "-d"
+	if force {
+		flag = "-D"
+	}
+	_, err = s.mutate(ctx, dir, nil, nil, "branch", "-q", flag, name)
+	return err
+}
+
+func (s *GitService) checkRefName(ctx context.Context, dir, name string) error {
+	if name == "" || strings.HasPrefix(name, "-") {
+		return ErrInvalidRefName
+	}
+	if _, err := s.run(ctx, dir, nil, "check-ref-format", "--branch", name); err != nil {
+		return ErrInvalidRefName
+	}
+	return nil
+}
+
+// Stash saves local modifications to a new stash entry.
+func (s *GitService) Stash(ctx context.Context, repo, message string, includeUntracked bool) error {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return err
+	}
+	args := []string{"stash", "push", "-q"}
+	if includeUntracked {
+		args = append(args, "--include-untracked")
+	}
+	if message != "" {
+		args = append(args, "-m", message)
+	}
+	_, err = s.mutate(ctx, dir, nil, nil, args...)
+	return err
+}
+
+// StashPop applies the stash entry at index and removes it from the stash list.
+func (s *GitService) StashPop(ctx context.Context, repo string, index int) error {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return err
+	}
+	if index < 0 {
+		return fmt.Errorf("%w: negative stash index", ErrInvalidRevision)
+	}
+	entry := fmt.Sprintf("stash@{%d}", index)
+	if _, err := s.run(ctx, dir, nil, "rev-parse", "--verify", "-q", entry); err != nil {
+		return ErrNoStashToRestore
+	}
+	_, err = s.mutate(ctx, dir, nil, nil, "stash", "pop", "-q", entry)
+	return err
+}
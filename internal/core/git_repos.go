@@ -0,0 +1,310 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrInvalidCloneSource = errors.New("clone source must be a repository or bundle under the root directory")
+	ErrMainWorktree       = errors.New("cannot remove the main worktree")
+	ErrWorktreePathNeeded = errors.New("the repository at the root directory has no sibling directory, give the worktree path")
+)
+
+// DefaultRepoScanDepth limits how deep DiscoverRepos descends below the root.
+const DefaultRepoScanDepth = 6
+
+// GitRepoInfo describes a repository found under the root.
+type GitRepoInfo struct {
+	Path     string `json:"path"`     // relative to the root
+	Worktree bool   `json:"worktree"` // a linked worktree rather than a main repository
+	Bare     bool   `json:"bare"`
+}
+
+// GitWorktree is an entry of `git worktree list`.
+type GitWorktree struct {
+	Path     string `json:"path"` // relative to the root, absolute if outside of it
+	Head     string `json:"head,omitempty"`
+	Branch   string `json:"branch,omitempty"`
+	Bare     bool   `json:"bare,omitempty"`
+	Detached bool   `json:"detached,omitempty"`
+	Locked   bool   `json:"locked,omitempty"`
+	Prunable bool   `json:"prunable,omitempty"`
+}
+
+// runStream executes git in dir writing combined stdout and stderr to out. Like run, a failure
+// is reported with the output so it can be classified.
+func (s *GitService) runStream(ctx context.Context, dir string, out io.Writer, args ...string) error {
+	cmd := exec.CommandContext(ctx, s.GitBin, args...)
+	cmd.Dir = dir
+	// only local transports: clones must not reach out to the network
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "LC_ALL=C", "GIT_ALLOW_PROTOCOL=file")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = io.MultiWriter(out, &stdout)
+	cmd.Stderr = io.MultiWriter(out, &stderr)
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return classifyGitError(&GitError{Args: args, ExitCode: exitErr.ExitCode(), Stdout: stdout.String(), Stderr: stderr.String()})
+		}
+		return err
+	}
+	return nil
+}
+
+// DiscoverRepos walks the root up to maxDepth levels and returns every git repository found.
+func (s *GitService) DiscoverRepos(ctx context.Context, maxDepth int) ([]GitRepoInfo, error) {
+	root, err := s.fs.resolve("")
+	if err != nil {
+		return nil, err
+	}
+	if maxDepth <= 0 {
+		maxDepth = DefaultRepoScanDepth
+	}
+	repos := []GitRepoInfo{}
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		if d.Name() == ".git" || d.Name() == "node_modules" {
+			return fs.SkipDir
+		}
+		if rel != "." && strings.Count(rel, string(os.PathSeparator)) >= maxDepth {
+			return fs.SkipDir
+		}
+		if rel == "." {
+			rel = ""
+		}
+		if fi, err := os.Lstat(filepath.Join(path, ".git")); err == nil {
+			repos = append(repos, GitRepoInfo{Path: filepath.ToSlash(rel), Worktree: !fi.IsDir()})
+		} else if isBareRepo(path) {
+			repos = append(repos, GitRepoInfo{Path: filepath.ToSlash(rel), Bare: true})
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return repos, err
+}
+
+// isBareRepo reports whether dir looks like a bare repository.
+func isBareRepo(dir string) bool {
+	for _, name := range []string{"HEAD", "objects", "refs"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// Init creates a new repository at path, creating the directory if needed.
+func (s *GitService) Init(ctx context.Context, path, initialBranch string, out io.Writer) error {
+	abs, err := s.fs.resolve(path)
+	if err != nil {
+		return err
+	}
+	args := []string{"init"}
+	if initialBranch != "" {
+		if strings.HasPrefix(initialBranch, "-") {
+			return ErrInvalidRefName
+		}
+		args = append(args, "--initial-branch="+initialBranch)
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return err
+	}
+	return s.runStream(ctx, abs, out, append(args, "--", abs)...)
+}
+
+// Clone clones a repository or bundle located under the root into dest.
+func (s *GitService) Clone(ctx context.Context, source, dest string, bare bool, out io.Writer) error {
+	src, err := s.fs.resolve(source)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return ErrInvalidCloneSource
+	}
+	if fi.IsDir() {
+		if _, err := os.Lstat(filepath.Join(src, ".git")); err != nil && !isBareRepo(src) {
+			return ErrInvalidCloneSource
+		}
+	}
+	dst, err := s.fs.resolve(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return ErrAlreadyExists
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	args := []string{"clone", "--progress"}
+	if bare {
+		args = append(args, "--bare")
+	}
+	// the source is about the size of the clone: refuse clones that cannot fit up front
+	estimate, _, _ := diskUsage(src)
+	if err := s.fs.Quota.Reserve(estimate, 0); err != nil {
+		return err
+	}
+	err = s.runStream(ctx, filepath.Dir(dst), out, append(args, "--", src, dst)...)
+	s.fs.Quota.Release(estimate, 0)
+	if err != nil {
+		return err
+	}
+	return s.chargeQuota(dst, func() error { return os.RemoveAll(dst) })
+}
+
+// CheckQuota returns ErrQuotaExceeded if the quota is used up, before starting a clone or
+// worktree job whose size is only known once it is done.
+func (s *GitService) CheckQuota() error {
+	return s.fs.Quota.Check()
+}
+
+// chargeQuota accounts the files git wrote to dst. When they exceed the quota they are removed
+// with undo and ErrQuotaExceeded is returned.
+func (s *GitService) chargeQuota(dst string, undo func() error) error {
+	if s.fs.Quota == nil {
+		return nil
+	}
+	bytes, files, err := diskUsage(dst)
+	if err != nil {
+		return err
+	}
+	if err := s.fs.Quota.Reserve(bytes, files); err != nil {
+		if uerr := undo(); uerr != nil {
+			slog.Warn("Failed to remove git checkout exceeding the quota", "path", dst, "error", uerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// ListWorktrees lists the worktrees of a repository.
+func (s *GitService) ListWorktrees(ctx context.Context, repo string) ([]GitWorktree, error) {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.run(ctx, dir, nil, "worktree", "list", "--porcelain", "-z")
+	if err != nil {
+		return nil, err
+	}
+	root, _ := s.fs.resolve("")
+	worktrees := []GitWorktree{}
+	var cur *GitWorktree
+	for _, line := range strings.Split(string(out), "\x00") {
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "worktree":
+			worktrees = append(worktrees, GitWorktree{Path: value})
+			cur = &worktrees[len(worktrees)-1]
+			if rel, err := filepath.Rel(root, value); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				cur.Path = filepath.ToSlash(rel)
+			}
+		case "HEAD":
+			cur.Head = value
+		case "branch":
+			cur.Branch = strings.TrimPrefix(value, "refs/heads/")
+		case "bare":
+			cur.Bare = true
+		case "detached":
+			cur.Detached = true
+		case "locked":
+			cur.Locked = true
+		case "prunable":
+			cur.Prunable = true
+		}
+	}
+	return worktrees, nil
+}
+
+// AddWorktree checks out branch (creating it from HEAD when newBranch is set) into a new worktree at path.
+// An empty path places the worktree next to the repository as "<repo>-<branch>".
+func (s *GitService) AddWorktree(ctx context.Context, repo, path, branch string, newBranch bool, out io.Writer) (string, error) {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return "", err
+	}
+	if err := s.checkRefName(ctx, dir, branch); err != nil {
+		return "", err
+	}
+	if path == "" {
+		// a sibling of the repository, which a repository at the root does not have
+		repoPath := filepath.Clean("/" + repo)
+		if repoPath == "/" {
+			return "", ErrWorktreePathNeeded
+		}
+		path = filepath.Join(filepath.Dir(repoPath), filepath.Base(dir)+"-"+strings.ReplaceAll(branch, "/", "-"))
+	}
+	dst, err := s.fs.resolve(path)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(dst); err == nil {
+		return "", ErrAlreadyExists
+	}
+	args := []string{"worktree", "add"}
+	if newBranch {
+		args = append(args, "-b", branch, "--", dst)
+	} else {
+		args = append(args, "--", dst, branch)
+	}
+	unlock := lockRepo(dir)
+	defer unlock()
+	if err := s.runStream(ctx, dir, out, args...); err != nil {
+		return "", err
+	}
+	err = s.chargeQuota(dst, func() error {
+		// the job may have been canceled, the cleanup must still run
+		if _, err := s.run(context.Background(), dir, nil, "worktree", "remove", "--force", "--", dst); err != nil {
+			return err
+		}
+		if newBranch {
+			_, err := s.run(context.Background(), dir, nil, "branch", "-D", "--", branch)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+path)), "/"), nil
+}
+
+// RemoveWorktree removes the linked worktree at path. Worktrees with local changes are only
+// removed when force is true.
+func (s *GitService) RemoveWorktree(ctx context.Context, repo, path string, force bool, out io.Writer) error {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return err
+	}
+	dst, err := s.fs.resolve(path)
+	if err != nil {
+		return err
+	}
+	if dst == dir {
+		return ErrMainWorktree
+	}
+	args := []string{"worktree", "remove"}
+	if force {
+		args = append(args, "--force")
+	}
+	unlock := lockRepo(dir)
+	defer unlock()
+	return s.runStream(ctx, dir, out, append(args, "--", dst)...)
+}
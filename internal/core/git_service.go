@@ -0,0 +1,341 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrNotRepository   = errors.New("not a git repository")
+	ErrInvalidRevision = errors.New("invalid revision")
+	ErrRevNotFound     = errors.New("revision or path not found")
+)
+
+// GitError is returned when a git command exits with a non-zero status.
+type GitError struct {
+	Args     []string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+func (e *GitError) Error() string {
+	msg := strings.TrimSpace(e.Stderr)
+	if msg == "" {
+		msg = fmt.Sprintf("exit status %d", e.ExitCode)
+	}
+	return fmt.Sprintf("git %s: %s", strings.Join(e.Args, " "), msg)
+}
+
+// GitService runs the local git binary against repositories located under the file service root.
+type GitService struct {
+	fs     *LocalFileServiceImpl
+	GitBin string
+
+	logCache   *lruCache[string, *GitLogPage]
+	blameCache *lruCache[string, []GitBlameRange]
+}
+
+// NewGitService constructs a GitService confined to the root of the given file service.
+func NewGitService(fs *LocalFileServiceImpl) *GitService {
+	return &GitService{
+		fs:         fs,
+		GitBin:     "git",
+		logCache:   newLRUCache[string, *GitLogPage](256),
+		blameCache: newLRUCache[string, []GitBlameRange](128),
+	}
+}
+
+// GitFileChange describes a single changed path in the working tree or index.
+type GitFileChange struct {
+	Path     string `json:"path"`
+	OrigPath string `json:"origPath,omitempty"`
+	Status   string `json:"status"`
+}
+
+// GitStatus is the parsed output of git status for a repository.
+type GitStatus struct {
+	Branch     string          `json:"branch"`
+	Head       string          `json:"head"`
+	Upstream   string          `json:"upstream,omitempty"`
+	Ahead      int             `json:"ahead"`
+	Behind     int             `json:"behind"`
+	Staged     []GitFileChange `json:"staged"`
+	Unstaged   []GitFileChange `json:"unstaged"`
+	Untracked  []GitFileChange `json:"untracked"`
+	Conflicted []GitFileChange `json:"conflicted"`
+}
+
+// Diff targets accepted by GitService.Diff.
+const (
+	DiffAgainstIndex = "index"  // working tree vs index
+	DiffAgainstHead  = "head"   // working tree vs HEAD
+	DiffStaged       = "staged" // index vs HEAD
+)
+
+// repoDir resolves a repository path relative to the root and verifies it is the top level of a git work tree.
+func (s *GitService) repoDir(ctx context.Context, repo string) (string, error) {
+	abs, err := s.fs.resolve(repo)
+	if err != nil {
+		return "", err
+	}
+	if fi, err := os.Stat(abs); err != nil || !fi.IsDir() {
+		return "", ErrNotRepository
+	}
+	out, err := s.run(ctx, abs, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", ErrNotRepository
+	}
+	// reject subdirectories of a repository, which may live outside the root
+	top := filepath.Clean(strings.TrimSpace(string(out)))
+	real, _ := filepath.EvalSymlinks(abs)
+	if top != abs && top != real {
+		return "", ErrNotRepository
+	}
+	return abs, nil
+}
+
+// run executes git in dir and returns its stdout. stdin may be nil.
+func (s *GitService) run(ctx context.Context, dir string, stdin []byte, args ...string) ([]byte, error) {
+	return s.runEnv(ctx, dir, stdin, nil, args...)
+}
+
+// runEnv is like run with extra environment variables.
+func (s *GitService) runEnv(ctx context.Context, dir string, stdin []byte, env []string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, s.GitBin, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "LC_ALL=C")
+	cmd.Env = append(cmd.Env, env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return stdout.Bytes(), &GitError{Args: args, ExitCode: exitErr.ExitCode(), Stdout: stdout.String(), Stderr: stderr.String()}
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// Status returns staged, unstaged, untracked and conflicted changes of a repository.
+func (s *GitService) Status(ctx context.Context, repo string) (*GitStatus, error) {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.run(ctx, dir, nil, "--no-optional-locks", "status", "--porcelain=v2", "-z", "--branch", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	return parseGitStatus(out), nil
+}
+
+// Diff returns a unified diff of path (or the whole repository when empty) against the given target.
+func (s *GitService) Diff(ctx context.Context, repo, path, against string) ([]byte, error) {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--no-optional-locks", "diff", "--no-color", "--no-ext-diff"}
+	switch against {
+	case "", DiffAgainstIndex:
+	case DiffAgainstHead:
+		args = append(args, "HEAD")
+	case DiffStaged:
+		args = append(args, "--cached")
+	default:
+		return nil, fmt.Errorf("%w: unknown diff target %q", ErrInvalidRevision, against)
+	}
+	args = append(args, "--")
+	if path != "" {
+		args = append(args, path)
+	}
+	return s.run(ctx, dir, nil, args...)
+}
+
+// Show returns the contents of path at the given revision. A stage between 0 and 3 reads
+// the blob from that index stage instead and ignores ref.
+func (s *GitService) Show(ctx context.Context, repo, ref, path string, stage int) ([]byte, error) {
+	dir, err := s.repoDir(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := blobSpec(ref, path, stage)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.run(ctx, dir, nil, "cat-file", "blob", spec)
+	if err != nil {
+		return nil, mapGitLookupError(err)
+	}
+	return out, nil
+}
+
+// blobSpec builds a "<rev>:<path>" or ":<stage>:<path>" object name.
+func blobSpec(ref, path string, stage int) (string, error) {
+	path = strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+path)), "/")
+	if stage >= 0 {
+		if stage > 3 {
+			return "", fmt.Errorf("%w: stage must be between 0 and 3", ErrInvalidRevision)
+		}
+		return fmt.Sprintf(":%d:%s", stage, path), nil
+	}
+	if err := validateRef(ref); err != nil {
+		return "", err
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	return ref + ":" + path, nil
+}
+
+// validateRef rejects revisions that git could interpret as options.
+func validateRef(ref string) error {
+	if strings.HasPrefix(ref, "-") || strings.ContainsAny(ref, "\x00\n") {
+		return fmt.Errorf("%w: %q", ErrInvalidRevision, ref)
+	}
+	return nil
+}
+
+// mapGitLookupError turns git's "object does not exist" failures into ErrRevNotFound.
+func mapGitLookupError(err error) error {
+	var gitErr *GitError
+	if errors.As(err, &gitErr) {
+		msg := gitErr.Stderr
+		if strings.Contains(msg, "Not a valid object name") || strings.Contains(msg, "does not exist") ||
+			strings.Contains(msg, "invalid object name") || strings.Contains(msg, "bad revision") ||
+			strings.Contains(msg, "unknown revision") || strings.Contains(msg, "not in") {
+			return ErrRevNotFound
+		}
+	}
+	return err
+}
+
+// parseGitStatus parses `git status --porcelain=v2 -z --branch` output.
+func parseGitStatus(out []byte) *GitStatus {
+	st := &GitStatus{
+		Staged:     []GitFileChange{},
+		Unstaged:   []GitFileChange{},
+		Untracked:  []GitFileChange{},
+		Conflicted: []GitFileChange{},
+	}
+	records := strings.Split(string(out), "\x00")
+	for i := 0; i < len(records); i++ {
+		rec := records[i]
+		if rec == "" {
+			continue
+		}
+		switch rec[0] {
+		case '#':
+			parseBranchHeader(st, rec)
+		case '1':
+			// 1 XY sub mH mI mW hH hI path
+			fields := strings.SplitN(rec, " ", 9)
+			if len(fields) == 9 {
+				addChange(st, fields[1], fields[8], "")
+			}
+		case '2':
+			// 2 XY sub mH mI mW hH hI Xscore path NUL origPath
+			fields := strings.SplitN(rec, " ", 10)
+			if len(fields) == 10 && i+1 < len(records) {
+				i++
+				addChange(st, fields[1], fields[9], records[i])
+			}
+		case 'u':
+			// u XY sub m1 m2 m3 mW h1 h2 h3 path
+			fields := strings.SplitN(rec, " ", 11)
+			if len(fields) == 11 {
+				st.Conflicted = append(st.Conflicted, GitFileChange{Path: fields[10], Status: conflictStatus(fields[1])})
+			}
+		case '?':
+			st.Untracked = append(st.Untracked, GitFileChange{Path: rec[2:], Status: "untracked"})
+		}
+	}
+	return st
+}
+
+func parseBranchHeader(st *GitStatus, rec string) {
+	fields := strings.Fields(rec)
+	if len(fields) < 3 {
+		return
+	}
+	switch fields[1] {
+	case "branch.oid":
+		st.Head = fields[2]
+	case "branch.head":
+		st.Branch = fields[2]
+	case "branch.upstream":
+		st.Upstream = fields[2]
+	case "branch.ab":
+		if len(fields) == 4 {
+			st.Ahead, _ = strconv.Atoi(strings.TrimPrefix(fields[2], "+"))
+			st.Behind, _ = strconv.Atoi(strings.TrimPrefix(fields[3], "-"))
+		}
+	}
+}
+
+func addChange(st *GitStatus, xy, path, origPath string) {
+	if len(xy) != 2 {
+		return
+	}
+	if xy[0] != '.' {
+		st.Staged = append(st.Staged, GitFileChange{Path: path, OrigPath: origPath, Status: changeStatus(xy[0])})
+	}
+	if xy[1] != '.' {
+		change := GitFileChange{Path: path, Status: changeStatus(xy[1])}
+		if xy[0] == '.' {
+			change.OrigPath = origPath
+		}
+		st.Unstaged = append(st.Unstaged, change)
+	}
+}
+
+func changeStatus(c byte) string {
+	switch c {
+	case 'M':
+		return "modified"
+	case 'A':
+		return "added"
+	case 'D':
+		return "deleted"
+	case 'R':
+		return "renamed"
+	case 'C':
+		return "copied"
+	case 'T':
+		return "typechange"
+	}
+	return string(c)
+}
+
+func conflictStatus(xy string) string {
+	switch xy {
+	case "DD":
+		return "both deleted"
+	case "AU":
+		return "added by us"
+	case "UD":
+		return "deleted by them"
+	case "UA":
+		return "added by them"
+	case "DU":
+		return "deleted by us"
+	case "AA":
+		return "both added"
+	case "UU":
+		return "both modified"
+	}
+	return xy
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseGitStatus(t *testing.T) {
+	const (
+		oid  = "0b58042b29b03b06a1b66386b8f1d29f6d9b7452"
+		blob = "f2ad6c76f0115a6ba5b00456a849810e7ec0af20"
+	)
+	// records as printed by git status --porcelain=v2 -z --branch
+	status := func(records ...string) []byte {
+		return []byte(strings.Join(records, "\x00") + "\x00")
+	}
+	tests := []struct {
+		name string
+		out  []byte
+		want *GitStatus
+	}{
+		{
+			name: "empty",
+			out:  nil,
+			want: &GitStatus{},
+		},
+		{
+			name: "branch with upstream",
+			out: status(
+				"# branch.oid "+oid,
+				"# branch.head main",
+				"# branch.upstream origin/main",
+				"# branch.ab +2 -3",
+			),
+			want: &GitStatus{Branch: "main", Head: oid, Upstream: "origin/main", Ahead: 2, Behind: 3},
+		},
+		{
+			name: "unborn branch",
+			out:  status("# branch.oid (initial)", "# branch.head main"),
+			want: &GitStatus{Branch: "main", Head: "(initial)"},
+		},
+		{
+			name: "staged and unstaged changes of the same file",
+			out:  status("1 MM N... 100644 100644 100644 " + blob + " " + blob + " a.txt"),
+			want: &GitStatus{
+				Staged:   []GitFileChange{{Path: "a.txt", Status: "modified"}},
+				Unstaged: []GitFileChange{{Path: "a.txt", Status: "modified"}},
+			},
+		},
+		{
+			name: "added and deleted",
+			out: status(
+				"1 A. N... 000000 100644 100644 "+strings.Repeat("0", 40)+" "+blob+" new file.txt",
+				"1 .D N... 100644 100644 000000 "+blob+" "+blob+" gone.txt",
+			),
+			want: &GitStatus{
+				Staged:   []GitFileChange{{Path: "new file.txt", Status: "added"}},
+				Unstaged: []GitFileChange{{Path: "gone.txt", Status: "deleted"}},
+			},
+		},
+		{
+			name: "staged rename",
+			out:  status("2 R. N... 100644 100644 100644 "+blob+" "+blob+" R100 new.txt", "old.txt"),
+			want: &GitStatus{
+				Staged: []GitFileChange{{Path: "new.txt", OrigPath: "old.txt", Status: "renamed"}},
+			},
+		},
+		{
+			name: "rename followed by more records",
+			out: status(
+				"2 R. N... 100644 100644 100644 "+blob+" "+blob+" R087 b.txt", "a.txt",
+				"? untracked.txt",
+			),
+			want: &GitStatus{
+				Staged:    []GitFileChange{{Path: "b.txt", OrigPath: "a.txt", Status: "renamed"}},
+				Untracked: []GitFileChange{{Path: "untracked.txt", Status: "untracked"}},
+			},
+		},
+		{
+			name: "conflicts",
+			out: status(
+				"u UU N... 100644 100644 100644 100644 "+blob+" "+blob+" "+blob+" c.txt",
+				"u AA N... 000000 100644 100644 100644 "+strings.Repeat("0", 40)+" "+blob+" "+blob+" both added.txt",
+			),
+			want: &GitStatus{
+				Conflicted: []GitFileChange{
+					{Path: "c.txt", Status: "both modified"},
+					{Path: "both added.txt", Status: "both added"},
+				},
+			},
+		},
+		{
+			name: "untracked path with spaces",
+			out:  status("? sp ace.txt"),
+			want: &GitStatus{Untracked: []GitFileChange{{Path: "sp ace.txt", Status: "untracked"}}},
+		},
+		{
+			name: "ignored and malformed records are skipped",
+			out:  status("! build/", "1 M. truncated", "2 R. N... truncated"),
+			want: &GitStatus{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the lists are never nil so they encode as []
+			for _, list := range []*[]GitFileChange{&tt.want.Staged, &tt.want.Unstaged, &tt.want.Untracked, &tt.want.Conflicted} {
+				if *list == nil {
+					*list = []GitFileChange{}
+				}
+			}
+			if got := parseGitStatus(tt.out); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseGitStatus() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gitFileInfo is an os.FileInfo for an entry of a git tree object.
+type gitFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *gitFileInfo) Name() string       { return fi.name }
+func (fi *gitFileInfo) Size() int64        { return fi.size }
+func (fi *gitFileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *gitFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *gitFileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *gitFileInfo) Sys() any           { return nil }
+
+// treeRef identifies a path inside the tree of a resolved commit.
+type treeRef struct {
+	dir        string // repository top level
+	commit     string
+	commitTime time.Time
+	path       string // slash separated, relative to dir, "" for the top level
+}
+
+// findRepo walks up from rel (relative to the root) to the closest directory containing .git,
+// never leaving the root. It returns the repository directory and rel relative to it.
+func (s *GitService) findRepo(rel string) (string, string, error) {
+	abs, err := s.fs.resolve(rel)
+	if err != nil {
+		return "", "", err
+	}
+	root, err := s.fs.resolve("")
+	if err != nil {
+		return "", "", err
+	}
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			inRepo, err := filepath.Rel(dir, abs)
+			if err != nil {
+				return "", "", err
+			}
+			if inRepo == "." {
+				inRepo = ""
+			}
+			return dir, filepath.ToSlash(inRepo), nil
+		}
+		if dir == root || dir == filepath.Dir(dir) {
+			return "", "", ErrNotRepository
+		}
+	}
+}
+
+// resolveTreeRef locates the repository containing rel and resolves ref to a commit.
+func (s *GitService) resolveTreeRef(ctx context.Context, rel, ref string) (*treeRef, error) {
+	if err := validateRef(ref); err != nil {
+		return nil, err
+	}
+	dir, inRepo, err := s.findRepo(rel)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.run(ctx, dir, nil, "show", "-s", "--format=%H %ct", ref+"^{commit}", "--")
+	if err != nil {
+		return nil, mapGitLookupError(err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) != 2 {
+		return nil, ErrRevNotFound
+	}
+	sec, _ := strconv.ParseInt(fields[1], 10, 64)
+	return &treeRef{dir: dir, commit: fields[0], commitTime: time.Unix(sec, 0), path: inRepo}, nil
+}
+
+// StatAt returns file info for rel as it was at the given revision.
+func (s *GitService) StatAt(ctx context.Context, rel, ref string) (os.FileInfo, error) {
+	tr, err := s.resolveTreeRef(ctx, rel, ref)
+	if err != nil {
+		return nil, err
+	}
+	if tr.path == "" {
+		return &gitFileInfo{name: filepath.Base(tr.dir), mode: fs.ModeDir | 0o755, modTime: tr.commitTime}, nil
+	}
+	out, err := s.run(ctx, tr.dir, nil, "ls-tree", "-l", "-z", tr.commit, "--", tr.path)
+	if err != nil {
+		return nil, mapGitLookupError(err)
+	}
+	entries := parseLsTree(out, tr.commitTime)
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	entries[0].name = path.Base(tr.path)
+	return entries[0], nil
+}
+
+// ListAt lists the directory rel as it was at the given revision.
+func (s *GitService) ListAt(ctx context.Context, rel, ref string) ([]os.FileInfo, error) {
+	tr, err := s.resolveTreeRef(ctx, rel, ref)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.run(ctx, tr.dir, nil, "ls-tree", "-l", "-z", tr.commit+":"+tr.path)
+	if err != nil {
+		if fi, serr := s.StatAt(ctx, rel, ref); serr == nil && !fi.IsDir() {
+			return nil, ErrNotDirectory
+		}
+		return nil, ErrNotFound
+	}
+	entries := parseLsTree(out, tr.commitTime)
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		infos = append(infos, e)
+	}
+	return infos, nil
+}
+
+// ReadFileAt returns the contents of the file rel as it was at the given revision.
+func (s *GitService) ReadFileAt(ctx context.Context, rel, ref string) ([]byte, error) {
+	tr, err := s.resolveTreeRef(ctx, rel, ref)
+	if err != nil {
+		return nil, err
+	}
+	if tr.path == "" {
+		return nil, ErrIsDirectory
+	}
+	out, err := s.run(ctx, tr.dir, nil, "cat-file", "blob", tr.commit+":"+tr.path)
+	if err != nil {
+		if fi, serr := s.StatAt(ctx, rel, ref); serr == nil && fi.IsDir() {
+			return nil, ErrIsDirectory
+		}
+		return nil, ErrNotFound
+	}
+	return out, nil
+}
+
+// parseLsTree parses `git ls-tree -l -z` output: "<mode> <type> <oid> <size>\t<name>".
+func parseLsTree(out []byte, modTime time.Time) []*gitFileInfo {
+	var entries []*gitFileInfo
+	for _, rec := range strings.Split(string(out), "\x00") {
+		meta, name, ok := strings.Cut(rec, "\t")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 4 {
+			continue
+		}
+		fi := &gitFileInfo{name: path.Base(name), modTime: modTime}
+		fi.size, _ = strconv.ParseInt(fields[3], 10, 64)
+		switch fields[0] {
+		case "040000", "160000": // tree or submodule
+			fi.mode = fs.ModeDir | 0o755
+		case "120000":
+			fi.mode = fs.ModeSymlink | 0o777
+		case "100755":
+			fi.mode = 0o755
+		default:
+			fi.mode = 0o644
+		}
+		entries = append(entries, fi)
+	}
+	return entries
+}
@@ -0,0 +1,274 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultHostMetricsInterval is how often host metrics are sampled.
+	DefaultHostMetricsInterval = 5 * time.Second
+	// DefaultHostMetricsHistory is the number of samples kept.
+	DefaultHostMetricsHistory = 120
+
+	hostSampleMinAge = time.Second // age before Latest replaces a sample taken on request
+)
+
+// HostSample is a reading of the resources of the host.
+type HostSample struct {
+	Time   time.Time   `json:"time"`
+	CPU    CPUUsage    `json:"cpu"`
+	Load   [3]float64  `json:"load"` // 1, 5 and 15 minute load averages
+	Memory MemoryUsage `json:"memory"`
+	Swap   MemoryUsage `json:"swap"`
+	Disk   DiskUsage   `json:"disk"`
+}
+
+// CPUUsage is the share of CPU time spent busy since the previous sample.
+type CPUUsage struct {
+	Usage float64 `json:"usage"` // percent of all cores
+	Cores int     `json:"cores"`
+}
+
+// MemoryUsage is in bytes.
+type MemoryUsage struct {
+	Total     uint64 `json:"total"`
+	Used      uint64 `json:"used"`
+	Available uint64 `json:"available"`
+}
+
+// DiskUsage of the filesystem containing the root directory, in bytes. Available is what
+// unprivileged users may still write.
+type DiskUsage struct {
+	Total     uint64 `json:"total"`
+	Used      uint64 `json:"used"`
+	Available uint64 `json:"available"`
+}
+
+type cpuTimes struct {
+	busy, total uint64
+}
+
+// HostMetrics samples host resources and keeps a short history of samples.
+type HostMetrics struct {
+	rootDir string
+
+	mu          sync.Mutex
+	prev        cpuTimes
+	history     []HostSample // ring buffer
+	next        int          // where the next sample goes
+	full        bool
+	sampling    bool // Run takes the samples; otherwise they are taken on request
+	subscribers map[chan HostSample]struct{}
+}
+
+func NewHostMetrics(rootDir string, history int) *HostMetrics {
+	if history <= 0 {
+		history = DefaultHostMetricsHistory
+	}
+	return &HostMetrics{
+		rootDir:     rootDir,
+		history:     make([]HostSample, history),
+		subscribers: make(map[chan HostSample]struct{}),
+	}
+}
+
+// Run samples every interval until ctx is done.
+func (h *HostMetrics) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	h.mu.Lock()
+	h.sampling = true
+	h.mu.Unlock()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := h.Sample(); err != nil {
+			slog.Warn("failed to sample host metrics", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sample reads the host resources, records the sample and sends it to subscribers.
+func (h *HostMetrics) Sample() (HostSample, error) {
+	s := HostSample{Time: time.Now()}
+	times, cores, err := readCPUTimes()
+	if err != nil {
+		return s, err
+	}
+	if s.Load, err = readLoadAvg(); err != nil {
+		return s, err
+	}
+	if s.Memory, s.Swap, err = readMemInfo(); err != nil {
+		return s, err
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(h.rootDir, &fs); err != nil {
+		return s, err
+	}
+	bsize := uint64(fs.Bsize)
+	s.Disk = DiskUsage{
+		Total:     fs.Blocks * bsize,
+		Used:      (fs.Blocks - fs.Bfree) * bsize,
+		Available: fs.Bavail * bsize,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// the first sample covers the time since boot
+	if times.total > h.prev.total && times.busy >= h.prev.busy {
+		s.CPU.Usage = float64(times.busy-h.prev.busy) / float64(times.total-h.prev.total) * 100
+		h.prev = times
+	}
+	s.CPU.Cores = cores
+	h.history[h.next] = s
+	h.next = (h.next + 1) % len(h.history)
+	h.full = h.full || h.next == 0
+	for ch := range h.subscribers {
+		select {
+		case ch <- s:
+		default:
+		}
+	}
+	return s, nil
+}
+
+// Latest returns the most recent sample, taking one if there is none yet. Without Run, a
+// sample older than hostSampleMinAge is replaced by a fresh one.
+func (h *HostMetrics) Latest() (HostSample, error) {
+	h.mu.Lock()
+	if h.next > 0 || h.full {
+		s := h.history[(h.next-1+len(h.history))%len(h.history)]
+		if h.sampling || time.Since(s.Time) < hostSampleMinAge {
+			h.mu.Unlock()
+			return s, nil
+		}
+	}
+	h.mu.Unlock()
+	return h.Sample()
+}
+
+// History returns the recorded samples, oldest first.
+func (h *HostMetrics) History() []HostSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]HostSample(nil), h.history[:h.next]...)
+	}
+	return append(append([]HostSample(nil), h.history[h.next:]...), h.history[:h.next]...)
+}
+
+// Subscribe returns a channel of the samples taken from now on. The channel is closed when
+// unsubscribe is called.
+func (h *HostMetrics) Subscribe() (<-chan HostSample, func()) {
+	ch := make(chan HostSample, 16)
+	h.mu.Lock()
+	h.subscribers[ch] = struct{}{}
+	h.mu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			delete(h.subscribers, ch)
+			close(ch)
+		})
+	}
+}
+
+// readCPUTimes sums the CPU times of all cores from /proc/stat.
+func readCPUTimes() (cpuTimes, int, error) {
+	f, err := os.Open(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return cpuTimes{}, 0, err
+	}
+	defer f.Close()
+	var times cpuTimes
+	cores := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+		if fields[0] != "cpu" {
+			cores++
+			continue
+		}
+		// user nice system idle iowait irq softirq steal; guest time is included in user
+		for i, field := range fields[1:min(len(fields), 9)] {
+			v, _ := strconv.ParseUint(field, 10, 64)
+			times.total += v
+			if i != 3 && i != 4 {
+				times.busy += v
+			}
+		}
+	}
+	if times.total == 0 {
+		return times, cores, fmt.Errorf("malformed %s", f.Name())
+	}
+	return times, cores, scanner.Err()
+}
+
+func readLoadAvg() ([3]float64, error) {
+	var load [3]float64
+	data, err := os.ReadFile(filepath.Join(procRoot, "loadavg"))
+	if err != nil {
+		return load, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return load, fmt.Errorf("malformed loadavg")
+	}
+	for i := range load {
+		load[i], _ = strconv.ParseFloat(fields[i], 64)
+	}
+	return load, nil
+}
+
+// readMemInfo reads memory and swap usage from /proc/meminfo.
+func readMemInfo() (MemoryUsage, MemoryUsage, error) {
+	var mem, swap MemoryUsage
+	f, err := os.Open(filepath.Join(procRoot, "meminfo"))
+	if err != nil {
+		return mem, swap, err
+	}
+	defer f.Close()
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		v, _ := strconv.ParseUint(fields[0], 10, 64)
+		values[name] = v * 1024 // kB
+	}
+	if err := scanner.Err(); err != nil {
+		return mem, swap, err
+	}
+	mem.Total, mem.Available = values["MemTotal"], values["MemAvailable"]
+	mem.Used = mem.Total - min(mem.Available, mem.Total)
+	swap.Total, swap.Available = values["SwapTotal"], values["SwapFree"]
+	swap.Used = swap.Total - min(swap.Available, swap.Total)
+	return mem, swap, nil
+}
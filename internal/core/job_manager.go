@@ -0,0 +1,251 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+// JobStatus is the lifecycle state of a background job.
+type JobStatus string
+
+const (
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+)
+
+const (
+	maxJobOutput   = 1 << 20 // bytes of output kept per job for late subscribers
+	maxFinishedJob = 100     // finished jobs kept before the oldest are forgotten
+)
+
+// jobOutputGap marks where a slow subscriber missed output.
+const jobOutputGap = "\n[output skipped]\n"
+
+// JobFunc is the work of a job. Everything written to out is streamed to subscribers.
+// The returned result is exposed with the job info once it finishes.
+type JobFunc func(ctx context.Context, out io.Writer) (any, error)
+
+// JobInfo is a snapshot of a job.
+type JobInfo struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
+	Description string     `json:"description"`
+	Status      JobStatus  `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Result      any        `json:"result,omitempty"`
+	StartedAt   time.Time  `json:"startedAt"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+}
+
+// Job is a tracked background operation with buffered, streamable output.
+type Job struct {
+	mu          sync.Mutex
+	info        JobInfo
+	output      []byte
+	subscribers map[chan []byte]bool // true if the subscriber missed output since its last chunk
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// Info returns a snapshot of the job.
+func (j *Job) Info() JobInfo {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.info
+}
+
+// Done is closed when the job finishes.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Write appends to the job output and fans it out to subscribers. Slow subscribers miss chunks
+// rather than blocking the job; their next chunk starts with a gap marker.
+func (j *Job) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if room := maxJobOutput - len(j.output); room > 0 {
+		j.output = append(j.output, p[:min(len(p), room)]...)
+	}
+	for ch, missed := range j.subscribers {
+		chunk := append([]byte(nil), p...)
+		if missed {
+			chunk = append([]byte(jobOutputGap), chunk...)
+		}
+		select {
+		case ch <- chunk:
+			j.subscribers[ch] = false
+		default:
+			j.subscribers[ch] = true
+		}
+	}
+	return len(p), nil
+}
+
+// Subscribe returns the output produced so far and a channel of further output. The channel
+// is closed when the job finishes or unsubscribe is called.
+func (j *Job) Subscribe() ([]byte, <-chan []byte, func()) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	backlog := append([]byte(nil), j.output...)
+	ch := make(chan []byte, 64)
+	if j.info.Status != JobRunning {
+		close(ch)
+		return backlog, ch, func() {}
+	}
+	j.subscribers[ch] = false
+	var once sync.Once
+	return backlog, ch, func() {
+		once.Do(func() {
+			j.mu.Lock()
+			defer j.mu.Unlock()
+			if _, ok := j.subscribers[ch]; ok {
+				delete(j.subscribers, ch)
+				close(ch)
+			}
+		})
+	}
+}
+
+func (j *Job) finish(result any, err error, canceled bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.info.FinishedAt = &now
+	j.info.Result = result
+	switch {
+	case canceled:
+		j.info.Status = JobCanceled
+	case err != nil:
+		j.info.Status = JobFailed
+	default:
+		j.info.Status = JobSucceeded
+	}
+	if err != nil {
+		j.info.Error = err.Error()
+	}
+	for ch := range j.subscribers {
+		close(ch)
+	}
+	j.subscribers = nil
+	close(j.done)
+}
+
+// JobManager runs and tracks background jobs.
+type JobManager struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+func NewJobManager() *JobManager {
+	return &JobManager{jobs: make(map[string]*Job)}
+}
+
+// Start runs fn in the background as a new job.
+func (m *JobManager) Start(kind, description string, fn JobFunc) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		info: JobInfo{
+			ID:          uuid.NewString(),
+			Kind:        kind,
+			Description: description,
+			Status:      JobRunning,
+			StartedAt:   time.Now(),
+		},
+		subscribers: make(map[chan []byte]bool),
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.jobs[job.info.ID] = job
+	m.pruneLocked()
+	m.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		result, err := fn(ctx, job)
+		job.finish(result, err, ctx.Err() != nil)
+	}()
+	return job
+}
+
+// Get returns the job with the given id.
+func (m *JobManager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// List returns all tracked jobs, most recent first.
+func (m *JobManager) List() []JobInfo {
+	m.mu.Lock()
+	out := make([]JobInfo, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		out = append(out, job.Info())
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.After(out[j].StartedAt) })
+	return out
+}
+
+// Cancel requests a running job to stop.
+func (m *JobManager) Cancel(id string) error {
+	job, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	job.cancel()
+	return nil
+}
+
+// CancelAll requests every running job to stop and waits up to timeout for them to finish.
+func (m *JobManager) CancelAll(timeout time.Duration) {
+	m.mu.Lock()
+	jobs := make([]*Job, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	m.mu.Unlock()
+	for _, job := range jobs {
+		job.cancel()
+	}
+	deadline := time.After(timeout)
+	for _, job := range jobs {
+		select {
+		case <-job.Done():
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// pruneLocked forgets the oldest finished jobs beyond maxFinishedJob.
+func (m *JobManager) pruneLocked() {
+	var finished []*Job
+	for _, job := range m.jobs {
+		if job.Info().Status != JobRunning {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedJob {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool { return finished[i].Info().StartedAt.Before(finished[j].Info().StartedAt) })
+	for _, job := range finished[:len(finished)-maxFinishedJob] {
+		delete(m.jobs, job.Info().ID)
+	}
+}
@@ -0,0 +1,50 @@
+package core
+
+// stripJSONC turns JSON with comments, as used by VS Code configuration files, into plain JSON
+// by removing // and /* */ comments and trailing commas. Offsets of the remaining content
+// shift, but string contents are preserved.
+func stripJSONC(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	// index in out of a comma that may turn out to be trailing
+	pendingComma := -1
+	for i := 0; i < len(data); i++ {
+		ch := data[i]
+		switch {
+		case ch == '"':
+			pendingComma = -1
+			start := i
+			for i++; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+			out = append(out, data[start:min(i+1, len(data))]...)
+		case ch == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			i--
+		case ch == '/' && i+1 < len(data) && data[i+1] == '*':
+			i += 2
+			for i+1 < len(data) && !(data[i] == '*' && data[i+1] == '/') {
+				i++
+			}
+			i++
+		case ch == ',':
+			pendingComma = len(out)
+			out = append(out, ch)
+		case ch == '}' || ch == ']':
+			if pendingComma >= 0 {
+				out = append(out[:pendingComma], out[pendingComma+1:]...)
+				pendingComma = -1
+			}
+			out = append(out, ch)
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			out = append(out, ch)
+		default:
+			pendingComma = -1
+			out = append(out, ch)
+		}
+	}
+	return out
+}
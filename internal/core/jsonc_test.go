@@ -0,0 +1,78 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStripJSONC(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string // equivalent JSON
+	}{
+		{
+			name: "plain JSON",
+			in:   `{"a": [1, 2], "b": {"c": null}}`,
+			want: `{"a": [1, 2], "b": {"c": null}}`,
+		},
+		{
+			name: "line comments",
+			in:   "// settings\n{\n  \"a\": 1, // one\n  \"b\": 2\n}\n",
+			want: `{"a": 1, "b": 2}`,
+		},
+		{
+			name: "block comments",
+			in:   "{\n  /* the\n     version */ \"version\": \"2.0.0\" /* inline */\n}",
+			want: `{"version": "2.0.0"}`,
+		},
+		{
+			name: "trailing commas",
+			in:   `{"tasks": [{"label": "build",}, {"label": "test"},],}`,
+			want: `{"tasks": [{"label": "build"}, {"label": "test"}]}`,
+		},
+		{
+			name: "trailing comma before a comment",
+			in:   "{\"args\": [\"-v\", // verbose\n],\n}",
+			want: `{"args": ["-v"]}`,
+		},
+		{
+			name: "comment markers inside strings",
+			in:   `{"url": "http://example.com//x", "glob": "src/**/*.go", "c": "/* kept */"}`,
+			want: `{"url": "http://example.com//x", "glob": "src/**/*.go", "c": "/* kept */"}`,
+		},
+		{
+			name: "escaped quotes and commas inside strings",
+			in:   `{"cmd": "echo \"a, b\" // c", "s": ",]", "t": "\\"}`,
+			want: `{"cmd": "echo \"a, b\" // c", "s": ",]", "t": "\\"}`,
+		},
+		{
+			name: "comma between values is kept",
+			in:   `[1, /* two */ 2]`,
+			want: `[1, 2]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := stripJSONC([]byte(tt.in))
+			var got, want any
+			if err := json.Unmarshal(out, &got); err != nil {
+				t.Fatalf("stripJSONC(%q) = %q, not JSON: %v", tt.in, out, err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("stripJSONC(%q) = %s, want %s", tt.in, out, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripJSONCUnterminated(t *testing.T) {
+	// malformed input must not panic; json.Unmarshal reports the error
+	for _, in := range []string{`{"a": "open`, `{"a": 1 /* open`, `{"a": 1 //`, `/`, `"\`} {
+		stripJSONC([]byte(in))
+	}
+}
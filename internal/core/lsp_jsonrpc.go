@@ -0,0 +1,326 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// maxRPCMessage bounds a single JSON-RPC message read from a language server.
+const maxRPCMessage = 64 << 20
+
+// rpcMessage is a decoded JSON-RPC 2.0 message. Numbers are kept as json.Number so ids and
+// positions survive the round trip unchanged.
+type rpcMessage map[string]any
+
+func decodeRPCMessage(data []byte) (rpcMessage, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var msg rpcMessage
+	if err := dec.Decode(&msg); err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, fmt.Errorf("not a JSON-RPC message")
+	}
+	return msg, nil
+}
+
+func (m rpcMessage) method() string {
+	s, _ := m["method"].(string)
+	return s
+}
+
+func (m rpcMessage) id() (any, bool) {
+	id, ok := m["id"]
+	return id, ok && id != nil
+}
+
+func (m rpcMessage) isRequest() bool {
+	_, ok := m.id()
+	return ok && m.method() != ""
+}
+
+func (m rpcMessage) isResponse() bool {
+	_, ok := m.id()
+	return ok && m.method() == ""
+}
+
+func (m rpcMessage) params() map[string]any {
+	p, _ := m["params"].(map[string]any)
+	return p
+}
+
+// idKey turns a JSON-RPC id into a map key.
+func idKey(id any) string {
+	data, _ := json.Marshal(id)
+	return string(data)
+}
+
+func rpcResponse(id any, result any) rpcMessage {
+	return rpcMessage{"jsonrpc": "2.0", "id": id, "result": result}
+}
+
+func rpcError(id any, code int, message string) rpcMessage {
+	return rpcMessage{"jsonrpc": "2.0", "id": id, "error": map[string]any{"code": code, "message": message}}
+}
+
+func rpcRequest(id any, method string, params any) rpcMessage {
+	return rpcMessage{"jsonrpc": "2.0", "id": id, "method": method, "params": params}
+}
+
+func rpcNotification(method string, params any) rpcMessage {
+	return rpcMessage{"jsonrpc": "2.0", "method": method, "params": params}
+}
+
+// JSON-RPC error codes used by the proxy.
+const (
+	rpcMethodNotFound = -32601
+	rpcInternalError  = -32603
+)
+
+// readRPCFrame reads one Content-Length framed message from a language server.
+func readRPCFrame(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 || length > maxRPCMessage {
+		return nil, fmt.Errorf("invalid Content-Length %d", length)
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
+
+// writeRPCFrame writes msg with a Content-Length header.
+func writeRPCFrame(w io.Writer, msg rpcMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return err
+}
+
+// uriRewriter translates between the remotefs:/path URIs of the workbench and the file URIs
+// understood by language servers.
+type uriRewriter struct {
+	root string // absolute root directory
+}
+
+const remoteFSScheme = "remotefs"
+
+// toServer maps remotefs:/path to file:///<root>/path.
+func (u uriRewriter) toServer(s string) string {
+	abs, parsed, ok := u.parseRemote(s)
+	if !ok {
+		return s
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs), RawQuery: parsed.RawQuery, Fragment: parsed.Fragment}).String()
+}
+
+// toLocalPath maps remotefs:/path to the absolute path under the root.
+func (u uriRewriter) toLocalPath(s string) string {
+	if abs, _, ok := u.parseRemote(s); ok {
+		return abs
+	}
+	return s
+}
+
+// fromLocalPath maps an absolute path under the root to remotefs:/path.
+func (u uriRewriter) fromLocalPath(s string) string {
+	if !filepath.IsAbs(s) {
+		return s
+	}
+	return u.toClient((&url.URL{Scheme: "file", Path: filepath.ToSlash(s)}).String())
+}
+
+func (u uriRewriter) parseRemote(s string) (string, *url.URL, bool) {
+	rest, ok := strings.CutPrefix(s, remoteFSScheme+":")
+	if !ok {
+		return "", nil, false
+	}
+	parsed, err := url.Parse("x:" + rest)
+	if err != nil {
+		return "", nil, false
+	}
+	p := parsed.Path
+	if p == "" {
+		p = parsed.Opaque
+	}
+	return filepath.Join(u.root, filepath.FromSlash(filepath.Clean("/"+p))), parsed, true
+}
+
+// toClient maps file:///<root>/path to remotefs:/path. Files outside the root are left alone.
+func (u uriRewriter) toClient(s string) string {
+	if !strings.HasPrefix(s, "file:") {
+		return s
+	}
+	parsed, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	rel, err := filepath.Rel(u.root, filepath.FromSlash(parsed.Path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return s
+	}
+	p := "/" + filepath.ToSlash(rel)
+	if rel == "." {
+		p = "/"
+	}
+	out := remoteFSScheme + ":" + (&url.URL{Path: p}).EscapedPath()
+	if parsed.RawQuery != "" {
+		out += "?" + parsed.RawQuery
+	}
+	if parsed.Fragment != "" {
+		out += "#" + parsed.EscapedFragment()
+	}
+	return out
+}
+
+// lspURIFields are the properties of LSP structures that hold a document or folder URI.
+var lspURIFields = map[string]bool{
+	"uri":       true, // TextDocumentIdentifier, Location, WorkspaceFolder, FileEvent, CreateFile, ...
+	"rootUri":   true, // InitializeParams
+	"targetUri": true, // LocationLink
+	"oldUri":    true, // RenameFile, FileRename
+	"newUri":    true, // RenameFile, FileRename
+	"scopeUri":  true, // ConfigurationItem
+	"baseUri":   true, // RelativePattern
+	"target":    true, // DocumentLink
+}
+
+// rewriteURIs applies fn to the URI properties of LSP messages and to the document URIs that
+// key WorkspaceEdit.changes. Other strings, such as document text, are left alone.
+func rewriteURIs(v any, fn func(string) string) any {
+	switch v := v.(type) {
+	case []any:
+		for i := range v {
+			v[i] = rewriteURIs(v[i], fn)
+		}
+	case map[string]any:
+		for k, item := range v {
+			if s, ok := item.(string); ok && lspURIFields[k] {
+				v[k] = fn(s)
+			} else if changes, ok := item.(map[string]any); ok && k == "changes" {
+				out := make(map[string]any, len(changes))
+				for uri, edits := range changes {
+					out[fn(uri)] = edits
+				}
+				v[k] = out
+			} else {
+				v[k] = rewriteURIs(item, fn)
+			}
+		}
+	case rpcMessage:
+		rewriteURIs(map[string]any(v), fn)
+	}
+	return v
+}
+
+// rewriteStrings applies fn to every string in v.
+func rewriteStrings(v any, fn func(string) string) any {
+	switch v := v.(type) {
+	case string:
+		return fn(v)
+	case []any:
+		for i := range v {
+			v[i] = rewriteStrings(v[i], fn)
+		}
+		return v
+	case map[string]any:
+		for k, item := range v {
+			v[k] = rewriteStrings(item, fn)
+		}
+		return v
+	case rpcMessage:
+		rewriteStrings(map[string]any(v), fn)
+		return v
+	default:
+		return v
+	}
+}
+
+// lspDocument is the text of a document open in a language server, kept so it can be
+// reopened after a restart.
+type lspDocument struct {
+	languageID string
+	version    any
+	text       string
+	refs       int // clients that have the document open
+}
+
+// applyChange applies a TextDocumentContentChangeEvent to the document text.
+func (d *lspDocument) applyChange(change map[string]any) {
+	text, _ := change["text"].(string)
+	rng, ok := change["range"].(map[string]any)
+	if !ok {
+		d.text = text
+		return
+	}
+	start := offsetAt(d.text, rng["start"])
+	end := offsetAt(d.text, rng["end"])
+	if end < start {
+		start, end = end, start
+	}
+	d.text = d.text[:start] + text + d.text[end:]
+}
+
+// offsetAt converts an LSP position (line, UTF-16 character) into a byte offset of text.
+func offsetAt(text string, pos any) int {
+	p, _ := pos.(map[string]any)
+	line := jsonInt(p["line"])
+	char := jsonInt(p["character"])
+	offset := 0
+	for ; line > 0; line-- {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		offset += i + 1
+	}
+	for char > 0 && offset < len(text) && text[offset] != '\n' {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		char -= utf16.RuneLen(r)
+		if char < 0 {
+			break
+		}
+		offset += size
+	}
+	return offset
+}
+
+func jsonInt(v any) int {
+	switch n := v.(type) {
+	case json.Number:
+		i, _ := n.Int64()
+		return int(i)
+	case float64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 0
+}
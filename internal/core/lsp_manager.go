@@ -0,0 +1,760 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrUnknownLanguageServer = errors.New("unknown language server")
+	ErrLSPClientClosed       = errors.New("language server connection closed")
+)
+
+const (
+	DefaultLSPIdleTimeout = 10 * time.Minute
+
+	lspMaxCrashes      = 5               // crashes tolerated within lspCrashWindow before giving up
+	lspCrashWindow     = 3 * time.Minute // window in which crashes are counted
+	lspClientQueue     = 1024            // messages buffered per client
+	lspShutdownTimeout = 5 * time.Second // time given to a server to answer "shutdown"
+	lspShutdownWait    = 2 * time.Second // time given to a server to exit after "exit"
+	lspProxyRequestID  = "remotefs-proxy-"
+)
+
+// DefaultLanguageServers are the language servers known out of the box, by id.
+var DefaultLanguageServers = map[string][]string{
+	"go":         {"gopls"},
+	"typescript": {"typescript-language-server", "--stdio"},
+	"python":     {"pyright-langserver", "--stdio"},
+	"rust":       {"rust-analyzer"},
+	"c":          {"clangd"},
+}
+
+// LanguageServerInfo describes a configured language server.
+type LanguageServerInfo struct {
+	ID        string   `json:"id"`
+	Command   []string `json:"command"`
+	Available bool     `json:"available"` // the executable was found
+	Running   []string `json:"running"`   // workspace folders with a running instance
+}
+
+// LSPManager starts language servers per workspace folder and multiplexes the clients of
+// the workbench onto them. Servers are restarted when they crash and shut down when no
+// client has been attached for the idle timeout.
+type LSPManager struct {
+	fs          *LocalFileServiceImpl
+	registry    *ProcessRegistry
+	servers     map[string][]string
+	idleTimeout atomic.Int64 // time.Duration
+
+	mu        sync.Mutex
+	instances map[string]*lspInstance
+}
+
+// NewLSPManager creates a manager for the given language servers, keyed by id.
+func NewLSPManager(fs *LocalFileServiceImpl, registry *ProcessRegistry, servers map[string][]string) *LSPManager {
+	m := &LSPManager{
+		fs:        fs,
+		registry:  registry,
+		servers:   servers,
+		instances: make(map[string]*lspInstance),
+	}
+	m.SetIdleTimeout(DefaultLSPIdleTimeout)
+	return m
+}
+
+// SetIdleTimeout sets how long servers are kept without attached clients, 0 keeps them
+// forever. It applies from the next time a server loses its last client.
+func (m *LSPManager) SetIdleTimeout(timeout time.Duration) {
+	m.idleTimeout.Store(int64(timeout))
+}
+
+// Servers lists the configured language servers.
+func (m *LSPManager) Servers() []LanguageServerInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]LanguageServerInfo, 0, len(m.servers))
+	for id, command := range m.servers {
+		_, err := exec.LookPath(command[0])
+		info := LanguageServerInfo{ID: id, Command: command, Available: err == nil, Running: []string{}}
+		for _, inst := range m.instances {
+			if inst.server == id {
+				info.Running = append(info.Running, inst.folderRel)
+			}
+		}
+		sort.Strings(info.Running)
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Active returns the number of running language server instances.
+func (m *LSPManager) Active() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.instances)
+}
+
+// Connect attaches a new client to the language server for the workspace folder, starting
+// the server if it isn't running.
+func (m *LSPManager) Connect(server, folder string) (*LSPClient, error) {
+	command, ok := m.servers[server]
+	if !ok {
+		return nil, ErrUnknownLanguageServer
+	}
+	dir, err := m.fs.resolve(folder)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return nil, ErrNotDirectory
+	}
+	root, _ := m.fs.resolve("")
+	rel, _ := filepath.Rel(root, dir)
+
+	key := server + "\x00" + dir
+	for {
+		inst, err := m.instance(key, server, command, dir, root, rel)
+		if err != nil {
+			return nil, err
+		}
+		// an instance that is stopping is being removed, start a new one in its place
+		if c := inst.attach(); c != nil {
+			return c, nil
+		}
+		m.remove(inst)
+	}
+}
+
+// instance returns the instance for key, starting it if it isn't running. The manager lock and
+// an instance lock are never held together.
+func (m *LSPManager) instance(key, server string, command []string, dir, root, rel string) (*lspInstance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	inst, ok := m.instances[key]
+	if !ok {
+		inst = &lspInstance{
+			manager:        m,
+			key:            key,
+			server:         server,
+			command:        command,
+			dir:            dir,
+			folderRel:      filepath.ToSlash(rel),
+			uris:           uriRewriter{root: root},
+			clients:        make(map[*LSPClient]struct{}),
+			pending:        make(map[string]lspPending),
+			serverRequests: make(map[string]*LSPClient),
+			registrations:  make(map[string]any),
+			docs:           make(map[string]*lspDocument),
+		}
+		if err := inst.start(); err != nil {
+			return nil, err
+		}
+		m.instances[key] = inst
+	}
+	return inst, nil
+}
+
+func (m *LSPManager) remove(inst *lspInstance) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.instances[inst.key] == inst {
+		delete(m.instances, inst.key)
+	}
+}
+
+// lspPending is a client request forwarded to the server under a proxy id.
+type lspPending struct {
+	client   *LSPClient // nil for requests made by the proxy itself
+	id       any        // id used by the client
+	method   string
+	answered chan struct{} // closed on the response, for requests the proxy waits for
+}
+
+// lspInstance is a language server process for one workspace folder and its clients.
+type lspInstance struct {
+	manager   *LSPManager
+	key       string
+	server    string
+	command   []string
+	dir       string
+	folderRel string
+	uris      uriRewriter
+
+	mu              sync.Mutex
+	cmd             *exec.Cmd
+	stdin           io.WriteCloser
+	exited          chan struct{}
+	stopping        bool
+	clients         map[*LSPClient]struct{}
+	primary         *LSPClient // receives the requests made by the server
+	nextID          int64
+	pending         map[string]lspPending // proxy id -> client request
+	serverRequests  map[string]*LSPClient // server request id -> client expected to answer
+	initParams      any                   // params of the first initialize, replayed on restart
+	initResult      any                   // nil until the server has answered initialize
+	initWaiters     []lspPending          // initialize requests waiting for initResult
+	initializedSent bool
+	registrations   map[string]any          // dynamic capability registrations by id
+	docs            map[string]*lspDocument // open documents by server URI
+	crashes         []time.Time
+	idleTimer       *time.Timer
+}
+
+// LSPClient is a workbench connection to a language server.
+type LSPClient struct {
+	inst     *lspInstance
+	messages chan []byte
+	open     map[string]bool // documents opened by this client, guarded by the instance lock
+	once     sync.Once
+	done     chan struct{}
+}
+
+func (inst *lspInstance) start() error {
+	cmd := exec.Command(inst.command[0], inst.command[1:]...)
+	cmd.Dir = inst.dir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = lspStderr(inst.server)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	inst.cmd = cmd
+	inst.stdin = stdin
+	inst.exited = make(chan struct{})
+	inst.manager.registry.Add(SessionProcess{
+		PID:       cmd.Process.Pid,
+		Kind:      ProcessKindLSP,
+		SessionID: inst.server + ":" + inst.folderRel,
+		Command:   inst.command,
+		Cwd:       inst.dir,
+		StartedAt: time.Now(),
+	})
+	slog.Info("Started language server", "server", inst.server, "folder", inst.folderRel, "pid", cmd.Process.Pid)
+
+	go inst.readLoop(cmd, bufio.NewReader(stdout))
+	return nil
+}
+
+// readLoop dispatches server messages until the process exits, then restarts it if it crashed.
+func (inst *lspInstance) readLoop(cmd *exec.Cmd, r *bufio.Reader) {
+	for {
+		data, err := readRPCFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				slog.Warn("invalid frame from language server", "server", inst.server, "error", err)
+				// the stream can't be resynchronized: treat it as a crash
+				_ = signalGroup(cmd.Process.Pid, syscall.SIGKILL)
+			}
+			break
+		}
+		msg, err := decodeRPCMessage(data)
+		if err != nil {
+			slog.Warn("invalid message from language server", "server", inst.server, "error", err)
+			continue
+		}
+		inst.fromServer(rewriteURIs(msg, inst.uris.toClient).(rpcMessage))
+	}
+	err := cmd.Wait()
+	_ = signalGroup(cmd.Process.Pid, syscall.SIGKILL)
+	inst.manager.registry.Remove(cmd.Process.Pid)
+
+	// removed from the manager once the instance lock is released
+	removed := false
+	defer func() {
+		if removed {
+			inst.manager.remove(inst)
+		}
+	}()
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	close(inst.exited)
+	if inst.stopping {
+		return
+	}
+	slog.Warn("Language server exited unexpectedly", "server", inst.server, "folder", inst.folderRel, "error", err)
+	inst.failPendingLocked("language server crashed")
+
+	now := time.Now()
+	recent := inst.crashes[:0]
+	for _, t := range inst.crashes {
+		if now.Sub(t) < lspCrashWindow {
+			recent = append(recent, t)
+		}
+	}
+	inst.crashes = append(recent, now)
+	if len(inst.crashes) > lspMaxCrashes || inst.initParams == nil {
+		// without a completed handshake there is nothing to replay: let the clients reconnect
+		inst.stopping = true
+		removed = true
+		for c := range inst.clients {
+			c.closeLocked()
+		}
+		return
+	}
+	if err := inst.start(); err != nil {
+		slog.Error("Failed to restart language server", "server", inst.server, "error", err)
+		inst.stopping = true
+		removed = true
+		for c := range inst.clients {
+			c.closeLocked()
+		}
+		return
+	}
+	inst.replayLocked()
+}
+
+// replayLocked brings a restarted server back to the state the clients expect: initialized
+// with the original parameters and with every open document reopened.
+func (inst *lspInstance) replayLocked() {
+	// the new process registers its capabilities again
+	if len(inst.registrations) > 0 {
+		unregs := make([]any, 0, len(inst.registrations))
+		for id, r := range inst.registrations {
+			method, _ := r.(map[string]any)["method"].(string)
+			unregs = append(unregs, map[string]any{"id": id, "method": method})
+		}
+		for c := range inst.clients {
+			inst.nextID++
+			c.deliverLocked(rpcRequest(fmt.Sprintf("%s%d", lspProxyRequestID, inst.nextID), "client/unregisterCapability", map[string]any{"unregisterations": unregs}))
+		}
+		inst.registrations = make(map[string]any)
+	}
+	inst.initResult = nil
+	inst.sendRequestLocked(nil, nil, "initialize", inst.initParams)
+	inst.sendLocked(rpcNotification("initialized", map[string]any{}))
+	for uri, doc := range inst.docs {
+		inst.sendLocked(rpcNotification("textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{"uri": uri, "languageId": doc.languageID, "version": doc.version, "text": doc.text},
+		}))
+	}
+}
+
+// failPendingLocked answers every forwarded request with an error.
+func (inst *lspInstance) failPendingLocked(reason string) {
+	for key, p := range inst.pending {
+		if p.client != nil {
+			p.client.deliverLocked(rpcError(p.id, rpcInternalError, reason))
+		}
+		delete(inst.pending, key)
+	}
+	for _, w := range inst.initWaiters {
+		w.client.deliverLocked(rpcError(w.id, rpcInternalError, reason))
+	}
+	inst.initWaiters = nil
+	inst.serverRequests = make(map[string]*LSPClient)
+}
+
+func (inst *lspInstance) sendLocked(msg rpcMessage) {
+	if err := writeRPCFrame(inst.stdin, msg); err != nil {
+		slog.Debug("failed to write to language server", "server", inst.server, "error", err)
+	}
+}
+
+// sendRequestLocked forwards a request under a fresh proxy id.
+func (inst *lspInstance) sendRequestLocked(client *LSPClient, id any, method string, params any) {
+	inst.nextID++
+	proxyID := inst.nextID
+	inst.pending[idKey(proxyID)] = lspPending{client: client, id: id, method: method}
+	inst.sendLocked(rpcRequest(proxyID, method, params))
+}
+
+// attach adds a client, or returns nil if the instance is stopping.
+func (inst *lspInstance) attach() *LSPClient {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if inst.stopping {
+		return nil
+	}
+	c := &LSPClient{inst: inst, messages: make(chan []byte, lspClientQueue), open: make(map[string]bool), done: make(chan struct{})}
+	inst.clients[c] = struct{}{}
+	if inst.primary == nil {
+		inst.primary = c
+	}
+	if inst.idleTimer != nil {
+		inst.idleTimer.Stop()
+		inst.idleTimer = nil
+	}
+	return c
+}
+
+func (inst *lspInstance) detachLocked(c *LSPClient) {
+	if _, ok := inst.clients[c]; !ok {
+		return
+	}
+	c.closeLocked()
+	// documents nobody else has open are closed so a later client starts from a clean slate
+	for uri := range c.open {
+		inst.closeDocumentLocked(uri)
+	}
+	for key, p := range inst.pending {
+		if p.client == c {
+			// the server still answers, the response is dropped
+			inst.pending[key] = lspPending{method: p.method}
+		}
+	}
+	for key, target := range inst.serverRequests {
+		if target == c {
+			delete(inst.serverRequests, key)
+			inst.sendLocked(rpcError(json.RawMessage(key), rpcInternalError, "client went away"))
+		}
+	}
+	if inst.primary == c {
+		inst.primary = nil
+		for other := range inst.clients {
+			inst.primary = other
+			break
+		}
+	}
+	if len(inst.clients) == 0 && !inst.stopping {
+		timeout := time.Duration(inst.manager.idleTimeout.Load())
+		if timeout <= 0 {
+			return
+		}
+		inst.idleTimer = time.AfterFunc(timeout, inst.shutdownIfIdle)
+	}
+}
+
+func (inst *lspInstance) closeDocumentLocked(uri string) {
+	doc, ok := inst.docs[uri]
+	if !ok {
+		return
+	}
+	if doc.refs--; doc.refs > 0 {
+		return
+	}
+	delete(inst.docs, uri)
+	inst.sendLocked(rpcNotification("textDocument/didClose", map[string]any{"textDocument": map[string]any{"uri": uri}}))
+}
+
+// shutdownIfIdle stops the server with the shutdown/exit handshake if no client came back.
+func (inst *lspInstance) shutdownIfIdle() {
+	inst.mu.Lock()
+	if len(inst.clients) > 0 || inst.stopping {
+		inst.mu.Unlock()
+		return
+	}
+	inst.stopping = true
+	slog.Info("Stopping idle language server", "server", inst.server, "folder", inst.folderRel)
+	answered := make(chan struct{})
+	inst.nextID++
+	inst.pending[idKey(inst.nextID)] = lspPending{method: "shutdown", answered: answered}
+	inst.sendLocked(rpcRequest(inst.nextID, "shutdown", nil))
+	pid, exited := inst.cmd.Process.Pid, inst.exited
+	inst.mu.Unlock()
+	inst.manager.remove(inst)
+
+	// the server may only exit once it has answered shutdown
+	select {
+	case <-answered:
+	case <-exited:
+	case <-time.After(lspShutdownTimeout):
+		slog.Warn("Language server did not answer shutdown", "server", inst.server, "folder", inst.folderRel)
+	}
+	inst.mu.Lock()
+	inst.sendLocked(rpcNotification("exit", nil))
+	inst.stdin.Close()
+	inst.mu.Unlock()
+	terminateGroup(pid, exited, lspShutdownWait)
+}
+
+// Stop shuts down every running language server.
+func (m *LSPManager) Stop() {
+	m.mu.Lock()
+	instances := make([]*lspInstance, 0, len(m.instances))
+	for _, inst := range m.instances {
+		instances = append(instances, inst)
+	}
+	m.mu.Unlock()
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inst.mu.Lock()
+			for c := range inst.clients {
+				c.closeLocked()
+			}
+			inst.clients = make(map[*LSPClient]struct{})
+			inst.mu.Unlock()
+			inst.shutdownIfIdle()
+		}()
+	}
+	wg.Wait()
+}
+
+// fromServer routes a message of the server to the clients.
+func (inst *lspInstance) fromServer(msg rpcMessage) {
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	switch {
+	case msg.isResponse():
+		id, _ := msg.id()
+		key := idKey(id)
+		p, ok := inst.pending[key]
+		if !ok {
+			return
+		}
+		delete(inst.pending, key)
+		if p.answered != nil {
+			close(p.answered)
+		}
+		if p.method == "initialize" {
+			inst.initResult = msg["result"]
+			for _, w := range inst.initWaiters {
+				w.client.deliverLocked(rpcResponse(w.id, inst.initResult))
+			}
+			inst.initWaiters = nil
+		}
+		if p.client != nil {
+			msg["id"] = p.id
+			p.client.deliverLocked(msg)
+		}
+	case msg.isRequest():
+		id, _ := msg.id()
+		switch msg.method() {
+		case "client/registerCapability", "client/unregisterCapability":
+			inst.trackRegistrationsLocked(msg)
+			// every client needs the registrations; only the primary answers the server
+			for c := range inst.clients {
+				if c != inst.primary {
+					inst.nextID++
+					c.deliverLocked(rpcRequest(fmt.Sprintf("%s%d", lspProxyRequestID, inst.nextID), msg.method(), msg["params"]))
+				}
+			}
+		}
+		if inst.primary == nil {
+			inst.answerWithoutClientLocked(msg)
+			return
+		}
+		inst.serverRequests[idKey(id)] = inst.primary
+		inst.primary.deliverLocked(msg)
+	default:
+		for c := range inst.clients {
+			c.deliverLocked(msg)
+		}
+	}
+}
+
+// answerWithoutClientLocked replies to server requests made while no client is attached.
+func (inst *lspInstance) answerWithoutClientLocked(msg rpcMessage) {
+	id, _ := msg.id()
+	switch msg.method() {
+	case "client/registerCapability", "client/unregisterCapability", "window/workDoneProgress/create":
+		inst.sendLocked(rpcResponse(id, nil))
+	default:
+		inst.sendLocked(rpcError(id, rpcMethodNotFound, "no client attached"))
+	}
+}
+
+func (inst *lspInstance) trackRegistrationsLocked(msg rpcMessage) {
+	params := msg.params()
+	if list, ok := params["registrations"].([]any); ok {
+		for _, r := range list {
+			if reg, ok := r.(map[string]any); ok {
+				id, _ := reg["id"].(string)
+				inst.registrations[id] = reg
+			}
+		}
+	}
+	// the misspelling is part of the protocol
+	if list, ok := params["unregisterations"].([]any); ok {
+		for _, r := range list {
+			if reg, ok := r.(map[string]any); ok {
+				id, _ := reg["id"].(string)
+				delete(inst.registrations, id)
+			}
+		}
+	}
+}
+
+// lspStderr logs what a language server writes to stderr.
+type lspStderr string
+
+func (w lspStderr) Write(p []byte) (int, error) {
+	slog.Debug("language server", "server", string(w), "stderr", strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// Send handles a message from the client.
+func (c *LSPClient) Send(data []byte) error {
+	msg, err := decodeRPCMessage(data)
+	if err != nil {
+		return err
+	}
+	msg = rewriteURIs(msg, c.inst.uris.toServer).(rpcMessage)
+	inst := c.inst
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if _, ok := inst.clients[c]; !ok {
+		return ErrLSPClientClosed
+	}
+
+	if msg.isResponse() {
+		id, _ := msg.id()
+		if s, ok := id.(string); ok && strings.HasPrefix(s, lspProxyRequestID) {
+			return nil
+		}
+		key := idKey(id)
+		if inst.serverRequests[key] == c {
+			delete(inst.serverRequests, key)
+			inst.sendLocked(msg)
+		}
+		return nil
+	}
+
+	id, isRequest := msg.id()
+	switch msg.method() {
+	case "initialize":
+		switch {
+		case inst.initParams == nil:
+			inst.initParams = msg["params"]
+			inst.sendRequestLocked(c, id, "initialize", msg["params"])
+		case inst.initResult != nil:
+			c.deliverLocked(rpcResponse(id, inst.initResult))
+			c.replayRegistrationsLocked()
+		default:
+			inst.initWaiters = append(inst.initWaiters, lspPending{client: c, id: id})
+		}
+		return nil
+	case "initialized":
+		// forwarded once: the server is already initialized for later clients
+		if !inst.initializedSent {
+			inst.initializedSent = true
+			inst.sendLocked(msg)
+		}
+		return nil
+	case "shutdown":
+		// the server is shared and outlives its clients
+		c.deliverLocked(rpcResponse(id, nil))
+		return nil
+	case "exit":
+		inst.detachLocked(c)
+		return nil
+	case "textDocument/didOpen":
+		td, _ := msg.params()["textDocument"].(map[string]any)
+		uri, _ := td["uri"].(string)
+		if c.open[uri] {
+			return nil
+		}
+		c.open[uri] = true
+		if doc, ok := inst.docs[uri]; ok {
+			// already open through another client: the server must not see it twice
+			doc.refs++
+			return nil
+		}
+		languageID, _ := td["languageId"].(string)
+		text, _ := td["text"].(string)
+		inst.docs[uri] = &lspDocument{languageID: languageID, version: td["version"], text: text, refs: 1}
+	case "textDocument/didChange":
+		td, _ := msg.params()["textDocument"].(map[string]any)
+		uri, _ := td["uri"].(string)
+		if doc, ok := inst.docs[uri]; ok {
+			doc.version = td["version"]
+			changes, _ := msg.params()["contentChanges"].([]any)
+			for _, ch := range changes {
+				if change, ok := ch.(map[string]any); ok {
+					doc.applyChange(change)
+				}
+			}
+		}
+	case "textDocument/didClose":
+		td, _ := msg.params()["textDocument"].(map[string]any)
+		uri, _ := td["uri"].(string)
+		if c.open[uri] {
+			delete(c.open, uri)
+			inst.closeDocumentLocked(uri)
+		}
+		return nil
+	case "$/cancelRequest":
+		params := msg.params()
+		for key, p := range inst.pending {
+			if p.client == c && idKey(p.id) == idKey(params["id"]) {
+				params["id"] = json.RawMessage(key)
+				break
+			}
+		}
+	}
+	if isRequest {
+		inst.sendRequestLocked(c, id, msg.method(), msg["params"])
+		return nil
+	}
+	inst.sendLocked(msg)
+	return nil
+}
+
+// replayRegistrationsLocked tells a client joining an initialized server about the dynamic
+// capabilities registered so far.
+func (c *LSPClient) replayRegistrationsLocked() {
+	inst := c.inst
+	if len(inst.registrations) == 0 {
+		return
+	}
+	regs := make([]any, 0, len(inst.registrations))
+	for _, r := range inst.registrations {
+		regs = append(regs, r)
+	}
+	inst.nextID++
+	c.deliverLocked(rpcRequest(fmt.Sprintf("%s%d", lspProxyRequestID, inst.nextID), "client/registerCapability", map[string]any{"registrations": regs}))
+}
+
+// Messages returns the messages for the client. The channel is closed when the client is
+// disconnected.
+func (c *LSPClient) Messages() <-chan []byte {
+	return c.messages
+}
+
+// Close detaches the client from the language server.
+func (c *LSPClient) Close() {
+	c.inst.mu.Lock()
+	defer c.inst.mu.Unlock()
+	c.inst.detachLocked(c)
+}
+
+func (c *LSPClient) deliverLocked(msg rpcMessage) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	select {
+	case c.messages <- data:
+	default:
+		// losing messages would desynchronize the client: disconnect it instead
+		slog.Warn("language server client too slow, disconnecting", "server", c.inst.server)
+		c.inst.detachLocked(c)
+	}
+}
+
+func (c *LSPClient) closeLocked() {
+	c.once.Do(func() {
+		delete(c.inst.clients, c)
+		close(c.done)
+		close(c.messages)
+	})
+}
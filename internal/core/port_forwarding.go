@@ -0,0 +1,73 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultForwardedPorts are the ports that may be forwarded unless configured otherwise: none,
+// forwarding lets anyone who reaches the server reach the local ports as well.
+const DefaultForwardedPorts = ""
+
+// PortPolicy decides which local ports the HTTP proxy may forward to.
+type PortPolicy interface {
+	Allowed(port int) bool
+}
+
+// PortAllowlist is the set of local ports the HTTP proxy may forward to. It is not modified
+// once parsed.
+type PortAllowlist struct {
+	ranges [][2]int
+}
+
+// ParsePortAllowlist parses a comma separated list of ports and port ranges such as
+// "3000-3999,5173,8080". An empty spec allows no port.
+func ParsePortAllowlist(spec string) (*PortAllowlist, error) {
+	l := &PortAllowlist{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(item, "-")
+		if !isRange {
+			hi = lo
+		}
+		from, err1 := strconv.Atoi(strings.TrimSpace(lo))
+		to, err2 := strconv.Atoi(strings.TrimSpace(hi))
+		if err1 != nil || err2 != nil || from < 1 || to > 65535 || from > to {
+			return nil, fmt.Errorf("invalid port range %q", item)
+		}
+		l.ranges = append(l.ranges, [2]int{from, to})
+	}
+	return l, nil
+}
+
+// Allowed reports whether port may be forwarded. A nil allowlist allows no port.
+func (l *PortAllowlist) Allowed(port int) bool {
+	if l == nil {
+		return false
+	}
+	for _, r := range l.ranges {
+		if port >= r[0] && port <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *PortAllowlist) String() string {
+	if l == nil {
+		return ""
+	}
+	items := make([]string, 0, len(l.ranges))
+	for _, r := range l.ranges {
+		if r[0] == r[1] {
+			items = append(items, strconv.Itoa(r[0]))
+		} else {
+			items = append(items, fmt.Sprintf("%d-%d", r[0], r[1]))
+		}
+	}
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,213 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPortScanInterval is how often listening sockets are polled.
+const DefaultPortScanInterval = 2 * time.Second
+
+// tcpListen is the LISTEN state in /proc/net/tcp.
+const tcpListen = "0A"
+
+// ListeningPort is a TCP port listened on by a process spawned for a client session.
+type ListeningPort struct {
+	Port      int       `json:"port"`
+	Addresses []string  `json:"addresses"` // local addresses bound, e.g. 127.0.0.1 and ::
+	PID       int       `json:"pid"`
+	Command   []string  `json:"command"` // command line of the listening process
+	Kind      string    `json:"kind"`    // kind of the session that spawned it
+	SessionID string    `json:"sessionId"`
+	Forwarded bool      `json:"forwarded"` // reachable under /proxy/<port>/
+	OpenedAt  time.Time `json:"openedAt"`
+}
+
+// PortWatcher polls /proc/net/tcp{,6} for ports listened on by session processes and
+// publishes port-opened and port-closed events.
+type PortWatcher struct {
+	registry  *ProcessRegistry
+	events    *EventBus
+	Allowlist PortPolicy // ports the HTTP proxy forwards
+
+	mu    sync.Mutex
+	ports map[int]ListeningPort
+}
+
+func NewPortWatcher(registry *ProcessRegistry, events *EventBus) *PortWatcher {
+	return &PortWatcher{registry: registry, events: events, ports: make(map[int]ListeningPort)}
+}
+
+// List returns the listening ports found by the last scan, ordered by port.
+func (w *PortWatcher) List() []ListeningPort {
+	w.mu.Lock()
+	out := make([]ListeningPort, 0, len(w.ports))
+	for _, p := range w.ports {
+		out = append(out, p)
+	}
+	w.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Port < out[j].Port })
+	return out
+}
+
+// Run scans every interval until ctx is done.
+func (w *PortWatcher) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := w.Scan(); err != nil {
+			slog.Warn("port scan failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Scan refreshes the listening ports and publishes what changed since the last scan.
+func (w *PortWatcher) Scan() error {
+	sockets := make(map[uint64]tcpSocket)
+	for _, name := range []string{"tcp", "tcp6"} {
+		if err := readListeningSockets(filepath.Join(procRoot, "net", name), sockets); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	found := make(map[int]ListeningPort)
+	if len(sockets) > 0 {
+		for pid, owner := range w.sessionProcesses() {
+			for _, inode := range procSocketInodes(pid) {
+				sock, ok := sockets[inode]
+				if !ok {
+					continue
+				}
+				p, seen := found[sock.port]
+				// sockets inherited by children are attributed to the process with the lowest pid
+				if !seen || pid < p.PID {
+					p = ListeningPort{
+						Port:      sock.port,
+						Addresses: p.Addresses,
+						PID:       pid,
+						Command:   readProcCmdline(pid),
+						Kind:      owner.Kind,
+						SessionID: owner.SessionID,
+						Forwarded: w.Allowlist != nil && w.Allowlist.Allowed(sock.port),
+						OpenedAt:  time.Now(),
+					}
+				}
+				if !slices.Contains(p.Addresses, sock.addr) {
+					p.Addresses = append(p.Addresses, sock.addr)
+				}
+				found[sock.port] = p
+			}
+		}
+	}
+
+	w.mu.Lock()
+	var opened, closed []ListeningPort
+	for port, p := range found {
+		if prev, ok := w.ports[port]; ok && prev.PID == p.PID {
+			p.OpenedAt = prev.OpenedAt
+			found[port] = p
+			continue
+		}
+		if prev, ok := w.ports[port]; ok {
+			closed = append(closed, prev)
+		}
+		opened = append(opened, p)
+	}
+	for port, p := range w.ports {
+		if _, ok := found[port]; !ok {
+			closed = append(closed, p)
+		}
+	}
+	w.ports = found
+	w.mu.Unlock()
+
+	for _, p := range closed {
+		w.events.Publish(EventPortClosed, p)
+	}
+	for _, p := range opened {
+		w.events.Publish(EventPortOpened, p)
+	}
+	return nil
+}
+
+// sessionProcesses maps the processes of registered sessions to their session process.
+func (w *PortWatcher) sessionProcesses() map[int]SessionProcess {
+	leaders := w.registry.leaders()
+	if len(leaders) == 0 {
+		return nil
+	}
+	return sessionMembers(leaders, readAllProcStats())
+}
+
+type tcpSocket struct {
+	addr string
+	port int
+}
+
+// readListeningSockets adds the listening sockets of a /proc/net/tcp{,6} table to sockets,
+// keyed by inode.
+func readListeningSockets(path string, sockets map[uint64]tcpSocket) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != tcpListen {
+			continue
+		}
+		addr, port, err := parseProcNetAddr(fields[1])
+		if err != nil {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil || inode == 0 {
+			continue
+		}
+		sockets[inode] = tcpSocket{addr: addr, port: port}
+	}
+	return scanner.Err()
+}
+
+// parseProcNetAddr decodes an address such as 0100007F:1F90. The address is a sequence of
+// 32-bit words in host byte order, which is little endian on every platform we run on.
+func parseProcNetAddr(s string) (string, int, error) {
+	hexAddr, hexPort, ok := strings.Cut(s, ":")
+	if !ok {
+		return "", 0, fmt.Errorf("malformed address %q", s)
+	}
+	port, err := strconv.ParseUint(hexPort, 16, 16)
+	if err != nil {
+		return "", 0, err
+	}
+	raw, err := hex.DecodeString(hexAddr)
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", 0, fmt.Errorf("malformed address %q", s)
+	}
+	for i := 0; i < len(raw); i += 4 {
+		raw[i], raw[i+1], raw[i+2], raw[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	return net.IP(raw).String(), int(port), nil
+}
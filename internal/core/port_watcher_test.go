@@ -0,0 +1,37 @@
+package core
+
+import "testing"
+
+func TestParseProcNetAddr(t *testing.T) {
+	tests := []struct {
+		s        string
+		wantAddr string
+		wantPort int
+		wantErr  bool
+	}{
+		{s: "0100007F:1F90", wantAddr: "127.0.0.1", wantPort: 8080},
+		{s: "00000000:0016", wantAddr: "0.0.0.0", wantPort: 22},
+		{s: "0201A8C0:FFFF", wantAddr: "192.168.1.2", wantPort: 65535},
+		{s: "00000000000000000000000001000000:0BB8", wantAddr: "::1", wantPort: 3000},
+		{s: "00000000000000000000000000000000:0050", wantAddr: "::", wantPort: 80},
+		{s: "0000000000000000FFFF00000100007F:1F90", wantAddr: "127.0.0.1", wantPort: 8080},
+		{s: "B80D0120000000000000000001000000:01BB", wantAddr: "2001:db8::1", wantPort: 443},
+		{s: "0100007F", wantErr: true},
+		{s: "0100007F:", wantErr: true},
+		{s: "0100007F:10000", wantErr: true},
+		{s: "0100007:1F90", wantErr: true},
+		{s: "0100007G:1F90", wantErr: true},
+		{s: "000000000100007F:1F90", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			addr, port, err := parseProcNetAddr(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProcNetAddr(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			}
+			if addr != tt.wantAddr || port != tt.wantPort {
+				t.Errorf("parseProcNetAddr(%q) = %s, %d, want %s, %d", tt.s, addr, port, tt.wantAddr, tt.wantPort)
+			}
+		})
+	}
+}
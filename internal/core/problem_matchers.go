@@ -0,0 +1,186 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a problem reported by a task and extracted by a problem matcher.
+type Diagnostic struct {
+	File     string `json:"file"` // relative to the root when inside it
+	Line     int    `json:"line"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"` // error, warning or info
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message"`
+	Source   string `json:"source"` // the problem matcher owner, e.g. go or typescript
+	Task     string `json:"task"`
+}
+
+// problemPattern is one line of a problem matcher. Group numbers are 0 when not captured.
+type problemPattern struct {
+	re       *regexp.Regexp
+	file     int
+	line     int
+	column   int
+	severity int
+	code     int
+	message  int
+	loop     bool // repeats for every following line that matches
+}
+
+// problemMatcher mirrors the built-in VS Code problem matchers of the same name.
+type problemMatcher struct {
+	owner    string
+	severity string
+	relative bool // file names are relative to the task cwd rather than absolute
+	patterns []problemPattern
+}
+
+var problemMatchers = map[string]*problemMatcher{
+	"$go": {
+		owner:    "go",
+		severity: "error",
+		relative: true,
+		patterns: []problemPattern{{
+			re:   regexp.MustCompile(`^([^:]*: )?((.:)?[^:]*):(\d+)(:(\d+))?: (.*)$`),
+			file: 2, line: 4, column: 6, message: 7,
+		}},
+	},
+	"$tsc": {
+		owner:    "typescript",
+		severity: "error",
+		relative: true,
+		patterns: []problemPattern{{
+			re:   regexp.MustCompile(`^([^\s].*)[\(:](\d+)[,:](\d+)(?:\):\s+|\s+-\s+)(error|warning|info)\s+TS(\d+)\s*:\s*(.*)$`),
+			file: 1, line: 2, column: 3, severity: 4, code: 5, message: 6,
+		}},
+	},
+	"$eslint-stylish": {
+		owner:    "eslint",
+		severity: "error",
+		patterns: []problemPattern{
+			{
+				re:   regexp.MustCompile(`^((?:[a-zA-Z]:)*[./\\]+.*?)$`),
+				file: 1,
+			},
+			{
+				re:   regexp.MustCompile(`^\s+(\d+):(\d+)\s+(error|warning|info)\s+(.+?)(?:\s\s+(.*))?$`),
+				line: 1, column: 2, severity: 3, message: 4, code: 5,
+				loop: true,
+			},
+		},
+	},
+}
+
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]`)
+
+// match extracts diagnostics from output. cwd is the absolute directory relative file names
+// are resolved against; root is used to make the reported file names relative.
+func (m *problemMatcher) match(output []byte, task, cwd, root string) []Diagnostic {
+	var out []Diagnostic
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(ansiEscape.ReplaceAll(output, nil)))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+
+	for i := 0; i < len(lines); i++ {
+		var d Diagnostic
+		n := m.apply(lines, i, &d, func(d Diagnostic) {
+			out = append(out, m.finish(d, task, cwd, root))
+		})
+		if n > 0 {
+			i += n - 1
+		}
+	}
+	return out
+}
+
+// apply matches the patterns of m starting at lines[i]. It reports every complete diagnostic
+// through emit and returns the number of lines consumed, 0 if the patterns didn't match.
+func (m *problemMatcher) apply(lines []string, i int, d *Diagnostic, emit func(Diagnostic)) int {
+	start := i
+	for pi, p := range m.patterns {
+		last := pi == len(m.patterns)-1
+		if p.loop {
+			matched := 0
+			for ; i < len(lines); i++ {
+				groups := p.re.FindStringSubmatch(lines[i])
+				if groups == nil {
+					break
+				}
+				looped := *d
+				p.fill(groups, &looped)
+				emit(looped)
+				matched++
+			}
+			if matched == 0 {
+				return 0
+			}
+			return i - start
+		}
+		if i >= len(lines) {
+			return 0
+		}
+		groups := p.re.FindStringSubmatch(lines[i])
+		if groups == nil {
+			return 0
+		}
+		p.fill(groups, d)
+		i++
+		if last {
+			emit(*d)
+		}
+	}
+	return i - start
+}
+
+func (p *problemPattern) fill(groups []string, d *Diagnostic) {
+	group := func(n int) string {
+		if n <= 0 || n >= len(groups) {
+			return ""
+		}
+		return strings.TrimSpace(groups[n])
+	}
+	if s := group(p.file); s != "" {
+		d.File = s
+	}
+	if n, err := strconv.Atoi(group(p.line)); err == nil {
+		d.Line = n
+	}
+	if n, err := strconv.Atoi(group(p.column)); err == nil {
+		d.Column = n
+	}
+	if s := group(p.severity); s != "" {
+		d.Severity = strings.ToLower(s)
+	}
+	if s := group(p.code); s != "" {
+		d.Code = s
+	}
+	if s := group(p.message); s != "" {
+		d.Message = s
+	}
+}
+
+func (m *problemMatcher) finish(d Diagnostic, task, cwd, root string) Diagnostic {
+	d.Source = m.owner
+	d.Task = task
+	if d.Severity == "" {
+		d.Severity = m.severity
+	}
+	file := d.File
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(cwd, file)
+	}
+	if rel, err := filepath.Rel(root, file); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		file = filepath.ToSlash(rel)
+	}
+	d.File = file
+	return d
+}
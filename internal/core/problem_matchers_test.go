@@ -0,0 +1,99 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProblemMatchers(t *testing.T) {
+	const root = "/ws"
+	tests := []struct {
+		name    string
+		matcher string
+		cwd     string
+		output  string
+		want    []Diagnostic
+	}{
+		{
+			name:    "go build",
+			matcher: "$go",
+			cwd:     "/ws/app",
+			output:  "# example.com/app\n./main.go:12:5: undefined: foo\ninternal/x.go:3: missing return\n",
+			want: []Diagnostic{
+				{File: "app/main.go", Line: 12, Column: 5, Severity: "error", Message: "undefined: foo", Source: "go", Task: "build"},
+				{File: "app/internal/x.go", Line: 3, Severity: "error", Message: "missing return", Source: "go", Task: "build"},
+			},
+		},
+		{
+			name:    "go vet with a prefix",
+			matcher: "$go",
+			cwd:     "/ws",
+			output:  "vet: ./a.go:7:2: unreachable code\n",
+			want: []Diagnostic{
+				{File: "a.go", Line: 7, Column: 2, Severity: "error", Message: "unreachable code", Source: "go", Task: "build"},
+			},
+		},
+		{
+			name:    "files outside the root stay absolute",
+			matcher: "$go",
+			cwd:     "/ws",
+			output:  "/usr/local/go/src/fmt/print.go:10:1: oops\n",
+			want: []Diagnostic{
+				{File: "/usr/local/go/src/fmt/print.go", Line: 10, Column: 1, Severity: "error", Message: "oops", Source: "go", Task: "build"},
+			},
+		},
+		{
+			name:    "tsc",
+			matcher: "$tsc",
+			cwd:     "/ws/web",
+			output:  "src/app.ts(10,5): error TS2304: Cannot find name 'x'.\r\nsrc/util.ts:3:7 - warning TS6133: 'y' is declared but its value is never read.\r\n",
+			want: []Diagnostic{
+				{File: "web/src/app.ts", Line: 10, Column: 5, Severity: "error", Code: "2304", Message: "Cannot find name 'x'.", Source: "typescript", Task: "build"},
+				{File: "web/src/util.ts", Line: 3, Column: 7, Severity: "warning", Code: "6133", Message: "'y' is declared but its value is never read.", Source: "typescript", Task: "build"},
+			},
+		},
+		{
+			name:    "colored output",
+			matcher: "$tsc",
+			cwd:     "/ws",
+			output:  "\x1b[96msrc/a.ts\x1b[0m:\x1b[93m1\x1b[0m:\x1b[93m2\x1b[0m - \x1b[91merror\x1b[0m\x1b[90m TS1005: \x1b[0m';' expected.\n",
+			want: []Diagnostic{
+				{File: "src/a.ts", Line: 1, Column: 2, Severity: "error", Code: "1005", Message: "';' expected.", Source: "typescript", Task: "build"},
+			},
+		},
+		{
+			name:    "eslint stylish",
+			matcher: "$eslint-stylish",
+			cwd:     "/ws",
+			output: "\n/ws/src/a.js\n" +
+				"  1:10  error    'x' is defined but never used  no-unused-vars\n" +
+				"  2:1   warning  Unexpected console statement   no-console\n" +
+				"\n/ws/src/b.js\n" +
+				"  5:3  error  Parsing error: Unexpected token\n" +
+				"\n✖ 3 problems (2 errors, 1 warning)\n",
+			want: []Diagnostic{
+				{File: "src/a.js", Line: 1, Column: 10, Severity: "error", Code: "no-unused-vars", Message: "'x' is defined but never used", Source: "eslint", Task: "build"},
+				{File: "src/a.js", Line: 2, Column: 1, Severity: "warning", Code: "no-console", Message: "Unexpected console statement", Source: "eslint", Task: "build"},
+				{File: "src/b.js", Line: 5, Column: 3, Severity: "error", Message: "Parsing error: Unexpected token", Source: "eslint", Task: "build"},
+			},
+		},
+		{
+			name:    "no problems",
+			matcher: "$eslint-stylish",
+			cwd:     "/ws",
+			output:  "./src/ok.js\n\nDone in 0.5s\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := problemMatchers[tt.matcher]
+			if !ok {
+				t.Fatalf("unknown problem matcher %s", tt.matcher)
+			}
+			got := m.match([]byte(tt.output), "build", tt.cwd, root)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("match() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
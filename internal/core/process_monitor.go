@@ -0,0 +1,341 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrProcessNotFound     = errors.New("process not found")
+	ErrInvalidSignal       = errors.New("unsupported signal")
+	ErrProtectedProcess    = errors.New("process cannot be signaled")
+	ErrInvalidConfirmation = errors.New("confirmation token is invalid or expired")
+)
+
+// signalConfirmationTTL is how long a signal confirmation token stays valid.
+const signalConfirmationTTL = 30 * time.Second
+
+// DefaultCPUSampleInterval is the interval over which the CPU usage of processes is measured.
+const DefaultCPUSampleInterval = 2 * time.Second
+
+// processSignals are the signals that may be sent to processes, by name.
+var processSignals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGCONT": syscall.SIGCONT,
+	"SIGSTOP": syscall.SIGSTOP,
+}
+
+// ProcessInfo describes a process read from /proc.
+type ProcessInfo struct {
+	PID       int            `json:"pid"`
+	PPID      int            `json:"ppid"`
+	PGID      int            `json:"pgid"`
+	Name      string         `json:"name"`
+	Command   []string       `json:"command"`
+	Cwd       string         `json:"cwd"`
+	State     string         `json:"state"`
+	CPU       float64        `json:"cpu"` // percent of one core over the last sample interval
+	RSS       int64          `json:"rss"` // bytes
+	StartedAt time.Time      `json:"startedAt"`
+	SessionID string         `json:"sessionId,omitempty"`
+	Kind      string         `json:"kind,omitempty"` // kind of the session that spawned it
+	Children  []*ProcessInfo `json:"children,omitempty"`
+}
+
+// ProcessTree is the tree of processes spawned for a session.
+type ProcessTree struct {
+	Session SessionProcess `json:"session"`
+	Root    *ProcessInfo   `json:"root"` // nil once the session process has exited
+}
+
+// SignalConfirmation must be sent back to actually deliver a signal.
+type SignalConfirmation struct {
+	Token     string       `json:"confirmToken"`
+	Signal    string       `json:"signal"`
+	Process   *ProcessInfo `json:"process"`
+	ExpiresAt time.Time    `json:"expiresAt"`
+}
+
+type pendingSignal struct {
+	pid       int
+	startTime uint64 // tells a reused pid apart
+	signal    string
+	expires   time.Time
+}
+
+type cpuSample struct {
+	startTime uint64
+	ticks     uint64
+	at        time.Time
+	cpu       float64 // percent of one core since the previous sample
+}
+
+// ProcessMonitor lists the processes of the server's user and of the sessions it spawned,
+// and sends them signals.
+type ProcessMonitor struct {
+	registry *ProcessRegistry
+
+	mu      sync.Mutex
+	samples map[int]cpuSample
+	pending map[string]pendingSignal
+}
+
+func NewProcessMonitor(registry *ProcessRegistry) *ProcessMonitor {
+	return &ProcessMonitor{
+		registry: registry,
+		samples:  make(map[int]cpuSample),
+		pending:  make(map[string]pendingSignal),
+	}
+}
+
+// Run samples the CPU usage of every process until ctx is done. Listings report the usage
+// of the last sample, whoever made the previous listing and when.
+func (m *ProcessMonitor) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := m.sampleCPU(); err != nil {
+			slog.Warn("failed to sample process CPU usage", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *ProcessMonitor) sampleCPU() error {
+	stats := readAllProcStats()
+	if stats == nil {
+		return fmt.Errorf("failed to read %s", procRoot)
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	samples := make(map[int]cpuSample, len(stats))
+	for pid, st := range stats {
+		ticks := st.UTime + st.STime
+		// new processes are measured over their lifetime
+		since, used := boot.Add(time.Duration(st.StartTime)*time.Second/clockTicks), ticks
+		if prev, ok := m.samples[pid]; ok && prev.startTime == st.StartTime && ticks >= prev.ticks {
+			since, used = prev.at, ticks-prev.ticks
+		}
+		samples[pid] = cpuSample{startTime: st.StartTime, ticks: ticks, at: now, cpu: cpuPercent(used, now.Sub(since))}
+	}
+	m.samples = samples
+	return nil
+}
+
+func cpuPercent(ticks uint64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(ticks) / clockTicks / elapsed.Seconds() * 100
+}
+
+// snapshot reads every visible process. Processes are visible when they belong to the
+// server's user or to a session.
+func (m *ProcessMonitor) snapshot() (map[int]*ProcessInfo, error) {
+	stats := readAllProcStats()
+	if stats == nil {
+		return nil, fmt.Errorf("failed to read %s", procRoot)
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return nil, err
+	}
+	members := sessionMembers(m.registry.leaders(), stats)
+	uid := os.Getuid()
+	now := time.Now()
+	pageSize := int64(os.Getpagesize())
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[int]*ProcessInfo, len(stats))
+	for pid, st := range stats {
+		// kernel threads
+		if pid == 2 || st.PPID == 2 {
+			continue
+		}
+		leader, inSession := members[pid]
+		if owner, ok := procOwner(pid); !inSession && (!ok || owner != uid) {
+			continue
+		}
+		started := boot.Add(time.Duration(st.StartTime) * time.Second / clockTicks)
+		// processes started since the last sample are measured over their lifetime
+		cpu := cpuPercent(st.UTime+st.STime, now.Sub(started))
+		if s, ok := m.samples[pid]; ok && s.startTime == st.StartTime {
+			cpu = s.cpu
+		}
+		info := &ProcessInfo{
+			PID:       pid,
+			PPID:      st.PPID,
+			PGID:      st.PGID,
+			Name:      st.Comm,
+			Command:   readProcCmdline(pid),
+			Cwd:       readProcCwd(pid),
+			State:     st.State,
+			CPU:       cpu,
+			RSS:       st.RSS * pageSize,
+			StartedAt: started,
+		}
+		if inSession {
+			info.SessionID, info.Kind = leader.SessionID, leader.Kind
+		}
+		out[pid] = info
+	}
+	return out, nil
+}
+
+// List returns the visible processes ordered by pid, only those of a session if sessionID
+// is not empty.
+func (m *ProcessMonitor) List(sessionID string) ([]*ProcessInfo, error) {
+	procs, err := m.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*ProcessInfo, 0, len(procs))
+	for _, p := range procs {
+		if sessionID == "" || p.SessionID == sessionID {
+			out = append(out, p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].PID < out[j].PID })
+	return out, nil
+}
+
+// Get returns a visible process.
+func (m *ProcessMonitor) Get(pid int) (*ProcessInfo, error) {
+	procs, err := m.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	p, ok := procs[pid]
+	if !ok {
+		return nil, ErrProcessNotFound
+	}
+	return p, nil
+}
+
+// Trees returns the process tree of every session. Processes of a session whose parent is
+// not part of it, such as daemons reparented to init, hang off the session process.
+func (m *ProcessMonitor) Trees() ([]ProcessTree, error) {
+	procs, err := m.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, 0, len(procs))
+	for pid := range procs {
+		pids = append(pids, pid)
+	}
+	sort.Ints(pids)
+	for _, pid := range pids {
+		p := procs[pid]
+		if p.SessionID == "" {
+			continue
+		}
+		parent, ok := procs[p.PPID]
+		if !ok || parent.SessionID != p.SessionID {
+			continue
+		}
+		parent.Children = append(parent.Children, p)
+	}
+
+	sessions := m.registry.List()
+	out := make([]ProcessTree, 0, len(sessions))
+	for _, s := range sessions {
+		tree := ProcessTree{Session: s, Root: procs[s.PID]}
+		if tree.Root != nil {
+			for _, pid := range pids {
+				p := procs[pid]
+				if p.SessionID != s.SessionID || p.PID == s.PID {
+					continue
+				}
+				if parent, ok := procs[p.PPID]; !ok || parent.SessionID != p.SessionID {
+					tree.Root.Children = append(tree.Root.Children, p)
+				}
+			}
+		}
+		out = append(out, tree)
+	}
+	return out, nil
+}
+
+// Signal sends a signal to a visible process in two steps. Without confirm it returns a
+// confirmation describing the target; the signal is only sent when the token of that
+// confirmation is passed back as confirm with the same pid and signal.
+func (m *ProcessMonitor) Signal(pid int, signal, confirm string) (*SignalConfirmation, error) {
+	name := strings.ToUpper(signal)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	sig, ok := processSignals[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSignal, signal)
+	}
+	if pid <= 1 || pid == os.Getpid() {
+		return nil, ErrProtectedProcess
+	}
+	p, err := m.Get(pid)
+	if err != nil {
+		return nil, err
+	}
+	st, err := readProcStat(pid)
+	if err != nil {
+		return nil, ErrProcessNotFound
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for token, ps := range m.pending {
+		if now.After(ps.expires) {
+			delete(m.pending, token)
+		}
+	}
+	if confirm == "" {
+		token := uuid.NewString()
+		expires := now.Add(signalConfirmationTTL)
+		m.pending[token] = pendingSignal{pid: pid, startTime: st.StartTime, signal: name, expires: expires}
+		return &SignalConfirmation{Token: token, Signal: name, Process: p, ExpiresAt: expires}, nil
+	}
+	ps, ok := m.pending[confirm]
+	if !ok || ps.pid != pid || ps.signal != name || ps.startTime != st.StartTime {
+		return nil, ErrInvalidConfirmation
+	}
+	delete(m.pending, confirm)
+	if err := syscall.Kill(pid, sig); err != nil {
+		if errors.Is(err, syscall.ESRCH) {
+			return nil, ErrProcessNotFound
+		}
+		if errors.Is(err, syscall.EPERM) {
+			return nil, fmt.Errorf("%w: %v", ErrProtectedProcess, err)
+		}
+		return nil, err
+	}
+	return nil, nil
+}
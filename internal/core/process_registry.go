@@ -0,0 +1,158 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Kinds of sessions that spawn processes.
+const (
+	ProcessKindExec     = "exec"
+	ProcessKindTerminal = "terminal"
+	ProcessKindTask     = "task"
+	ProcessKindLSP      = "lsp"
+	ProcessKindDebug    = "debug"
+)
+
+// SessionProcess is the leader of a process group spawned on behalf of a client session.
+type SessionProcess struct {
+	PID       int       `json:"pid"`
+	Kind      string    `json:"kind"`
+	SessionID string    `json:"sessionId"`
+	Command   []string  `json:"command"`
+	Cwd       string    `json:"cwd"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// ProcessRegistry tracks the process groups spawned by the server so they can be listed,
+// attributed to sessions and terminated together.
+type ProcessRegistry struct {
+	mu    sync.Mutex
+	procs map[int]SessionProcess
+}
+
+func NewProcessRegistry() *ProcessRegistry {
+	return &ProcessRegistry{procs: make(map[int]SessionProcess)}
+}
+
+// Add registers a running process group leader. A nil registry ignores the call.
+func (r *ProcessRegistry) Add(p SessionProcess) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.procs[p.PID] = p
+}
+
+// Remove forgets a process group leader once it has exited.
+func (r *ProcessRegistry) Remove(pid int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.procs, pid)
+}
+
+// List returns the registered processes ordered by start time.
+func (r *ProcessRegistry) List() []SessionProcess {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	out := make([]SessionProcess, 0, len(r.procs))
+	for _, p := range r.procs {
+		out = append(out, p)
+	}
+	r.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+// leaders returns the registered processes keyed by pid.
+func (r *ProcessRegistry) leaders() map[int]SessionProcess {
+	out := make(map[int]SessionProcess)
+	for _, p := range r.List() {
+		out[p.PID] = p
+	}
+	return out
+}
+
+// sessionMembers maps every process in the process group of, or descending from, a session
+// process to that session process.
+func sessionMembers(leaders map[int]SessionProcess, stats map[int]procStat) map[int]SessionProcess {
+	out := make(map[int]SessionProcess)
+	for pid, st := range stats {
+		if leader, ok := leaders[st.PGID]; ok {
+			out[pid] = leader
+			continue
+		}
+		// processes that moved to a group of their own, e.g. with setsid
+		for ppid, depth := st.PPID, 0; ppid > 1 && depth < 64; depth++ {
+			if leader, ok := leaders[ppid]; ok {
+				out[pid] = leader
+				break
+			}
+			parent, ok := stats[ppid]
+			if !ok {
+				break
+			}
+			ppid = parent.PPID
+		}
+	}
+	return out
+}
+
+// signalGroup sends sig to the process group led by pid.
+func signalGroup(pid int, sig syscall.Signal) error {
+	return syscall.Kill(-pid, sig)
+}
+
+// terminateGroup sends SIGTERM to the process group and SIGKILL if it is still alive after grace.
+// done must be closed when the leader has been waited for.
+func terminateGroup(pid int, done <-chan struct{}, grace time.Duration) {
+	_ = signalGroup(pid, syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(grace):
+	}
+	// reap children that outlived the leader as well
+	_ = signalGroup(pid, syscall.SIGKILL)
+}
+
+// TerminateAll sends SIGTERM to every registered process group and SIGKILL to the groups whose
+// leader is still registered after grace. It returns once every leader has been reaped or the
+// grace period and a second to reap the killed ones have passed.
+func (r *ProcessRegistry) TerminateAll(grace time.Duration) {
+	if r == nil {
+		return
+	}
+	procs := r.List()
+	for _, p := range procs {
+		_ = signalGroup(p.PID, syscall.SIGTERM)
+	}
+	alive := func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		for _, p := range procs {
+			if _, ok := r.procs[p.PID]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	deadline := time.Now().Add(grace)
+	for alive() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	// reap children that outlived their leader as well
+	for _, p := range procs {
+		_ = signalGroup(p.PID, syscall.SIGKILL)
+	}
+	for deadline = time.Now().Add(time.Second); alive() && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
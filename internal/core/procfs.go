@@ -0,0 +1,165 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// procRoot is where the proc filesystem is mounted.
+const procRoot = "/proc"
+
+// procStat holds the fields of /proc/<pid>/stat the server uses.
+type procStat struct {
+	PID       int
+	PPID      int
+	PGID      int
+	State     string
+	Comm      string
+	UTime     uint64 // clock ticks
+	STime     uint64 // clock ticks
+	StartTime uint64 // clock ticks after boot
+	RSS       int64  // pages
+}
+
+func readProcStat(pid int) (procStat, error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procStat{}, err
+	}
+	return parseProcStat(pid, data)
+}
+
+// parseProcStat parses the contents of /proc/<pid>/stat.
+func parseProcStat(pid int, data []byte) (procStat, error) {
+	// the command name is in parentheses and may itself contain spaces and parentheses
+	open, end := bytes.IndexByte(data, '('), bytes.LastIndexByte(data, ')')
+	if open < 0 || end < open {
+		return procStat{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	// fields[0] is field 3 of proc(5)
+	if len(fields) < 22 {
+		return procStat{}, fmt.Errorf("malformed stat of process %d", pid)
+	}
+	field := func(n int) string { return fields[n-3] }
+	st := procStat{PID: pid, State: field(3), Comm: string(data[open+1 : end])}
+	st.PPID, _ = strconv.Atoi(field(4))
+	st.PGID, _ = strconv.Atoi(field(5))
+	st.UTime, _ = strconv.ParseUint(field(14), 10, 64)
+	st.STime, _ = strconv.ParseUint(field(15), 10, 64)
+	st.StartTime, _ = strconv.ParseUint(field(22), 10, 64)
+	st.RSS, _ = strconv.ParseInt(field(24), 10, 64)
+	return st, nil
+}
+
+// readProcCmdline returns the arguments of a process, empty for kernel threads and zombies.
+func readProcCmdline(pid int) []string {
+	data, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cmdline"))
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(string(data), "\x00"), "\x00")
+}
+
+// listPIDs returns the ids of every process visible in /proc.
+func listPIDs() ([]int, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, 0, len(entries))
+	for _, e := range entries {
+		if pid, err := strconv.Atoi(e.Name()); err == nil && e.IsDir() {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// procSocketInodes returns the inodes of the sockets a process has open.
+func procSocketInodes(pid int) []uint64 {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid), "fd")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var inodes []uint64
+	for _, e := range entries {
+		target, err := os.Readlink(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		if s, ok := strings.CutPrefix(target, "socket:["); ok {
+			if inode, err := strconv.ParseUint(strings.TrimSuffix(s, "]"), 10, 64); err == nil {
+				inodes = append(inodes, inode)
+			}
+		}
+	}
+	return inodes
+}
+
+// readAllProcStats reads the stat of every process, skipping those that exit meanwhile.
+func readAllProcStats() map[int]procStat {
+	pids, err := listPIDs()
+	if err != nil {
+		return nil
+	}
+	stats := make(map[int]procStat, len(pids))
+	for _, pid := range pids {
+		if st, err := readProcStat(pid); err == nil {
+			stats[pid] = st
+		}
+	}
+	return stats
+}
+
+// clockTicks is USER_HZ, the unit of the times in /proc/<pid>/stat. It is 100 on every
+// Linux architecture the server is built for.
+const clockTicks = 100
+
+// readProcCwd returns the working directory of a process, empty if it is not accessible.
+func readProcCwd(pid int) string {
+	cwd, _ := os.Readlink(filepath.Join(procRoot, strconv.Itoa(pid), "cwd"))
+	return cwd
+}
+
+// procOwner returns the uid owning a process.
+func procOwner(pid int) (int, bool) {
+	fi, err := os.Stat(filepath.Join(procRoot, strconv.Itoa(pid)))
+	if err != nil {
+		return 0, false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return int(st.Uid), true
+}
+
+// readUptime returns the seconds since boot.
+func readUptime() (float64, error) {
+	data, err := os.ReadFile(filepath.Join(procRoot, "uptime"))
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("malformed uptime")
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+// bootTime returns the time the system booted.
+func bootTime() (time.Time, error) {
+	uptime, err := readUptime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(-time.Duration(uptime * float64(time.Second))), nil
+}
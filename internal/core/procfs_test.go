@@ -0,0 +1,70 @@
+package core
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseProcStat(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    procStat
+		wantErr bool
+	}{
+		{
+			name: "process",
+			data: "1234 (bash) S 1200 1234 1234 34816 1300 4194560 2150 9836 0 2 15 7 41 12 20 0 1 0 518211 9695232 1375 18446744073709551615 0 0 0 0 0 0 65536 3686404 1266761467 0 0 0 17 3 0 0 0 0 0\n",
+			want: procStat{PID: 1234, PPID: 1200, PGID: 1234, State: "S", Comm: "bash", UTime: 15, STime: 7, StartTime: 518211, RSS: 1375},
+		},
+		{
+			name: "command name with spaces and parentheses",
+			data: "42 (tmux: server (1)) R 1 42 42 0 -1 4194368 100 0 0 0 300 200 0 0 20 0 1 0 99 1000 64 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0\n",
+			want: procStat{PID: 42, PPID: 1, PGID: 42, State: "R", Comm: "tmux: server (1)", UTime: 300, STime: 200, StartTime: 99, RSS: 64},
+		},
+		{
+			name: "zombie",
+			data: "77 (sh) Z 76 76 76 0 -1 4227084 0 0 0 0 0 0 0 0 20 0 1 0 12345 0 0 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 1 0 0 0 0 0\n",
+			want: procStat{PID: 77, PPID: 76, PGID: 76, State: "Z", Comm: "sh", StartTime: 12345},
+		},
+		{
+			name:    "missing command name",
+			data:    "1 bash S 0 1 1\n",
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			data:    "1 (bash) S 0 1 1 0 -1\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pid := tt.want.PID
+			got, err := parseProcStat(pid, []byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProcStat() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseProcStat() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadProcStat(t *testing.T) {
+	st, err := readProcStat(os.Getpid())
+	if err != nil {
+		t.Skipf("proc filesystem not available: %v", err)
+	}
+	if st.PID != os.Getpid() || st.PPID != os.Getppid() {
+		t.Errorf("readProcStat() = pid %d, ppid %d, want %d, %d", st.PID, st.PPID, os.Getpid(), os.Getppid())
+	}
+	if st.StartTime == 0 || st.RSS <= 0 {
+		t.Errorf("readProcStat() = start time %d, rss %d, want both positive", st.StartTime, st.RSS)
+	}
+}
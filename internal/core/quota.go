@@ -0,0 +1,191 @@
+package core
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Quota enforces byte and file count limits for everything stored under a root directory.
+// Usage is accounted incrementally by the write paths and periodically reconciled with a
+// full scan of the root so drift from out-of-band changes is corrected.
+// A nil *Quota is valid and enforces nothing.
+type Quota struct {
+	rootDir  string
+	maxBytes int64 // 0 means unlimited
+	maxFiles int64 // 0 means unlimited
+
+	mu    sync.Mutex
+	bytes int64
+	files int64
+}
+
+// QuotaUsage is a snapshot of the accounted usage and configured limits.
+type QuotaUsage struct {
+	Bytes    int64 `json:"bytes"`
+	Files    int64 `json:"files"`
+	MaxBytes int64 `json:"maxBytes"`
+	MaxFiles int64 `json:"maxFiles"`
+}
+
+// NewQuota creates a quota for rootDir. A zero limit disables that dimension.
+func NewQuota(rootDir string, maxBytes, maxFiles int64) *Quota {
+	return &Quota{rootDir: rootDir, maxBytes: maxBytes, maxFiles: maxFiles}
+}
+
+// SetLimits changes the limits, a zero limit disables that dimension. Usage above a lowered
+// limit is kept, only further writes are refused.
+func (q *Quota) SetLimits(maxBytes, maxFiles int64) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.maxBytes, q.maxFiles = maxBytes, maxFiles
+}
+
+// Usage returns the currently accounted usage.
+func (q *Quota) Usage() QuotaUsage {
+	if q == nil {
+		return QuotaUsage{}
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return QuotaUsage{Bytes: q.bytes, Files: q.files, MaxBytes: q.maxBytes, MaxFiles: q.maxFiles}
+}
+
+// Reserve accounts for bytes and files about to be written. It returns ErrQuotaExceeded,
+// leaving the usage untouched, if the reservation would exceed any limit.
+func (q *Quota) Reserve(bytes, files int64) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.maxBytes > 0 && bytes > 0 && q.bytes+bytes > q.maxBytes {
+		return ErrQuotaExceeded
+	}
+	if q.maxFiles > 0 && files > 0 && q.files+files > q.maxFiles {
+		return ErrQuotaExceeded
+	}
+	q.bytes += bytes
+	q.files += files
+	return nil
+}
+
+// Check returns ErrQuotaExceeded if the usage has reached a limit, for writes whose size is
+// only known once they are done.
+func (q *Quota) Check() error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if (q.maxBytes > 0 && q.bytes >= q.maxBytes) || (q.maxFiles > 0 && q.files >= q.maxFiles) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// Release gives back bytes and files previously reserved or found by a scan.
+func (q *Quota) Release(bytes, files int64) {
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.bytes = max(q.bytes-bytes, 0)
+	q.files = max(q.files-files, 0)
+}
+
+// Reconcile rescans the root directory and replaces the accounted usage with the result.
+func (q *Quota) Reconcile() error {
+	if q == nil {
+		return nil
+	}
+	bytes, files, err := diskUsage(q.rootDir)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	q.bytes, q.files = bytes, files
+	q.mu.Unlock()
+	return nil
+}
+
+// Run reconciles the usage every interval until ctx is done.
+func (q *Quota) Run(ctx context.Context, interval time.Duration) {
+	if q == nil || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := q.Reconcile(); err != nil {
+				slog.Warn("quota reconcile failed", "root", q.rootDir, "error", err)
+			}
+		}
+	}
+}
+
+// Reader wraps r so every chunk read is reserved against the quota. The number of bytes
+// reserved so far is reported through n so callers can release them on failure.
+func (q *Quota) Reader(r io.Reader, n *int64) io.Reader {
+	if q == nil {
+		return r
+	}
+	return &quotaReader{q: q, r: r, n: n}
+}
+
+type quotaReader struct {
+	q *Quota
+	r io.Reader
+	n *int64
+}
+
+func (qr *quotaReader) Read(p []byte) (int, error) {
+	n, err := qr.r.Read(p)
+	if n > 0 {
+		if rerr := qr.q.Reserve(int64(n), 0); rerr != nil {
+			return 0, rerr
+		}
+		*qr.n += int64(n)
+	}
+	return n, err
+}
+
+// diskUsage sums the size and number of non-directory entries under root without following symlinks.
+func diskUsage(root string) (int64, int64, error) {
+	var bytes, files int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			// entries may vanish while scanning; skip what we can't read
+			if d != nil && d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		bytes += info.Size()
+		files++
+		return nil
+	})
+	return bytes, files, err
+}
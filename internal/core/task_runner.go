@@ -0,0 +1,597 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoTasksFile      = errors.New("no .vscode/tasks.json in workspace folder")
+	ErrInvalidTasksFile = errors.New("invalid tasks.json")
+	ErrTaskNotFound     = errors.New("task not found")
+	ErrTaskCycle        = errors.New("task dependencies form a cycle")
+	ErrTaskFailed       = errors.New("task failed")
+	ErrUnsupportedTask  = errors.New("unsupported task type")
+	ErrUnknownMatcher   = errors.New("unknown problem matcher")
+)
+
+// maxTaskOutput bounds the output of a single task kept for problem matching.
+const maxTaskOutput = 4 << 20
+
+// TaskInfo describes a task defined in tasks.json.
+type TaskInfo struct {
+	Label          string   `json:"label"`
+	Type           string   `json:"type"`
+	Command        string   `json:"command"`
+	Detail         string   `json:"detail,omitempty"`
+	Group          string   `json:"group,omitempty"`
+	IsDefault      bool     `json:"isDefault,omitempty"`
+	DependsOn      []string `json:"dependsOn,omitempty"`
+	ProblemMatcher []string `json:"problemMatcher,omitempty"`
+}
+
+// TaskRunResult is the outcome of one task of a run.
+type TaskRunResult struct {
+	Label    string `json:"label"`
+	ExitCode int    `json:"exitCode"`
+	Duration int64  `json:"durationMs"`
+	Skipped  bool   `json:"skipped,omitempty"` // a dependency failed first
+}
+
+// TaskResult is the outcome of running a task and its dependencies.
+type TaskResult struct {
+	Tasks       []TaskRunResult `json:"tasks"`
+	Diagnostics []Diagnostic    `json:"diagnostics"`
+}
+
+// TaskRunRequest selects a task to run.
+type TaskRunRequest struct {
+	Folder string `json:"folder"` // workspace folder relative to the root
+	Label  string `json:"label"`
+	File   string `json:"file"` // active file relative to the root, for the ${file} variables
+}
+
+// taskOptions is the "options" property of a task or of the whole file.
+type taskOptions struct {
+	Cwd   string            `json:"cwd"`
+	Env   map[string]string `json:"env"`
+	Shell *struct {
+		Executable string   `json:"executable"`
+		Args       []string `json:"args"`
+	} `json:"shell"`
+}
+
+// taskConfig is a task as written in tasks.json.
+type taskConfig struct {
+	Label          string          `json:"label"`
+	TaskName       string          `json:"taskName"` // version 0.1.0 name of the label
+	Type           string          `json:"type"`
+	Command        taskCommand     `json:"command"`
+	Args           []taskCommand   `json:"args"`
+	Options        *taskOptions    `json:"options"`
+	DependsOn      json.RawMessage `json:"dependsOn"`
+	DependsOrder   string          `json:"dependsOrder"`
+	ProblemMatcher json.RawMessage `json:"problemMatcher"`
+	Group          json.RawMessage `json:"group"`
+	Detail         string          `json:"detail"`
+	Linux          *taskConfig     `json:"linux"`
+}
+
+// taskCommand is a command or argument, either a string or {"value": ..., "quoting": ...}.
+type taskCommand string
+
+func (c *taskCommand) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*c = taskCommand(s)
+		return nil
+	}
+	var quoted struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(data, &quoted); err != nil {
+		return err
+	}
+	var parts []string
+	if err := json.Unmarshal(quoted.Value, &s); err == nil {
+		parts = []string{s}
+	} else if err := json.Unmarshal(quoted.Value, &parts); err != nil {
+		return err
+	}
+	*c = taskCommand(strings.Join(parts, " "))
+	return nil
+}
+
+type tasksFile struct {
+	Version string        `json:"version"`
+	Options *taskOptions  `json:"options"`
+	Tasks   []*taskConfig `json:"tasks"`
+}
+
+func (t *taskConfig) label() string {
+	if t.Label != "" {
+		return t.Label
+	}
+	return t.TaskName
+}
+
+// dependencies returns the labels listed in dependsOn.
+func (t *taskConfig) dependencies() []string {
+	return rawStrings(t.DependsOn, "task")
+}
+
+// problemMatchers returns the names of the problem matchers referenced by the task.
+func (t *taskConfig) problemMatchers() []string {
+	return rawStrings(t.ProblemMatcher, "base")
+}
+
+// group returns the group kind and whether the task is the default of its group.
+func (t *taskConfig) group() (string, bool) {
+	var kind string
+	if err := json.Unmarshal(t.Group, &kind); err == nil {
+		return kind, false
+	}
+	var group struct {
+		Kind      string `json:"kind"`
+		IsDefault any    `json:"isDefault"`
+	}
+	if err := json.Unmarshal(t.Group, &group); err != nil {
+		return "", false
+	}
+	return group.Kind, group.IsDefault == true
+}
+
+// rawStrings decodes a string, an object carrying the string under key, or an array of either.
+func rawStrings(raw json.RawMessage, key string) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		items = []json.RawMessage{raw}
+	}
+	var out []string
+	for _, item := range items {
+		var s string
+		if err := json.Unmarshal(item, &s); err == nil {
+			out = append(out, s)
+			continue
+		}
+		var obj map[string]any
+		if err := json.Unmarshal(item, &obj); err == nil {
+			if s, ok := obj[key].(string); ok {
+				out = append(out, s)
+			}
+		}
+	}
+	return out
+}
+
+// TaskRunner runs the tasks defined in .vscode/tasks.json of workspace folders under the root.
+type TaskRunner struct {
+	fs   *LocalFileServiceImpl
+	exec *ExecService
+}
+
+func NewTaskRunner(fs *LocalFileServiceImpl, exec *ExecService) *TaskRunner {
+	return &TaskRunner{fs: fs, exec: exec}
+}
+
+func (r *TaskRunner) load(folder string) (*tasksFile, string, error) {
+	dir, err := r.fs.resolve(folder)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, ".vscode", "tasks.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, "", ErrNoTasksFile
+		}
+		return nil, "", err
+	}
+	var file tasksFile
+	if err := json.Unmarshal(stripJSONC(data), &file); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidTasksFile, err)
+	}
+	for i, t := range file.Tasks {
+		if t.Linux != nil {
+			file.Tasks[i] = t.withOverrides(t.Linux)
+		}
+	}
+	return &file, dir, nil
+}
+
+// withOverrides returns t with the properties set in the platform specific section applied.
+func (t *taskConfig) withOverrides(o *taskConfig) *taskConfig {
+	merged := *t
+	if o.Command != "" {
+		merged.Command = o.Command
+	}
+	if o.Args != nil {
+		merged.Args = o.Args
+	}
+	if o.Options != nil {
+		merged.Options = o.Options
+	}
+	if o.Type != "" {
+		merged.Type = o.Type
+	}
+	return &merged
+}
+
+// List returns the tasks defined in the workspace folder.
+func (r *TaskRunner) List(folder string) ([]TaskInfo, error) {
+	file, _, err := r.load(folder)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]TaskInfo, 0, len(file.Tasks))
+	for _, t := range file.Tasks {
+		group, isDefault := t.group()
+		out = append(out, TaskInfo{
+			Label:          t.label(),
+			Type:           t.Type,
+			Command:        string(t.Command),
+			Detail:         t.Detail,
+			Group:          group,
+			IsDefault:      isDefault,
+			DependsOn:      t.dependencies(),
+			ProblemMatcher: t.problemMatchers(),
+		})
+	}
+	return out, nil
+}
+
+// Check verifies that the task exists and that its dependencies can be resolved.
+func (r *TaskRunner) Check(folder, label string) error {
+	file, _, err := r.load(folder)
+	if err != nil {
+		return err
+	}
+	run := &taskRun{tasks: make(map[string]*taskConfig)}
+	for _, t := range file.Tasks {
+		run.tasks[t.label()] = t
+	}
+	if _, ok := run.tasks[label]; !ok {
+		return ErrTaskNotFound
+	}
+	return run.checkCycles(label, map[string]int{})
+}
+
+// taskRun is the state of a single Run call.
+type taskRun struct {
+	runner *TaskRunner
+	file   *tasksFile
+	tasks  map[string]*taskConfig
+	vars   *workspaceVariables
+	out    io.Writer
+
+	mu     sync.Mutex
+	result TaskResult
+}
+
+// Run runs a task after its dependencies, writing their output to out. The result is returned
+// even when a task fails, so the diagnostics of failed builds are available.
+func (r *TaskRunner) Run(ctx context.Context, req TaskRunRequest, out io.Writer) (*TaskResult, error) {
+	file, dir, err := r.load(req.Folder)
+	if err != nil {
+		return nil, err
+	}
+	run := &taskRun{
+		runner: r,
+		file:   file,
+		tasks:  make(map[string]*taskConfig),
+		out:    &lockedWriter{w: out},
+		result: TaskResult{Tasks: []TaskRunResult{}, Diagnostics: []Diagnostic{}},
+	}
+	for _, t := range file.Tasks {
+		run.tasks[t.label()] = t
+	}
+	if _, ok := run.tasks[req.Label]; !ok {
+		return nil, ErrTaskNotFound
+	}
+	if err := run.checkCycles(req.Label, map[string]int{}); err != nil {
+		return nil, err
+	}
+	run.vars, err = newWorkspaceVariables(r.fs, dir, req.File)
+	if err != nil {
+		return nil, err
+	}
+	err = run.run(ctx, req.Label, map[string]*taskOnce{})
+	return &run.result, err
+}
+
+// checkCycles walks the dependency graph depth first; state is 1 while visiting, 2 when done.
+func (run *taskRun) checkCycles(label string, state map[string]int) error {
+	switch state[label] {
+	case 1:
+		return fmt.Errorf("%w: %s", ErrTaskCycle, label)
+	case 2:
+		return nil
+	}
+	t, ok := run.tasks[label]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, label)
+	}
+	state[label] = 1
+	for _, dep := range t.dependencies() {
+		if err := run.checkCycles(dep, state); err != nil {
+			return err
+		}
+	}
+	state[label] = 2
+	return nil
+}
+
+// taskOnce makes a task shared by several dependents run only once.
+type taskOnce struct {
+	once sync.Once
+	err  error
+}
+
+func (run *taskRun) run(ctx context.Context, label string, started map[string]*taskOnce) error {
+	run.mu.Lock()
+	o, ok := started[label]
+	if !ok {
+		o = &taskOnce{}
+		started[label] = o
+	}
+	run.mu.Unlock()
+	o.once.Do(func() { o.err = run.runTask(ctx, label, started) })
+	return o.err
+}
+
+func (run *taskRun) runTask(ctx context.Context, label string, started map[string]*taskOnce) error {
+	t := run.tasks[label]
+	deps := t.dependencies()
+	if t.DependsOrder == "sequence" {
+		for _, dep := range deps {
+			if err := run.run(ctx, dep, started); err != nil {
+				run.skip(label)
+				return err
+			}
+		}
+	} else if len(deps) > 0 {
+		errs := make([]error, len(deps))
+		var wg sync.WaitGroup
+		for i, dep := range deps {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = run.run(ctx, dep, started)
+			}()
+		}
+		wg.Wait()
+		if err := errors.Join(errs...); err != nil {
+			run.skip(label)
+			return err
+		}
+	}
+	// a task with only dependencies has nothing to run itself
+	if t.Command == "" {
+		return nil
+	}
+	return run.exec(ctx, t)
+}
+
+func (run *taskRun) skip(label string) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	run.result.Tasks = append(run.result.Tasks, TaskRunResult{Label: label, ExitCode: -1, Skipped: true})
+}
+
+func (run *taskRun) exec(ctx context.Context, t *taskConfig) error {
+	label := t.label()
+	var matchers []*problemMatcher
+	for _, name := range t.problemMatchers() {
+		m, ok := problemMatchers[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownMatcher, name)
+		}
+		matchers = append(matchers, m)
+	}
+
+	opts := mergeTaskOptions(run.file.Options, t.Options)
+	req, err := run.request(t, opts)
+	if err != nil {
+		return err
+	}
+	root, _ := run.runner.fs.resolve("")
+	cwd := filepath.Join(root, req.Cwd)
+
+	fmt.Fprintf(run.out, "> Executing task: %s <\n\n", label)
+	captured := &limitedBuffer{max: maxTaskOutput}
+	w := io.MultiWriter(run.out, captured)
+	started := time.Now()
+	proc, err := run.runner.exec.Start(ctx, ProcessKindTask, req, w, w)
+	if err != nil {
+		return err
+	}
+	proc.CloseStdin()
+	code, waitErr := proc.Wait()
+	fmt.Fprintf(run.out, "\n> Task %q finished with exit code %d <\n\n", label, code)
+
+	run.mu.Lock()
+	run.result.Tasks = append(run.result.Tasks, TaskRunResult{Label: label, ExitCode: code, Duration: time.Since(started).Milliseconds()})
+	for _, m := range matchers {
+		run.result.Diagnostics = append(run.result.Diagnostics, m.match(captured.Bytes(), label, cwd, root)...)
+	}
+	run.mu.Unlock()
+
+	if waitErr != nil {
+		return waitErr
+	}
+	if code != 0 {
+		return fmt.Errorf("%w: %s exited with code %d", ErrTaskFailed, label, code)
+	}
+	return nil
+}
+
+// request builds the exec request of a shell or process task.
+func (run *taskRun) request(t *taskConfig, opts taskOptions) (ExecRequest, error) {
+	command := run.vars.expand(string(t.Command))
+	args := make([]string, len(t.Args))
+	for i, a := range t.Args {
+		args[i] = run.vars.expand(string(a))
+	}
+
+	var argv []string
+	switch t.Type {
+	case "process":
+		argv = append([]string{command}, args...)
+	case "shell", "":
+		shell, shellArgs := "/bin/sh", []string{"-c"}
+		if opts.Shell != nil && opts.Shell.Executable != "" {
+			shell, shellArgs = opts.Shell.Executable, opts.Shell.Args
+			if shellArgs == nil {
+				shellArgs = []string{"-c"}
+			}
+		}
+		line := command
+		for _, a := range args {
+			line += " " + shellQuote(a)
+		}
+		argv = append(append([]string{shell}, shellArgs...), line)
+	default:
+		return ExecRequest{}, fmt.Errorf("%w: %s", ErrUnsupportedTask, t.Type)
+	}
+
+	cwd := run.vars.workspaceFolder
+	if opts.Cwd != "" {
+		cwd = run.vars.expand(opts.Cwd)
+		if !filepath.IsAbs(cwd) {
+			cwd = filepath.Join(run.vars.workspaceFolder, cwd)
+		}
+	}
+	root, _ := run.runner.fs.resolve("")
+	rel, err := filepath.Rel(root, cwd)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ExecRequest{}, ErrPathTraversal
+	}
+	env := make(map[string]string, len(opts.Env))
+	for k, v := range opts.Env {
+		env[k] = run.vars.expand(v)
+	}
+	return ExecRequest{Argv: argv, Cwd: rel, Env: env}, nil
+}
+
+// mergeTaskOptions applies the options of a task over the options of the file.
+func mergeTaskOptions(global, task *taskOptions) taskOptions {
+	var out taskOptions
+	out.Env = map[string]string{}
+	for _, o := range []*taskOptions{global, task} {
+		if o == nil {
+			continue
+		}
+		if o.Cwd != "" {
+			out.Cwd = o.Cwd
+		}
+		if o.Shell != nil {
+			out.Shell = o.Shell
+		}
+		for k, v := range o.Env {
+			out.Env[k] = v
+		}
+	}
+	return out
+}
+
+// shellQuote quotes s for a POSIX shell unless it is made of safe characters only.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// workspaceVariables resolves the ${...} variables supported in tasks.json and launch.json.
+type workspaceVariables struct {
+	workspaceFolder string
+	values          map[string]string
+}
+
+var workspaceVariablePattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+func newWorkspaceVariables(fs *LocalFileServiceImpl, folder, file string) (*workspaceVariables, error) {
+	home, _ := os.UserHomeDir()
+	v := &workspaceVariables{
+		workspaceFolder: folder,
+		values: map[string]string{
+			"workspaceFolder":         folder,
+			"workspaceRoot":           folder,
+			"workspaceFolderBasename": filepath.Base(folder),
+			"cwd":                     folder,
+			"userHome":                home,
+			"pathSeparator":           string(os.PathSeparator),
+			"/":                       string(os.PathSeparator),
+		},
+	}
+	if file != "" {
+		abs, err := fs.resolve(file)
+		if err != nil {
+			return nil, err
+		}
+		base := filepath.Base(abs)
+		ext := filepath.Ext(base)
+		rel, _ := filepath.Rel(folder, abs)
+		v.values["file"] = abs
+		v.values["fileBasename"] = base
+		v.values["fileBasenameNoExtension"] = strings.TrimSuffix(base, ext)
+		v.values["fileExtname"] = ext
+		v.values["fileDirname"] = filepath.Dir(abs)
+		v.values["relativeFile"] = rel
+		v.values["relativeFileDirname"] = filepath.Dir(rel)
+	}
+	return v, nil
+}
+
+// expand replaces known variables in s. Unknown variables are left as written.
+func (v *workspaceVariables) expand(s string) string {
+	return workspaceVariablePattern.ReplaceAllStringFunc(s, func(match string) string {
+		name := match[2 : len(match)-1]
+		if env, ok := strings.CutPrefix(name, "env:"); ok {
+			return os.Getenv(env)
+		}
+		if value, ok := v.values[name]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// lockedWriter serializes writes of tasks running in parallel.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+// limitedBuffer keeps the first max bytes written to it and discards the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,384 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrRecordingNotFound    = errors.New("recording not found")
+	ErrInvalidRecordingMode = errors.New("recording policy must be opt-in or always")
+	ErrRecordingRequired    = errors.New("recordings cannot be deleted when every session is recorded")
+)
+
+// Terminal recording policies.
+const (
+	RecordOptIn  = "opt-in" // sessions are recorded when the client asks for it
+	RecordAlways = "always" // every session is recorded
+)
+
+const recordingExt = ".cast"
+
+// RecordingInfo describes a stored terminal recording.
+type RecordingInfo struct {
+	ID        string    `json:"id"`
+	SessionID string    `json:"sessionId"`
+	Command   string    `json:"command"`
+	Cols      uint16    `json:"cols"`
+	Rows      uint16    `json:"rows"`
+	Input     bool      `json:"input"` // keystrokes were recorded as well
+	Size      int64     `json:"size"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// asciicastHeader is the first line of an asciicast v2 file. The x-* fields are ignored by players.
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     uint16            `json:"width"`
+	Height    uint16            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	SessionID string            `json:"x-session-id,omitempty"`
+	Input     bool              `json:"x-input,omitempty"`
+}
+
+// TerminalRecorder writes terminal sessions to asciicast v2 files in a directory and expires them
+// after a retention period. A nil *TerminalRecorder records nothing.
+type TerminalRecorder struct {
+	Dir         string
+	Policy      string
+	RecordInput bool
+	Retention   time.Duration // 0 keeps recordings forever
+}
+
+// NewTerminalRecorder creates dir if needed and validates the policy.
+func NewTerminalRecorder(dir, policy string, recordInput bool, retention time.Duration) (*TerminalRecorder, error) {
+	if policy != RecordOptIn && policy != RecordAlways {
+		return nil, ErrInvalidRecordingMode
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &TerminalRecorder{Dir: dir, Policy: policy, RecordInput: recordInput, Retention: retention}, nil
+}
+
+// start opens a recording for the session if the policy or the client asks for one.
+func (r *TerminalRecorder) start(info TerminalInfo, requested bool) (*asciicastWriter, error) {
+	if r == nil || (!requested && r.Policy != RecordAlways) {
+		return nil, nil
+	}
+	id := info.StartedAt.UTC().Format("20060102T150405Z") + "-" + info.ID
+	f, err := os.OpenFile(filepath.Join(r.Dir, id+recordingExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	w := &asciicastWriter{
+		id:    id,
+		f:     f,
+		start: info.StartedAt,
+		input: r.RecordInput,
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+	header := asciicastHeader{
+		Version:   2,
+		Width:     info.Cols,
+		Height:    info.Rows,
+		Timestamp: info.StartedAt.Unix(),
+		Command:   strings.Join(info.Command, " "),
+		Env:       map[string]string{"TERM": "xterm-256color", "SHELL": os.Getenv("SHELL")},
+		SessionID: info.ID,
+		Input:     r.RecordInput,
+	}
+	if err := w.writeLine(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// List returns the stored recordings, most recent first.
+func (r *TerminalRecorder) List() ([]RecordingInfo, error) {
+	if r == nil {
+		return []RecordingInfo{}, nil
+	}
+	entries, err := os.ReadDir(r.Dir)
+	if err != nil {
+		return nil, err
+	}
+	out := []RecordingInfo{}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), recordingExt)
+		if !ok || e.IsDir() {
+			continue
+		}
+		info, err := r.stat(id)
+		if err != nil {
+			continue
+		}
+		out = append(out, *info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.After(out[j].StartedAt) })
+	return out, nil
+}
+
+// Open returns the asciicast file of a recording.
+func (r *TerminalRecorder) Open(id string) (*os.File, *RecordingInfo, error) {
+	info, err := r.stat(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(r.path(id))
+	if err != nil {
+		return nil, nil, ErrRecordingNotFound
+	}
+	return f, info, nil
+}
+
+// Delete removes a recording. Under the always policy recordings are kept until they expire.
+func (r *TerminalRecorder) Delete(id string) error {
+	if r != nil && r.Policy == RecordAlways {
+		return ErrRecordingRequired
+	}
+	if _, err := r.stat(id); err != nil {
+		return err
+	}
+	return os.Remove(r.path(id))
+}
+
+// Prune removes recordings older than the retention period.
+func (r *TerminalRecorder) Prune() error {
+	if r == nil || r.Retention <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(r.Dir)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-r.Retention)
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), recordingExt) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil || fi.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(r.Dir, e.Name())); err != nil {
+			slog.Warn("failed to remove expired recording", "file", e.Name(), "error", err)
+		}
+	}
+	return nil
+}
+
+// Run prunes expired recordings every interval until ctx is done.
+func (r *TerminalRecorder) Run(ctx context.Context, interval time.Duration) {
+	if r == nil || r.Retention <= 0 || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := r.Prune(); err != nil {
+			slog.Warn("recording prune failed", "dir", r.Dir, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *TerminalRecorder) path(id string) string {
+	return filepath.Join(r.Dir, id+recordingExt)
+}
+
+func (r *TerminalRecorder) stat(id string) (*RecordingInfo, error) {
+	if r == nil || id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return nil, ErrRecordingNotFound
+	}
+	f, err := os.Open(r.path(id))
+	if err != nil {
+		return nil, ErrRecordingNotFound
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, ErrRecordingNotFound
+	}
+	var header asciicastHeader
+	if err := json.Unmarshal(line, &header); err != nil || header.Version != 2 {
+		return nil, ErrRecordingNotFound
+	}
+	return &RecordingInfo{
+		ID:        id,
+		SessionID: header.SessionID,
+		Command:   header.Command,
+		Cols:      header.Width,
+		Rows:      header.Height,
+		Input:     header.Input,
+		Size:      fi.Size(),
+		StartedAt: time.Unix(header.Timestamp, 0),
+	}, nil
+}
+
+// maxRecordingBacklog bounds the events a recording may queue while its file is slow.
+const maxRecordingBacklog = 8 << 20
+
+// asciicastWriter appends events of a single session to its recording. Events are queued and
+// written by the writer's own goroutine so the session never waits for the file. Write
+// failures and a backlog the file can't keep up with stop the recording but never the session.
+type asciicastWriter struct {
+	id    string
+	start time.Time
+	input bool
+	f     *os.File
+	wake  chan struct{}
+	done  chan struct{}
+
+	mu      sync.Mutex
+	queue   [][]byte // encoded events waiting to be written
+	queued  int      // bytes in queue
+	failed  bool
+	closed  bool
+	partial []byte // trailing bytes of an output rune split across reads
+}
+
+func (w *asciicastWriter) writeLine(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.f.Write(append(data, '\n'))
+	return err
+}
+
+func (w *asciicastWriter) event(code string, data string) {
+	if w == nil {
+		return
+	}
+	elapsed := float64(time.Since(w.start).Microseconds()) / 1e6
+	line, err := json.Marshal([]any{elapsed, code, data})
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failed || w.closed {
+		return
+	}
+	if w.queued+len(line) > maxRecordingBacklog {
+		slog.Warn("terminal recording stopped", "id", w.id, "error", "recording fell behind")
+		w.failed = true
+		w.queue, w.queued = nil, 0
+		return
+	}
+	w.queue = append(w.queue, append(line, '\n'))
+	w.queued += len(line) + 1
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run writes queued events until the writer is closed and its queue is drained.
+func (w *asciicastWriter) run() {
+	defer close(w.done)
+	for range w.wake {
+		w.mu.Lock()
+		queue, closed := w.queue, w.closed
+		w.queue, w.queued = nil, 0
+		w.mu.Unlock()
+		for _, line := range queue {
+			if _, err := w.f.Write(line); err != nil {
+				slog.Warn("terminal recording stopped", "id", w.id, "error", err)
+				w.mu.Lock()
+				w.failed = true
+				w.mu.Unlock()
+				break
+			}
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// Output records terminal output. Multi-byte characters split across chunks are held back
+// until complete so they survive the JSON encoding.
+func (w *asciicastWriter) Output(p []byte) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	data := append(w.partial, p...)
+	cut := incompleteRuneStart(data)
+	w.partial = append([]byte(nil), data[cut:]...)
+	w.mu.Unlock()
+	if cut > 0 {
+		w.event("o", string(data[:cut]))
+	}
+}
+
+// incompleteRuneStart returns the offset of a truncated UTF-8 sequence at the end of p, or len(p).
+func incompleteRuneStart(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				return i
+			}
+			break
+		}
+	}
+	return len(p)
+}
+
+// Input records keystrokes if input recording is enabled.
+func (w *asciicastWriter) Input(p []byte) {
+	if w != nil && w.input {
+		w.event("i", string(p))
+	}
+}
+
+// Resize records a window size change.
+func (w *asciicastWriter) Resize(cols, rows uint16) {
+	w.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// Close writes the queued events and closes the file.
+func (w *asciicastWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	<-w.done
+	return w.f.Close()
+}
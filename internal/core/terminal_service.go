@@ -0,0 +1,627 @@
+package core
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrTerminalNotFound   = errors.New("terminal not found")
+	ErrTerminalExited     = errors.New("terminal has exited")
+	ErrTerminalReadOnly   = errors.New("terminal is read-only for this client")
+	ErrInvalidGuestAccess = errors.New("guest access must be read or write")
+)
+
+// Roles of the participants of a terminal session.
+const (
+	TerminalRoleOwner = "owner" // always read-write
+	TerminalRoleGuest = "guest" // read-write only if the session grants write access to guests
+)
+
+// Access granted to guests of a terminal session.
+const (
+	GuestAccessRead  = "read"
+	GuestAccessWrite = "write"
+)
+
+const (
+	DefaultTerminalScrollback  = 256 << 10 // bytes of output replayed on reattach
+	DefaultTerminalIdleTimeout = 30 * time.Minute
+	exitedTerminalKeep         = 5 * time.Minute // an exited session is kept for clients to read its output
+
+	defaultTerminalCols = 80
+	defaultTerminalRows = 24
+	terminalClientQueue = 256 // output chunks buffered per attached client
+)
+
+// TerminalOptions describes a terminal session to create.
+type TerminalOptions struct {
+	Command []string          `json:"command"` // defaults to $SHELL
+	Cwd     string            `json:"cwd"`     // relative to the root
+	Env     map[string]string `json:"env"`
+	Cols    uint16            `json:"cols"`
+	Rows    uint16            `json:"rows"`
+	Record  bool              `json:"record"` // ask for a recording when the policy is opt-in
+
+	GuestAccess string `json:"guestAccess"` // read (default) or write
+}
+
+// TerminalInfo is a snapshot of a terminal session.
+type TerminalInfo struct {
+	ID         string    `json:"id"`
+	PID        int       `json:"pid"`
+	Command    []string  `json:"command"`
+	Cwd        string    `json:"cwd"` // relative to the root
+	Cols       uint16    `json:"cols"`
+	Rows       uint16    `json:"rows"`
+	StartedAt  time.Time `json:"startedAt"`
+	LastActive time.Time `json:"lastActive"`
+	Clients    int       `json:"clients"`
+	Exited     bool      `json:"exited"`
+	ExitCode   int       `json:"exitCode,omitempty"`
+	Recording  string    `json:"recording,omitempty"` // id of the recording of this session
+
+	GuestAccess  string                `json:"guestAccess"`
+	Participants []TerminalParticipant `json:"participants"`
+}
+
+// TerminalParticipant is a client attached to a terminal session.
+type TerminalParticipant struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name,omitempty"`
+	Role     string    `json:"role"`
+	Writable bool      `json:"writable"`
+	Cols     uint16    `json:"cols,omitempty"`
+	Rows     uint16    `json:"rows,omitempty"`
+	JoinedAt time.Time `json:"joinedAt"`
+}
+
+// TerminalAttachOptions describes a client attaching to a terminal session.
+type TerminalAttachOptions struct {
+	Name     string
+	Token    string // owner token returned when the session was created
+	ReadOnly bool   // join without write access even if it would be granted
+	Cols     uint16 // window size of the client, 0 if not known yet
+	Rows     uint16
+}
+
+// TerminalEvent is delivered to attached clients: terminal output or a change of the session.
+type TerminalEvent struct {
+	Type         string                `json:"type"` // output, participants, join, leave, update or resize
+	Data         []byte                `json:"-"`
+	Participant  *TerminalParticipant  `json:"participant,omitempty"`
+	Participants []TerminalParticipant `json:"participants,omitempty"`
+	Cols         uint16                `json:"cols,omitempty"`
+	Rows         uint16                `json:"rows,omitempty"`
+}
+
+// TerminalService keeps PTY sessions alive independently of the clients attached to them.
+// Sessions without attached clients for longer than the idle timeout are terminated and forgotten.
+type TerminalService struct {
+	fs          *LocalFileServiceImpl
+	registry    *ProcessRegistry
+	scrollback  atomic.Int64 // bytes of output kept per session for reattaching clients
+	idleTimeout atomic.Int64 // time.Duration, 0 disables reaping
+	Recorder    *TerminalRecorder
+
+	mu       sync.Mutex
+	sessions map[string]*TerminalSession
+}
+
+func NewTerminalService(fs *LocalFileServiceImpl, registry *ProcessRegistry) *TerminalService {
+	s := &TerminalService{
+		fs:       fs,
+		registry: registry,
+		sessions: make(map[string]*TerminalSession),
+	}
+	s.SetScrollback(DefaultTerminalScrollback)
+	s.SetIdleTimeout(DefaultTerminalIdleTimeout)
+	return s
+}
+
+// SetScrollback sets the bytes of output kept for reattaching clients by sessions created from now on.
+func (s *TerminalService) SetScrollback(bytes int) {
+	s.scrollback.Store(int64(bytes))
+}
+
+// SetIdleTimeout sets how long sessions are kept without attached clients, 0 keeps running
+// sessions forever.
+func (s *TerminalService) SetIdleTimeout(timeout time.Duration) {
+	s.idleTimeout.Store(int64(timeout))
+}
+
+// TerminalSession is a process running on a PTY owned by the server.
+type TerminalSession struct {
+	ownerToken string
+	pty        *os.File
+	cmd        *exec.Cmd
+	scrollback int
+	recording  *asciicastWriter
+	done       chan struct{}
+
+	mu       sync.Mutex
+	info     TerminalInfo
+	buffer   []byte
+	clients  map[*TerminalClient]struct{}
+	closed   bool // output has ended
+	termOnce sync.Once
+}
+
+// TerminalClient is a client attached to a terminal session.
+type TerminalClient struct {
+	session     *TerminalSession
+	events      chan TerminalEvent
+	readOnly    bool
+	participant TerminalParticipant // guarded by the session lock
+	once        sync.Once
+}
+
+// Create starts a new terminal session.
+func (s *TerminalService) Create(opts TerminalOptions) (*TerminalSession, error) {
+	argv := opts.Command
+	if len(argv) == 0 || argv[0] == "" {
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		argv = []string{shell}
+	}
+	cwd, err := s.fs.resolve(opts.Cwd)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(cwd); err != nil || !fi.IsDir() {
+		return nil, ErrNotDirectory
+	}
+	if opts.Cols == 0 {
+		opts.Cols = defaultTerminalCols
+	}
+	if opts.Rows == 0 {
+		opts.Rows = defaultTerminalRows
+	}
+	if opts.GuestAccess == "" {
+		opts.GuestAccess = GuestAccessRead
+	}
+	if opts.GuestAccess != GuestAccessRead && opts.GuestAccess != GuestAccessWrite {
+		return nil, ErrInvalidGuestAccess
+	}
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = cwd
+	cmd.Env = append(os.Environ(), "TERM=xterm-256color", "COLORTERM=truecolor")
+	for k, v := range opts.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	// pty.Start makes the shell a session leader, so its pid is also its process group
+	f, err := pty.StartWithSize(cmd, &pty.Winsize{Cols: opts.Cols, Rows: opts.Rows})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	t := &TerminalSession{
+		ownerToken: uuid.NewString(),
+		pty:        f,
+		cmd:        cmd,
+		scrollback: int(s.scrollback.Load()),
+		done:       make(chan struct{}),
+		clients:    make(map[*TerminalClient]struct{}),
+		info: TerminalInfo{
+			ID:          uuid.NewString(),
+			PID:         cmd.Process.Pid,
+			Command:     argv,
+			Cwd:         strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+opts.Cwd)), "/"),
+			Cols:        opts.Cols,
+			Rows:        opts.Rows,
+			StartedAt:   now,
+			LastActive:  now,
+			GuestAccess: opts.GuestAccess,
+		},
+	}
+	if t.recording, err = s.Recorder.start(t.info, opts.Record); err != nil {
+		slog.Warn("failed to start terminal recording", "id", t.info.ID, "error", err)
+	} else if t.recording != nil {
+		t.info.Recording = t.recording.id
+	}
+	s.mu.Lock()
+	s.sessions[t.info.ID] = t
+	s.mu.Unlock()
+	s.registry.Add(SessionProcess{
+		PID:       t.info.PID,
+		Kind:      ProcessKindTerminal,
+		SessionID: t.info.ID,
+		Command:   argv,
+		Cwd:       cwd,
+		StartedAt: now,
+	})
+
+	go t.pump()
+	go func() {
+		err := cmd.Wait()
+		s.registry.Remove(t.info.PID)
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			slog.Warn("terminal wait failed", "id", t.info.ID, "error", err)
+		}
+		// the pty is closed by pump once the slave side is gone
+		_ = signalGroup(t.info.PID, syscall.SIGKILL)
+		t.mu.Lock()
+		t.info.Exited = true
+		t.info.ExitCode = cmd.ProcessState.ExitCode()
+		t.info.LastActive = time.Now()
+		t.mu.Unlock()
+		close(t.done)
+	}()
+	return t, nil
+}
+
+// Get returns the session with the given id.
+func (s *TerminalService) Get(id string) (*TerminalSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrTerminalNotFound
+	}
+	return t, nil
+}
+
+// List returns all sessions ordered by start time.
+func (s *TerminalService) List() []TerminalInfo {
+	s.mu.Lock()
+	out := make([]TerminalInfo, 0, len(s.sessions))
+	for _, t := range s.sessions {
+		out = append(out, t.Info())
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].StartedAt.Before(out[j].StartedAt) })
+	return out
+}
+
+// Active returns the number of sessions whose process is still running.
+func (s *TerminalService) Active() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, t := range s.sessions {
+		select {
+		case <-t.Done():
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// Kill terminates a session and forgets it.
+func (s *TerminalService) Kill(id string) error {
+	s.mu.Lock()
+	t, ok := s.sessions[id]
+	delete(s.sessions, id)
+	s.mu.Unlock()
+	if !ok {
+		return ErrTerminalNotFound
+	}
+	t.Terminate()
+	return nil
+}
+
+// Run reaps idle and exited sessions until ctx is done.
+func (s *TerminalService) Run(ctx context.Context) {
+	for {
+		// the timeout may change while running
+		interval := time.Minute
+		if timeout := time.Duration(s.idleTimeout.Load()); timeout > 0 {
+			interval = min(timeout/2, interval)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+			s.reapIdle()
+		}
+	}
+}
+
+// reapIdle removes the sessions without clients that have been idle for the idle timeout.
+// Exited sessions are removed even when the idle timeout is 0, after at most exitedTerminalKeep.
+func (s *TerminalService) reapIdle() {
+	now := time.Now()
+	timeout := time.Duration(s.idleTimeout.Load())
+	exitedDeadline := now.Add(-exitedTerminalKeep)
+	if timeout > 0 {
+		exitedDeadline = now.Add(-min(timeout, exitedTerminalKeep))
+	}
+	s.mu.Lock()
+	var idle []*TerminalSession
+	for id, t := range s.sessions {
+		info := t.Info()
+		if info.Clients > 0 {
+			continue
+		}
+		if (info.Exited && info.LastActive.Before(exitedDeadline)) || (timeout > 0 && info.LastActive.Before(now.Add(-timeout))) {
+			delete(s.sessions, id)
+			idle = append(idle, t)
+		}
+	}
+	s.mu.Unlock()
+	for _, t := range idle {
+		slog.Info("Reaping idle terminal", "id", t.info.ID, "pid", t.info.PID)
+		t.Terminate()
+	}
+}
+
+// Info returns a snapshot of the session.
+func (t *TerminalSession) Info() TerminalInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.infoLocked()
+}
+
+func (t *TerminalSession) infoLocked() TerminalInfo {
+	info := t.info
+	info.Clients = len(t.clients)
+	info.Participants = t.participantsLocked()
+	return info
+}
+
+// Authorize reports whether token is the owner token of the session.
+func (t *TerminalSession) Authorize(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t.ownerToken)) == 1
+}
+
+// OwnerToken returns the secret that grants the owner role.
+func (t *TerminalSession) OwnerToken() string {
+	return t.ownerToken
+}
+
+// SetGuestAccess changes whether guests may type into the session. Attached guests are updated
+// immediately.
+func (t *TerminalSession) SetGuestAccess(access string) error {
+	if access != GuestAccessRead && access != GuestAccessWrite {
+		return ErrInvalidGuestAccess
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.info.GuestAccess = access
+	for c := range t.clients {
+		if c.participant.Role == TerminalRoleGuest {
+			c.participant.Writable = access == GuestAccessWrite && !c.readOnly
+			p := c.participant
+			t.notifyLocked(TerminalEvent{Type: "update", Participant: &p})
+		}
+	}
+	return nil
+}
+
+// Done is closed when the session process has exited.
+func (t *TerminalSession) Done() <-chan struct{} {
+	return t.done
+}
+
+// Attach registers a client and returns the scrollback to replay before the client's events.
+// Clients presenting the owner token join as owner, everyone else as guest.
+func (t *TerminalSession) Attach(opts TerminalAttachOptions) ([]byte, *TerminalClient) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := &TerminalClient{
+		session:  t,
+		events:   make(chan TerminalEvent, terminalClientQueue),
+		readOnly: opts.ReadOnly,
+		participant: TerminalParticipant{
+			ID:       uuid.NewString(),
+			Name:     opts.Name,
+			Role:     TerminalRoleGuest,
+			Cols:     opts.Cols,
+			Rows:     opts.Rows,
+			JoinedAt: time.Now(),
+		},
+	}
+	if t.Authorize(opts.Token) {
+		c.participant.Role = TerminalRoleOwner
+	}
+	c.participant.Writable = !opts.ReadOnly && (c.participant.Role == TerminalRoleOwner || t.info.GuestAccess == GuestAccessWrite)
+	// events carry a copy, the participant changes under t.mu while clients encode them
+	p := c.participant
+	t.notifyLocked(TerminalEvent{Type: "join", Participant: &p})
+	t.clients[c] = struct{}{}
+	t.info.LastActive = time.Now()
+	if t.closed {
+		c.closeLocked()
+		return append([]byte(nil), t.buffer...), c
+	}
+	c.events <- TerminalEvent{Type: "participants", Participant: &p, Participants: t.participantsLocked()}
+	t.resizeLocked()
+	return append([]byte(nil), t.buffer...), c
+}
+
+// Write sends input to the terminal.
+func (t *TerminalSession) Write(p []byte) (int, error) {
+	select {
+	case <-t.done:
+		return 0, ErrTerminalExited
+	default:
+	}
+	t.touch()
+	t.recording.Input(p)
+	return t.pty.Write(p)
+}
+
+// resizeLocked sizes the PTY to the smallest attached viewer so everyone sees the whole screen.
+func (t *TerminalSession) resizeLocked() {
+	var cols, rows uint16
+	for c := range t.clients {
+		if c.participant.Cols > 0 && (cols == 0 || c.participant.Cols < cols) {
+			cols = c.participant.Cols
+		}
+		if c.participant.Rows > 0 && (rows == 0 || c.participant.Rows < rows) {
+			rows = c.participant.Rows
+		}
+	}
+	if cols == 0 || rows == 0 || (cols == t.info.Cols && rows == t.info.Rows) {
+		return
+	}
+	t.info.Cols, t.info.Rows = cols, rows
+	if err := pty.Setsize(t.pty, &pty.Winsize{Cols: cols, Rows: rows}); err != nil {
+		slog.Debug("terminal resize failed", "id", t.info.ID, "error", err)
+	}
+	t.recording.Resize(cols, rows)
+	t.notifyLocked(TerminalEvent{Type: "resize", Cols: cols, Rows: rows})
+}
+
+// Terminate stops the session's process group with SIGTERM, then SIGKILL after a grace period.
+func (t *TerminalSession) Terminate() {
+	t.termOnce.Do(func() {
+		go terminateGroup(t.info.PID, t.done, killGracePeriod)
+	})
+}
+
+func (t *TerminalSession) touch() {
+	t.mu.Lock()
+	t.info.LastActive = time.Now()
+	t.mu.Unlock()
+}
+
+// pump copies PTY output into the scrollback and to every attached client until the PTY closes.
+func (t *TerminalSession) pump() {
+	defer t.pty.Close()
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := t.pty.Read(buf)
+		if n > 0 {
+			t.broadcast(append([]byte(nil), buf[:n]...))
+		}
+		if err != nil {
+			// EIO once the last process holding the slave side exits
+			break
+		}
+	}
+	<-t.done
+	t.recording.Close()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for c := range t.clients {
+		c.closeLocked()
+	}
+}
+
+func (t *TerminalSession) broadcast(chunk []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.recording.Output(chunk)
+	t.buffer = append(t.buffer, chunk...)
+	if over := len(t.buffer) - t.scrollback; over > 0 {
+		t.buffer = append(t.buffer[:0], t.buffer[over:]...)
+	}
+	var dropped []*TerminalClient
+	for c := range t.clients {
+		select {
+		case c.events <- TerminalEvent{Type: "output", Data: chunk}:
+		default:
+			// a client that can't keep up is dropped; it can reattach and replay the scrollback
+			dropped = append(dropped, c)
+		}
+	}
+	for _, c := range dropped {
+		c.leaveLocked()
+	}
+}
+
+// notifyLocked sends a control event to every attached client. Unlike output, control events
+// are skipped for clients whose queue is full: they are about to be dropped anyway.
+func (t *TerminalSession) notifyLocked(ev TerminalEvent) {
+	for c := range t.clients {
+		select {
+		case c.events <- ev:
+		default:
+		}
+	}
+}
+
+func (t *TerminalSession) participantsLocked() []TerminalParticipant {
+	out := make([]TerminalParticipant, 0, len(t.clients))
+	for c := range t.clients {
+		out = append(out, c.participant)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].JoinedAt.Before(out[j].JoinedAt) })
+	return out
+}
+
+// Events returns the channel of terminal output and session events. It is closed when the
+// client is detached or the session ends.
+func (c *TerminalClient) Events() <-chan TerminalEvent {
+	return c.events
+}
+
+// Participant returns how the client appears to the other participants.
+func (c *TerminalClient) Participant() TerminalParticipant {
+	c.session.mu.Lock()
+	defer c.session.mu.Unlock()
+	return c.participant
+}
+
+// Write sends input to the terminal if the client is allowed to type.
+func (c *TerminalClient) Write(p []byte) (int, error) {
+	if !c.Participant().Writable {
+		return 0, ErrTerminalReadOnly
+	}
+	return c.session.Write(p)
+}
+
+// Resize records the window size of the client and renegotiates the size of the terminal.
+func (c *TerminalClient) Resize(cols, rows uint16) {
+	if cols == 0 || rows == 0 {
+		return
+	}
+	t := c.session
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.clients[c]; !ok {
+		return
+	}
+	c.participant.Cols, c.participant.Rows = cols, rows
+	t.resizeLocked()
+}
+
+// Detach unregisters the client from its session.
+func (c *TerminalClient) Detach() {
+	t := c.session
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c.leaveLocked()
+	t.info.LastActive = time.Now()
+}
+
+// leaveLocked detaches the client and tells the remaining participants.
+func (c *TerminalClient) leaveLocked() {
+	t := c.session
+	if _, ok := t.clients[c]; !ok {
+		return
+	}
+	c.closeLocked()
+	p := c.participant
+	t.notifyLocked(TerminalEvent{Type: "leave", Participant: &p})
+	t.resizeLocked()
+}
+
+func (c *TerminalClient) closeLocked() {
+	c.once.Do(func() {
+		delete(c.session.clients, c)
+		close(c.events)
+	})
+}
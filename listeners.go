@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Prefixes of listen addresses that are not TCP addresses.
+const (
+	listenUnixPrefix    = "unix:"
+	listenSystemdPrefix = "systemd"
+)
+
+// listenFdsStart is the first file descriptor of the sockets passed by systemd.
+const listenFdsStart = 3
+
+type socketOptions struct {
+	Mode  os.FileMode // permissions of unix sockets
+	Group string      // group owning unix sockets, empty = the group of the process
+}
+
+// activatedSocket is a listening socket passed by systemd socket activation.
+type activatedSocket struct {
+	name string // FileDescriptorName= of the socket unit
+	ln   net.Listener
+}
+
+// listenerSet opens the listeners of listen addresses and hands out the sockets passed by
+// systemd, each to one address.
+type listenerSet struct {
+	network   string      // of TCP addresses
+	tls       *tls.Config // of TCP listeners, nil for plain HTTP
+	socket    socketOptions
+	activated []*activatedSocket // nil entries have been handed out
+}
+
+func newListenerSet(network string, tlsConfig *tls.Config, socket socketOptions) (*listenerSet, error) {
+	activated, err := systemdSockets()
+	if err != nil {
+		return nil, err
+	}
+	return &listenerSet{network: network, tls: tlsConfig, socket: socket, activated: activated}, nil
+}
+
+// Open listens on an address: "host:port" for TCP, "unix:<path>" for a unix socket,
+// "systemd:<name>" for the sockets passed by systemd with that name and "systemd" for all
+// passed sockets not opened by name before. TCP listeners serve TLS if configured, unix
+// sockets are meant for a reverse proxy on the same host and never do.
+func (s *listenerSet) Open(addr string) ([]net.Listener, error) {
+	listeners, err := s.open(addr)
+	if err != nil {
+		return nil, err
+	}
+	for i, ln := range listeners {
+		if s.secure(ln) {
+			listeners[i] = tls.NewListener(ln, s.tls)
+		}
+	}
+	return listeners, nil
+}
+
+func (s *listenerSet) open(addr string) ([]net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, listenUnixPrefix):
+		ln, err := listenUnix(strings.TrimPrefix(addr, listenUnixPrefix), s.socket)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{ln}, nil
+	case addr == listenSystemdPrefix || strings.HasPrefix(addr, listenSystemdPrefix+":"):
+		name, byName := strings.CutPrefix(addr, listenSystemdPrefix+":")
+		var listeners []net.Listener
+		for i, sock := range s.activated {
+			if sock != nil && (!byName || sock.name == name) {
+				listeners = append(listeners, sock.ln)
+				s.activated[i] = nil
+			}
+		}
+		if len(listeners) == 0 {
+			return nil, fmt.Errorf("no socket passed by systemd for %q", addr)
+		}
+		return listeners, nil
+	}
+	ln, err := net.Listen(s.network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{ln}, nil
+}
+
+// Unused closes the sockets passed by systemd that no address opened and returns their names.
+func (s *listenerSet) Unused() []string {
+	var names []string
+	for i, sock := range s.activated {
+		if sock != nil {
+			names = append(names, sock.name)
+			sock.ln.Close()
+			s.activated[i] = nil
+		}
+	}
+	return names
+}
+
+// validateListenAddr checks the syntax of a listen address without opening it.
+func validateListenAddr(addr string) error {
+	switch {
+	case strings.HasPrefix(addr, listenUnixPrefix):
+		if strings.TrimPrefix(addr, listenUnixPrefix) == "" {
+			return errors.New("missing path of unix socket, expected unix:<path>")
+		}
+	case addr == listenSystemdPrefix || strings.HasPrefix(addr, listenSystemdPrefix+":"):
+		if addr == listenSystemdPrefix+":" {
+			return errors.New("missing socket name, expected systemd:<name>")
+		}
+	default:
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid address %q, expected host:port, unix:<path> or systemd[:<name>]", addr)
+		}
+	}
+	return nil
+}
+
+// parseSocketMode parses the octal permissions of unix sockets such as "0660".
+func parseSocketMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid permissions %q, expected an octal mode such as 0660", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// listenUnix listens on a unix socket with the given permissions. A socket file left behind
+// by a server that did not shut down is replaced, one that is still served is not.
+func listenUnix(path string, opts socketOptions) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := setSocketOwner(path, opts); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("unix socket %s: %w", path, err)
+	}
+	return ln, nil
+}
+
+func setSocketOwner(path string, opts socketOptions) error {
+	if opts.Group != "" {
+		group, err := user.LookupGroup(opts.Group)
+		if err != nil {
+			return err
+		}
+		gid, err := strconv.Atoi(group.Gid)
+		if err != nil {
+			return err
+		}
+		if err := os.Chown(path, -1, gid); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(path, opts.Mode)
+}
+
+// systemdSockets takes over the listening sockets passed by systemd socket activation, see
+// sd_listen_fds(3). The LISTEN_* variables are removed so processes spawned by the server do
+// not take them for their own.
+func systemdSockets() ([]*activatedSocket, error) {
+	pid, fds, fdNames := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(fdNames, ":")
+	sockets := make([]*activatedSocket, 0, n)
+	for i := range n {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+		name := "unknown" // systemd's name of sockets without FileDescriptorName=
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %q passed by systemd is not a listening stream socket: %w", name, err)
+		}
+		sockets = append(sockets, &activatedSocket{name: name, ln: ln})
+	}
+	return sockets, nil
+}
+
+// Serve listens on addrs and serves app on every listener, sending the error to errs if
+// serving fails before the app is shut down.
+func (s *listenerSet) Serve(app *fiber.App, what string, addrs []string, errs chan<- error) error {
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		opened, err := s.Open(addr)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		listeners = append(listeners, opened...)
+	}
+	// app.Listener builds the routes on every call, which must not race with serving requests
+	app.Handler()
+	for _, ln := range listeners {
+		slog.Info("Serving "+what, "addr", s.URL(ln))
+		go func() {
+			if err := app.Server().Serve(ln); err != nil {
+				errs <- fmt.Errorf("failed to serve %s on %s: %w", what, s.URL(ln), err)
+			}
+		}()
+	}
+	return nil
+}
+
+func (s *listenerSet) secure(ln net.Listener) bool {
+	return s.tls != nil && ln.Addr().Network() == "tcp"
+}
+
+// URL describes where a listener opened by Open serves, e.g. https://[::]:3000 or
+// unix:/run/ide.sock.
+func (s *listenerSet) URL(ln net.Listener) string {
+	switch {
+	case ln.Addr().Network() == "unix":
+		return listenUnixPrefix + ln.Addr().String()
+	case s.secure(ln):
+		return "https://" + ln.Addr().String()
+	}
+	return "http://" + ln.Addr().String()
+}
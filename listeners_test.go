@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestValidateListenAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr bool
+	}{
+		{":3000", false},
+		{"127.0.0.1:3000", false},
+		{"[::1]:3000", false},
+		{"localhost:https", false},
+		{"unix:/run/ide.sock", false},
+		{"unix:relative.sock", false},
+		{"systemd", false},
+		{"systemd:web", false},
+		{"", true},
+		{"localhost", true},
+		{"::1", true},
+		{"unix:", true},
+		{"systemd:", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			err := validateListenAddr(tt.addr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateListenAddr(%q) = %v, want error %v", tt.addr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseSocketMode(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{s: "0660", want: 0o660},
+		{s: "660", want: 0o660},
+		{s: "0600", want: 0o600},
+		{s: "0777", want: 0o777},
+		{s: "0", want: 0},
+		{s: "01777", wantErr: true},
+		{s: "0999", wantErr: true},
+		{s: "rw-rw----", wantErr: true},
+		{s: "", wantErr: true},
+		{s: "-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseSocketMode(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSocketMode(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseSocketMode(%q) = %o, want %o", tt.s, got, tt.want)
+			}
+		})
+	}
+}
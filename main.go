@@ -1,10 +1,16 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"log/slog"
+	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -15,17 +21,31 @@ import (
 )
 
 var (
-	app       *cli.App
-	gitCommit string
-	gitDate   string
-	gitTag    string
+	app          *cli.App
+	settingFlags []cli.Flag // every flag but --config, which can also be set by env and config file
+	gitCommit    string
+	gitDate      string
+	gitTag       string
 )
 
 var (
-	listenFlag = &cli.StringFlag{
+	listenFlag = &cli.StringSliceFlag{
 		Name:  "listen",
-		Usage: "Address to listen on",
-		Value: ":3000",
+		Usage: "Address to listen on: \"host:port\", \"unix:<path>\" or \"systemd[:<name>]\" for sockets passed by systemd, repeat to listen on several",
+		Value: cli.NewStringSlice(":3000"),
+	}
+	adminListenFlag = &cli.StringSliceFlag{
+		Name:  "admin-listen",
+		Usage: "Serve the admin API on these addresses, e.g. \"unix:/run/vscode-server/admin.sock\" (empty = no admin API)",
+	}
+	socketModeFlag = &cli.StringFlag{
+		Name:  "socket-mode",
+		Usage: "Permissions of unix sockets listened on, in octal",
+		Value: "0660",
+	}
+	socketGroupFlag = &cli.StringFlag{
+		Name:  "socket-group",
+		Usage: "Group owning unix sockets listened on (empty = the group of the server)",
 	}
 	rootDirFlag = &cli.StringFlag{
 		Name:  "rootdir",
@@ -41,23 +61,207 @@ var (
 		Name:  "debug",
 		Usage: "Enable debug logging",
 	}
+	quotaBytesFlag = &cli.Int64Flag{
+		Name:  "quota-bytes",
+		Usage: "Maximum total bytes stored under the root directory (0 = unlimited)",
+	}
+	quotaFilesFlag = &cli.Int64Flag{
+		Name:  "quota-files",
+		Usage: "Maximum number of files stored under the root directory (0 = unlimited)",
+	}
+	quotaScanIntervalFlag = &cli.DurationFlag{
+		Name:  "quota-scan-interval",
+		Usage: "Interval between full rescans that reconcile quota usage",
+		Value: 10 * time.Minute,
+	}
+	terminalIdleTimeoutFlag = &cli.DurationFlag{
+		Name:  "terminal-idle-timeout",
+		Usage: "Terminate terminal sessions with no attached client for this long (0 = never, exited sessions are still removed after 5m)",
+		Value: core.DefaultTerminalIdleTimeout,
+	}
+	terminalScrollbackFlag = &cli.IntFlag{
+		Name:  "terminal-scrollback",
+		Usage: "Bytes of terminal output kept per session and replayed on reattach",
+		Value: core.DefaultTerminalScrollback,
+	}
+	lspServerFlag = &cli.StringSliceFlag{
+		Name:  "lsp-server",
+		Usage: "Language server as \"<id>=<command> [args...]\", overriding the built-in server with the same id",
+	}
+	lspIdleTimeoutFlag = &cli.DurationFlag{
+		Name:  "lsp-idle-timeout",
+		Usage: "Shut down language servers with no attached client for this long (0 = never)",
+		Value: core.DefaultLSPIdleTimeout,
+	}
+	debugAdapterFlag = &cli.StringSliceFlag{
+		Name:  "debug-adapter",
+		Usage: "Debug adapter as \"<type>=<command> [args...]\" for launch configurations of that type, {port} makes it listen on TCP",
+	}
+	proxyPortsFlag = &cli.StringFlag{
+		Name:  "proxy-ports",
+		Usage: "Local ports forwarded under /proxy/<port>/, as a list of ports and ranges such as \"3000-3999,8080\" (empty = forwarding disabled)",
+		Value: core.DefaultForwardedPorts,
+	}
+	proxyDomainFlag = &cli.StringFlag{
+		Name:  "proxy-domain",
+		Usage: "Also forward <port>.<domain> to local ports, e.g. \"ide.example.com\" (requires a wildcard DNS record)",
+	}
+	portScanIntervalFlag = &cli.DurationFlag{
+		Name:  "port-scan-interval",
+		Usage: "Interval between scans for ports listened on by terminal and task processes (0 = disabled)",
+		Value: core.DefaultPortScanInterval,
+	}
+	hostMetricsIntervalFlag = &cli.DurationFlag{
+		Name:  "host-metrics-interval",
+		Usage: "Interval between samples of host CPU, memory and disk usage (0 = sample on request only)",
+		Value: core.DefaultHostMetricsInterval,
+	}
+	hostMetricsHistoryFlag = &cli.IntFlag{
+		Name:  "host-metrics-history",
+		Usage: "Number of host metrics samples kept",
+		Value: core.DefaultHostMetricsHistory,
+	}
+	shutdownTimeoutFlag = &cli.DurationFlag{
+		Name:  "shutdown-timeout",
+		Usage: "Time in-flight requests get to finish on SIGTERM or SIGINT before their connections are closed",
+		Value: 30 * time.Second,
+	}
+	tlsCertFlag = &cli.StringFlag{
+		Name:  "tls-cert",
+		Usage: "Serve HTTPS with this PEM certificate (chain), reloaded when the file changes",
+	}
+	tlsKeyFlag = &cli.StringFlag{
+		Name:  "tls-key",
+		Usage: "PEM private key of the --tls-cert certificate",
+	}
+	tlsClientCAFlag = &cli.StringFlag{
+		Name:  "tls-client-ca",
+		Usage: "Require client certificates signed by a CA of this PEM bundle (mutual TLS)",
+	}
+	tlsSelfSignedFlag = &cli.BoolFlag{
+		Name:  "tls-self-signed",
+		Usage: "Serve HTTPS with a certificate signed by a local CA, both created in --tls-dir on first run",
+	}
+	tlsDirFlag = &cli.StringFlag{
+		Name:  "tls-dir",
+		Usage: "Directory the self-signed CA and certificate are kept in (default: <user config dir>/vscode-server/tls)",
+	}
+	tlsHostsFlag = &cli.StringSliceFlag{
+		Name:  "tls-hosts",
+		Usage: "Extra DNS names and IP addresses of the self-signed certificate, which always covers localhost and the host name",
+	}
+	corsOriginsFlag = &cli.StringFlag{
+		Name:  "cors-origins",
+		Usage: "Comma separated origins allowed to make cross-origin requests, \"*\" for any",
+		Value: "*",
+	}
+	trustedProxiesFlag = &cli.StringSliceFlag{
+		Name:  "trusted-proxies",
+		Usage: "IP addresses and CIDR ranges of authenticating proxies, \"unix\" for unix socket peers, whose X-Forwarded-User and X-Forwarded-Email headers name the author of commits",
+	}
+	metricsListenFlag = &cli.StringFlag{
+		Name:  "metrics-listen",
+		Usage: "Serve Prometheus metrics on this address instead of at /metrics of the main listener",
+	}
+	traceExporterFlag = &cli.StringFlag{
+		Name:  "trace-exporter",
+		Usage: "Export OpenTelemetry traces of requests and file operations: \"otlp\", \"stdout\" or \"file\" (empty = tracing disabled)",
+	}
+	traceEndpointFlag = &cli.StringFlag{
+		Name:  "trace-endpoint",
+		Usage: "OTLP/HTTP endpoint of the otlp trace exporter, e.g. \"http://localhost:4318\", /v1/traces is appended to a URL without path (default: OTEL_EXPORTER_OTLP_ENDPOINT)",
+	}
+	traceFileFlag = &cli.StringFlag{
+		Name:  "trace-file",
+		Usage: "File the file trace exporter appends spans to as JSON",
+	}
+	traceSampleRatioFlag = &cli.Float64Flag{
+		Name:  "trace-sample-ratio",
+		Usage: "Share of new traces recorded, between 0 and 1; traces continued from a traceparent header follow its decision",
+		Value: 1,
+	}
+	recordingDirFlag = &cli.StringFlag{
+		Name:  "recording-dir",
+		Usage: "Directory to store terminal recordings in (empty = recording disabled)",
+	}
+	recordingPolicyFlag = &cli.StringFlag{
+		Name:  "recording-policy",
+		Usage: "Which terminal sessions are recorded: \"opt-in\" or \"always\", which also forbids deleting recordings",
+		Value: core.RecordOptIn,
+	}
+	recordingInputFlag = &cli.BoolFlag{
+		Name:  "recording-input",
+		Usage: "Record keystrokes in addition to terminal output",
+	}
+	recordingRetentionFlag = &cli.DurationFlag{
+		Name:  "recording-retention",
+		Usage: "Delete terminal recordings older than this (0 = keep forever)",
+		Value: 30 * 24 * time.Hour,
+	}
 )
 
 func init() {
 	app = cli.NewApp()
 	app.EnableBashCompletion = true
 	app.Usage = ""
-	app.Flags = []cli.Flag{
+	app.Description = "Every option can also be set with a " + envPrefix + "<OPTION> environment variable or in the --config file, see docs/configuration.md"
+	settingFlags = []cli.Flag{
 		debugFlag,
 		rootDirFlag,
 		webDirFlag,
 		listenFlag,
+		adminListenFlag,
+		socketModeFlag,
+		socketGroupFlag,
+		shutdownTimeoutFlag,
+		tlsCertFlag,
+		tlsKeyFlag,
+		tlsClientCAFlag,
+		tlsSelfSignedFlag,
+		tlsDirFlag,
+		tlsHostsFlag,
+		corsOriginsFlag,
+		trustedProxiesFlag,
+		quotaBytesFlag,
+		quotaFilesFlag,
+		quotaScanIntervalFlag,
+		terminalIdleTimeoutFlag,
+		terminalScrollbackFlag,
+		lspServerFlag,
+		lspIdleTimeoutFlag,
+		debugAdapterFlag,
+		proxyPortsFlag,
+		proxyDomainFlag,
+		portScanIntervalFlag,
+		hostMetricsIntervalFlag,
+		hostMetricsHistoryFlag,
+		metricsListenFlag,
+		traceExporterFlag,
+		traceEndpointFlag,
+		traceFileFlag,
+		traceSampleRatioFlag,
+		recordingDirFlag,
+		recordingPolicyFlag,
+		recordingInputFlag,
+		recordingRetentionFlag,
 	}
+	app.Flags = append([]cli.Flag{configFlag}, settingFlags...)
 	app.Commands = []*cli.Command{
 		{
 			Name:   "version",
 			Action: printVersion,
 		},
+		{
+			Name:  "config",
+			Usage: "Inspect the configuration",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "print",
+					Usage:  "Print the effective configuration and where each setting comes from",
+					Action: printConfig,
+				},
+			},
+		},
 	}
 	app.Action = run
 }
@@ -70,18 +274,43 @@ func printVersion(cli *cli.Context) error {
 	return nil
 }
 
-func mustInitLogger(debug bool) {
-	logLevel := slog.LevelInfo
+// logLevel is the level of the default logger, changed by reloading the configuration.
+var logLevel slog.LevelVar
+
+func setLogLevel(debug bool) {
 	if debug {
-		logLevel = slog.LevelDebug
+		logLevel.Set(slog.LevelDebug)
+	} else {
+		logLevel.Set(slog.LevelInfo)
 	}
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel})
+}
+
+func mustInitLogger(debug bool) {
+	setLogLevel(debug)
+	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: &logLevel})
 	slog.SetDefault(slog.New(handler))
 }
 
+func newCORSHandler(origins string) fiber.Handler {
+	return cors.New(cors.Config{
+		AllowOrigins: origins,
+		AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
+		AllowHeaders: "*",
+	})
+}
+
 func run(cli *cli.Context) error {
+	cfg, err := loadConfig(cli)
+	if err != nil {
+		return err
+	}
+	if err := validateConfig(cfg); err != nil {
+		return err
+	}
 	mustInitLogger(cli.Bool(debugFlag.Name))
-	listenAddr := cli.String(listenFlag.Name)
+	if path := cli.String(configFlag.Name); path != "" {
+		slog.Info("Loaded config file", "path", path)
+	}
 
 	webDir := cli.String(webDirFlag.Name)
 	if _, err := os.Stat(webDir); os.IsNotExist(err) {
@@ -97,9 +326,61 @@ func run(cli *cli.Context) error {
 		log.Fatal("must provide work directory")
 	}
 
+	shutdownTracing, err := setupTracing(cli.Context, tracingConfig{
+		Exporter:    cli.String(traceExporterFlag.Name),
+		Endpoint:    cli.String(traceEndpointFlag.Name),
+		File:        cli.String(traceFileFlag.Name),
+		SampleRatio: cli.Float64(traceSampleRatioFlag.Name),
+		ServiceName: cli.App.Name,
+	})
+	if err != nil {
+		log.Fatalf("failed to set up tracing: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn("failed to flush traces", "error", err)
+		}
+	}()
+
+	tlsDir := cli.String(tlsDirFlag.Name)
+	if tlsDir == "" && cli.Bool(tlsSelfSignedFlag.Name) {
+		if tlsDir, err = defaultTLSDir(); err != nil {
+			log.Fatalf("failed to find TLS directory: %v", err)
+		}
+	}
+	tlsConfig, certs, err := setupTLS(tlsOptions{
+		CertFile:     cli.String(tlsCertFlag.Name),
+		KeyFile:      cli.String(tlsKeyFlag.Name),
+		ClientCAFile: cli.String(tlsClientCAFlag.Name),
+		SelfSigned:   cli.Bool(tlsSelfSignedFlag.Name),
+		Dir:          tlsDir,
+		Hosts:        selfSignedHosts(cli.StringSlice(tlsHostsFlag.Name), cli.String(proxyDomainFlag.Name)),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if certs != nil {
+		go certs.Run(cli.Context, certCheckInterval)
+	}
+
 	lfs := core.NewLocalFileService(rootDir)
+	quotaBytes, quotaFiles := cli.Int64(quotaBytesFlag.Name), cli.Int64(quotaFilesFlag.Name)
+	if quotaBytes > 0 || quotaFiles > 0 {
+		lfs.Quota = core.NewQuota(rootDir, quotaBytes, quotaFiles)
+		if err := lfs.Quota.Reconcile(); err != nil {
+			log.Fatalf("failed to scan root directory for quota: %v", err)
+		}
+		usage := lfs.Quota.Usage()
+		slog.Info("Storage quota enabled", "bytes", usage.Bytes, "maxBytes", quotaBytes, "files", usage.Files, "maxFiles", quotaFiles)
+		go lfs.Quota.Run(cli.Context, cli.Duration(quotaScanIntervalFlag.Name))
+	}
 
+	trustedProxies := trustedProxyAddrs(cli.StringSlice(trustedProxiesFlag.Name))
 	app := fiber.New(fiber.Config{
+		EnableTrustedProxyCheck: len(trustedProxies) > 0,
+		TrustedProxies:          trustedProxies,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 			if e, ok := err.(*fiber.Error); ok {
@@ -113,21 +394,213 @@ func run(cli *cli.Context) error {
 		Format:     "${time} | ${status} | ${latency} | ${ip} | ${method} | ${path} ${queryParams} | ${error}\n",
 		TimeFormat: "2006-01-02 15:04:05",
 	}))
-	app.Use(cors.New(cors.Config{
-		AllowOrigins: "*",
-		AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
-		AllowHeaders: "*",
-	}))
+	app.Use(apiv1.MetricsMiddleware)
+	if exporter := cli.String(traceExporterFlag.Name); exporter != "" {
+		app.Use(apiv1.TracingMiddleware)
+		slog.Info("Tracing enabled", "exporter", exporter)
+	}
+	reloader, err := newConfigReloader(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the CORS handler is replaced when the allowed origins are reloaded
+	app.Use(func(c *fiber.Ctx) error {
+		return reloader.Settings().cors(c)
+	})
 
+	// Forward local ports ahead of the frontend, which would otherwise answer subdomain requests
+	if err := apiv1.SetupProxyRoutes(app, forwardedPorts{reloader}, cli.String(proxyDomainFlag.Name)); err != nil {
+		log.Fatal(err)
+	}
 	// Serve the built VS Code Web frontend from webDir at "/"
 	app.Static("/", webDir)
 	// Setup API routes at "/api/v1"
-	if err := apiv1.SetupRoutes(app, lfs); err != nil {
+	gitSvc := core.NewGitService(lfs)
+	if err := apiv1.SetupRoutes(app, lfs, gitSvc); err != nil {
+		log.Fatal(err)
+	}
+	jobs := core.NewJobManager()
+	if err := apiv1.SetupGitRoutes(app, gitSvc, jobs); err != nil {
+		log.Fatal(err)
+	}
+	if err := apiv1.SetupJobRoutes(app, jobs); err != nil {
+		log.Fatal(err)
+	}
+	events := core.NewEventBus()
+	if err := apiv1.SetupEventRoutes(app, events); err != nil {
+		log.Fatal(err)
+	}
+	processes := core.NewProcessRegistry()
+	ports := core.NewPortWatcher(processes, events)
+	ports.Allowlist = forwardedPorts{reloader}
+	go ports.Run(cli.Context, cli.Duration(portScanIntervalFlag.Name))
+	if err := apiv1.SetupPortRoutes(app, ports); err != nil {
+		log.Fatal(err)
+	}
+	processMonitor := core.NewProcessMonitor(processes)
+	go processMonitor.Run(cli.Context, core.DefaultCPUSampleInterval)
+	if err := apiv1.SetupProcessRoutes(app, processMonitor); err != nil {
+		log.Fatal(err)
+	}
+	hostMetrics := core.NewHostMetrics(rootDir, cli.Int(hostMetricsHistoryFlag.Name))
+	go hostMetrics.Run(cli.Context, cli.Duration(hostMetricsIntervalFlag.Name))
+	if err := apiv1.SetupHostRoutes(app, hostMetrics); err != nil {
+		log.Fatal(err)
+	}
+	execSvc := core.NewExecService(lfs, processes)
+	if err := apiv1.SetupExecRoutes(app, execSvc); err != nil {
+		log.Fatal(err)
+	}
+	tasks := core.NewTaskRunner(lfs, execSvc)
+	if err := apiv1.SetupTaskRoutes(app, tasks, jobs); err != nil {
+		log.Fatal(err)
+	}
+	terminals := core.NewTerminalService(lfs, processes)
+	terminals.SetIdleTimeout(cli.Duration(terminalIdleTimeoutFlag.Name))
+	terminals.SetScrollback(cli.Int(terminalScrollbackFlag.Name))
+	if recordingDir := cli.String(recordingDirFlag.Name); recordingDir != "" {
+		recorder, err := core.NewTerminalRecorder(recordingDir, cli.String(recordingPolicyFlag.Name), cli.Bool(recordingInputFlag.Name), cli.Duration(recordingRetentionFlag.Name))
+		if err != nil {
+			log.Fatalf("failed to set up terminal recording: %v", err)
+		}
+		terminals.Recorder = recorder
+		slog.Info("Terminal recording enabled", "dir", recordingDir, "policy", recorder.Policy, "input", recorder.RecordInput)
+		go recorder.Run(cli.Context, time.Hour)
+	}
+	go terminals.Run(cli.Context)
+	if err := apiv1.SetupTerminalRoutes(app, terminals); err != nil {
+		log.Fatal(err)
+	}
+	if err := apiv1.SetupRecordingRoutes(app, terminals.Recorder); err != nil {
+		log.Fatal(err)
+	}
+	languageServers, err := parseCommands("language server", core.DefaultLanguageServers, cli.StringSlice(lspServerFlag.Name))
+	if err != nil {
+		log.Fatal(err)
+	}
+	lsp := core.NewLSPManager(lfs, processes, languageServers)
+	lsp.SetIdleTimeout(cli.Duration(lspIdleTimeoutFlag.Name))
+	if err := apiv1.SetupLSPRoutes(app, lsp); err != nil {
+		log.Fatal(err)
+	}
+	debugAdapters, err := parseCommands("debug adapter", core.DefaultDebugAdapters, cli.StringSlice(debugAdapterFlag.Name))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := apiv1.SetupDebugRoutes(app, core.NewDebugService(lfs, processes, tasks, debugAdapters)); err != nil {
+		log.Fatal(err)
+	}
+	srv := &server{apps: []*fiber.App{app}, files: lfs, jobs: jobs, lsp: lsp, processes: processes}
+	sessions := map[string]apiv1.SessionCounter{"terminal": terminals, "lsp": lsp}
+	var metricsApp *fiber.App
+	if cli.String(metricsListenFlag.Name) != "" {
+		metricsApp = fiber.New(fiber.Config{DisableStartupMessage: true})
+		srv.apps = append(srv.apps, metricsApp)
+		if err := apiv1.SetupMetricsRoutes(metricsApp, lfs, sessions); err != nil {
+			log.Fatal(err)
+		}
+	} else if err := apiv1.SetupMetricsRoutes(app, lfs, sessions); err != nil {
 		log.Fatal(err)
 	}
 
-	log.Printf("Serving VSCode web with root directory %s at %s\n", rootDir, listenAddr)
-	return app.Listen(listenAddr)
+	reloader.Live(debugFlag.Name, corsOriginsFlag.Name, proxyPortsFlag.Name, terminalIdleTimeoutFlag.Name, terminalScrollbackFlag.Name, lspIdleTimeoutFlag.Name)
+	// enabling or disabling the quota needs a scan of the root directory
+	if lfs.Quota != nil {
+		reloader.Live(quotaBytesFlag.Name, quotaFilesFlag.Name)
+	}
+	reloader.OnReload(func(settings *liveSettings) {
+		setLogLevel(settings.debug)
+		if lfs.Quota != nil {
+			lfs.Quota.SetLimits(settings.quotaBytes, settings.quotaFiles)
+		}
+		terminals.SetIdleTimeout(settings.terminalIdleTimeout)
+		terminals.SetScrollback(settings.terminalScrollback)
+		lsp.SetIdleTimeout(settings.lspIdleTimeout)
+	})
+	go reloader.Run(cli.Context)
+	// the admin API has no authentication of its own: it is only served on the admin
+	// listeners, which are meant to be reachable by the operator alone
+	var adminApp *fiber.App
+	if len(cli.StringSlice(adminListenFlag.Name)) > 0 {
+		adminApp = fiber.New(fiber.Config{DisableStartupMessage: true})
+		srv.apps = append(srv.apps, adminApp)
+		if err := apiv1.SetupAdminRoutes(adminApp, reloader); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(cli.Context, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	socketMode, err := parseSocketMode(cli.String(socketModeFlag.Name))
+	if err != nil {
+		return err
+	}
+	listeners, err := newListenerSet(app.Config().Network, tlsConfig, socketOptions{Mode: socketMode, Group: cli.String(socketGroupFlag.Name)})
+	if err != nil {
+		return err
+	}
+	served := make(chan error, 1)
+	// the admin and metrics listeners go first to take the sockets passed by systemd by name
+	// before a plain "systemd" listen address takes the rest
+	if adminApp != nil {
+		if err := listeners.Serve(adminApp, "admin API", cli.StringSlice(adminListenFlag.Name), served); err != nil {
+			return err
+		}
+	}
+	if metricsApp != nil {
+		if err := listeners.Serve(metricsApp, "metrics", []string{cli.String(metricsListenFlag.Name)}, served); err != nil {
+			return err
+		}
+	}
+	log.Printf("Serving VSCode web with root directory %s\n", rootDir)
+	if err := listeners.Serve(app, "VSCode web", cli.StringSlice(listenFlag.Name), served); err != nil {
+		return err
+	}
+	if unused := listeners.Unused(); len(unused) > 0 {
+		slog.Warn("Closed sockets passed by systemd that no listen address uses", "names", unused)
+	}
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	// a second signal kills the server right away
+	stop()
+	timeout := cli.Duration(shutdownTimeoutFlag.Name)
+	slog.Info("Shutting down", "timeout", timeout)
+	srv.shutdown(timeout)
+	slog.Info("Server stopped")
+	return nil
+}
+
+// trustedProxyAddrs returns the trusted proxies as fiber expects them. Peers of unix sockets
+// have no IP address and are seen as 0.0.0.0.
+func trustedProxyAddrs(proxies []string) []string {
+	addrs := make([]string, 0, len(proxies))
+	for _, proxy := range proxies {
+		if proxy == trustedProxyUnix {
+			proxy = net.IPv4zero.String()
+		}
+		addrs = append(addrs, proxy)
+	}
+	return addrs
+}
+
+// parseCommands merges "<id>=<command> [args...]" definitions into the built-in commands.
+func parseCommands(what string, builtin map[string][]string, defs []string) (map[string][]string, error) {
+	commands := make(map[string][]string, len(builtin)+len(defs))
+	for id, command := range builtin {
+		commands[id] = command
+	}
+	for _, def := range defs {
+		id, command, ok := strings.Cut(def, "=")
+		fields := strings.Fields(command)
+		if !ok || id == "" || len(fields) == 0 {
+			return nil, fmt.Errorf("invalid %s %q, expected <id>=<command> [args...]", what, def)
+		}
+		commands[id] = fields
+	}
+	return commands, nil
 }
 
 func main() {
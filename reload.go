@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	apiv1 "github.com/khanghh/vscode-server/internal/api/v1"
+	"github.com/khanghh/vscode-server/internal/core"
+)
+
+// liveSettings are the settings in effect that can change while the server runs. They are
+// never modified: a reload swaps in new ones as a whole, so a request that loads them once
+// sees every setting from the same configuration.
+type liveSettings struct {
+	debug               bool
+	cors                fiber.Handler
+	forwardedPorts      *core.PortAllowlist
+	quotaBytes          int64
+	quotaFiles          int64
+	terminalIdleTimeout time.Duration
+	terminalScrollback  int
+	lspIdleTimeout      time.Duration
+}
+
+func newLiveSettings(cfg *config) (*liveSettings, error) {
+	ports, err := core.ParsePortAllowlist(cfg.String(proxyPortsFlag.Name))
+	if err != nil {
+		return nil, err
+	}
+	return &liveSettings{
+		debug:               cfg.Bool(debugFlag.Name),
+		cors:                newCORSHandler(cfg.String(corsOriginsFlag.Name)),
+		forwardedPorts:      ports,
+		quotaBytes:          cfg.Int64(quotaBytesFlag.Name),
+		quotaFiles:          cfg.Int64(quotaFilesFlag.Name),
+		terminalIdleTimeout: cfg.Duration(terminalIdleTimeoutFlag.Name),
+		terminalScrollback:  cfg.Int(terminalScrollbackFlag.Name),
+		lspIdleTimeout:      cfg.Duration(lspIdleTimeoutFlag.Name),
+	}, nil
+}
+
+// configReloader reloads the configuration and applies the changed settings that can change
+// while the server runs. Reloads are serialized and swap in the live settings as a whole.
+type configReloader struct {
+	settings atomic.Pointer[liveSettings]
+
+	mu       sync.Mutex
+	running  map[string]any                 // values of the settings in effect, by name
+	live     map[string]bool                // settings applied without a restart
+	onReload []func(settings *liveSettings) // hand the settings to services that keep a copy
+}
+
+func newConfigReloader(cfg *config) (*configReloader, error) {
+	settings, err := newLiveSettings(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := &configReloader{
+		running: make(map[string]any, len(settingFlags)),
+		live:    make(map[string]bool),
+	}
+	for _, f := range settingFlags {
+		r.running[f.Names()[0]] = settingValue(cfg.Context, f)
+	}
+	r.settings.Store(settings)
+	return r, nil
+}
+
+// Settings returns the live settings in effect.
+func (r *configReloader) Settings() *liveSettings {
+	return r.settings.Load()
+}
+
+// Live marks settings that are applied without a restart.
+func (r *configReloader) Live(names ...string) {
+	for _, name := range names {
+		r.live[name] = true
+	}
+}
+
+// OnReload registers a function that is given the live settings after every reload.
+func (r *configReloader) OnReload(apply func(settings *liveSettings)) {
+	r.onReload = append(r.onReload, apply)
+}
+
+// Reload loads the configuration again and applies the changed live settings. Changed
+// settings that are not live keep their running value until the server restarts. An invalid
+// configuration changes nothing.
+func (r *configReloader) Reload() (*apiv1.ConfigReload, error) {
+	cfg, err := reloadConfig()
+	if err == nil {
+		err = validateConfig(cfg)
+	}
+	var settings *liveSettings
+	if err == nil {
+		settings, err = newLiveSettings(cfg)
+	}
+	if err != nil {
+		slog.Error("Failed to reload configuration", "error", err)
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result := &apiv1.ConfigReload{Applied: []string{}, RestartRequired: []string{}}
+	running := maps.Clone(r.running)
+	for _, f := range settingFlags {
+		name := f.Names()[0]
+		value := settingValue(cfg.Context, f)
+		if reflect.DeepEqual(value, running[name]) {
+			continue
+		}
+		if r.live[name] {
+			running[name] = value
+			result.Applied = append(result.Applied, name)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, name)
+		}
+	}
+	r.running = running
+	r.settings.Store(settings)
+	for _, apply := range r.onReload {
+		apply(settings)
+	}
+	slog.Info("Reloaded configuration", "applied", result.Applied, "restartRequired", result.RestartRequired)
+	return result, nil
+}
+
+// Run reloads the configuration on SIGHUP until ctx is done.
+func (r *configReloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			_, _ = r.Reload()
+		}
+	}
+}
+
+// forwardedPorts is the port policy of the live settings in effect.
+type forwardedPorts struct {
+	reloader *configReloader
+}
+
+func (p forwardedPorts) Allowed(port int) bool {
+	return p.reloader.Settings().forwardedPorts.Allowed(port)
+}
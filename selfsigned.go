@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Files of the self-signed mode in the TLS directory.
+const (
+	selfSignedCAFile      = "ca.pem"
+	selfSignedCAKeyFile   = "ca-key.pem"
+	selfSignedCertFile    = "server.pem"
+	selfSignedCertKeyFile = "server-key.pem"
+)
+
+const (
+	selfSignedCAValidity   = 10 * 365 * 24 * time.Hour
+	selfSignedCertValidity = 397 * 24 * time.Hour // the longest validity browsers accept
+	selfSignedRenewBefore  = 30 * 24 * time.Hour
+)
+
+// defaultTLSDir returns the directory of the self-signed CA and certificate when --tls-dir is
+// not set.
+func defaultTLSDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "vscode-server", "tls"), nil
+}
+
+// ensureSelfSigned creates a local CA in dir on first use, and a server certificate for hosts
+// signed by it when there is none yet, it expires soon or misses one of hosts. It returns the
+// files of the server certificate and logs the fingerprint of the CA, which browsers have to
+// be told to trust.
+func ensureSelfSigned(dir string, hosts []string) (certFile, keyFile string, err error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", "", err
+	}
+	ca, err := loadOrCreateCA(dir)
+	if err != nil {
+		return "", "", err
+	}
+	slog.Info("Using self-signed TLS certificate", "ca", filepath.Join(dir, selfSignedCAFile), "fingerprint", certFingerprint(ca.Leaf))
+
+	certFile, keyFile = filepath.Join(dir, selfSignedCertFile), filepath.Join(dir, selfSignedCertKeyFile)
+	if cert, err := tls.LoadX509KeyPair(certFile, keyFile); err == nil && certCovers(cert.Leaf, ca.Leaf, hosts) {
+		return certFile, keyFile, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	template, err := newCertTemplate(hostName(), selfSignedCertValidity)
+	if err != nil {
+		return "", "", err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Leaf, key.Public(), ca.PrivateKey)
+	if err != nil {
+		return "", "", err
+	}
+	if err := writeKeyPair(certFile, keyFile, der, key); err != nil {
+		return "", "", err
+	}
+	slog.Info("Created self-signed TLS certificate", "cert", certFile, "hosts", hosts)
+	return certFile, keyFile, nil
+}
+
+// loadOrCreateCA loads the CA of dir, creating it if there is none.
+func loadOrCreateCA(dir string) (*tls.Certificate, error) {
+	certFile, keyFile := filepath.Join(dir, selfSignedCAFile), filepath.Join(dir, selfSignedCAKeyFile)
+	ca, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err == nil {
+		return &ca, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to load CA: %w", err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newCertTemplate("vscode-server CA "+hostName(), selfSignedCAValidity)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.MaxPathLenZero = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeKeyPair(certFile, keyFile, der, key); err != nil {
+		return nil, err
+	}
+	slog.Info("Created TLS certificate authority, add it to the trusted CAs of your browser", "ca", certFile)
+	if ca, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	return &ca, nil
+}
+
+// certCovers reports whether cert is signed by ca, is not about to expire and is valid for
+// every host.
+func certCovers(cert, ca *x509.Certificate, hosts []string) bool {
+	if cert.CheckSignatureFrom(ca) != nil || time.Until(cert.NotAfter) < selfSignedRenewBefore {
+		return false
+	}
+	for _, host := range hosts {
+		// VerifyHostname does not match wildcard names against themselves
+		name := strings.Replace(host, "*.", "x.", 1)
+		if cert.VerifyHostname(name) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func newCertTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Hour), // tolerate clock skew of clients
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+// writeKeyPair writes a certificate and its private key as PEM, the key readable by the owner only.
+func writeKeyPair(certFile, keyFile string, der []byte, key crypto.Signer) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644)
+}
+
+// certFingerprint returns the SHA-256 fingerprint of a certificate as colon separated hex,
+// the way browsers show it.
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	hex := make([]string, len(sum))
+	for i, b := range sum {
+		hex[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hex, ":")
+}
+
+func hostName() string {
+	if name, err := os.Hostname(); err == nil {
+		return name
+	}
+	return "localhost"
+}
+
+// selfSignedHosts returns the names the self-signed certificate is issued for: the local
+// host, the extra hosts and the forwarded port subdomains of the proxy domain.
+func selfSignedHosts(extra []string, proxyDomain string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "localhost" {
+		hosts = append(hosts, name)
+	}
+	hosts = append(hosts, extra...)
+	if proxyDomain != "" {
+		hosts = append(hosts, proxyDomain, "*."+proxyDomain)
+	}
+	return hosts
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	apiv1 "github.com/khanghh/vscode-server/internal/api/v1"
+	"github.com/khanghh/vscode-server/internal/core"
+)
+
+// processStopGrace is how long jobs and session processes get to exit after SIGTERM on
+// shutdown before they are killed.
+const processStopGrace = 5 * time.Second
+
+// server holds what has to be stopped when the server shuts down.
+type server struct {
+	apps      []*fiber.App
+	files     *core.LocalFileServiceImpl
+	jobs      *core.JobManager
+	lsp       *core.LSPManager
+	processes *core.ProcessRegistry
+}
+
+// shutdown stops accepting connections, tells WebSocket clients the server is going away and
+// lets in-flight requests of every app finish within one timeout. It then stops the language
+// servers, jobs, terminals and other session processes, and removes the staging files of
+// uploads that were cut off. Requests still running at that point are not interrupted, but
+// their uploads fail once the staging files are gone.
+func (s *server) shutdown(timeout time.Duration) {
+	apiv1.Shutdown()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	var timedOut atomic.Bool
+	for _, app := range s.apps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// fasthttp leaves the connections of unfinished requests open once ctx is done
+			if err := app.ShutdownWithContext(ctx); err != nil {
+				timedOut.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+	if timedOut.Load() {
+		slog.Warn("Requests still in flight after the shutdown timeout", "timeout", timeout)
+	}
+	s.lsp.Stop()
+	s.jobs.CancelAll(processStopGrace)
+	s.processes.TerminateAll(processStopGrace)
+	if n := s.files.RemoveStaging(); n > 0 {
+		slog.Info("Removed staging files of aborted uploads", "count", n)
+	}
+}
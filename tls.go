@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes.
+const certCheckInterval = 10 * time.Second
+
+type tlsOptions struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string   // CA bundle client certificates must be signed by, empty = no client auth
+	SelfSigned   bool     // use a certificate signed by a local CA kept in Dir
+	Dir          string   // directory of the self-signed CA and certificate
+	Hosts        []string // DNS names and IP addresses of the self-signed certificate
+}
+
+// setupTLS returns the TLS configuration of the listener, nil if TLS is disabled, and the
+// reloader of its certificate.
+func setupTLS(opts tlsOptions) (*tls.Config, *certReloader, error) {
+	if opts.SelfSigned {
+		var err error
+		if opts.CertFile, opts.KeyFile, err = ensureSelfSigned(opts.Dir, opts.Hosts); err != nil {
+			return nil, nil, fmt.Errorf("failed to set up self-signed certificate: %w", err)
+		}
+	}
+	if opts.CertFile == "" && opts.KeyFile == "" {
+		return nil, nil, nil
+	}
+	if opts.CertFile == "" || opts.KeyFile == "" {
+		return nil, nil, errors.New("TLS requires both a certificate and a key file")
+	}
+	certs, err := newCertReloader(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"http/1.1"},
+		GetCertificate: certs.GetCertificate,
+	}
+	if opts.ClientCAFile != "" {
+		pem, err := os.ReadFile(opts.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in client CA file %s", opts.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, certs, nil
+}
+
+// certReloader serves the certificate of a key pair and loads it again when the files change,
+// so a renewed certificate is picked up without a restart.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	cert     atomic.Pointer[tls.Certificate]
+	modTime  time.Time // of the files when they were last loaded
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) load() error {
+	// a change while loading is seen by the next check
+	r.modTime = r.filesModTime()
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	r.cert.Store(&cert)
+	return nil
+}
+
+// filesModTime returns the latest modification time of the certificate and key files.
+func (r *certReloader) filesModTime() time.Time {
+	var latest time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		if fi, err := os.Stat(path); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
+
+// GetCertificate returns the current certificate, as tls.Config.GetCertificate.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Run loads the certificate again whenever its files change until ctx is done. A certificate
+// that fails to load, e.g. because only one of the files was replaced so far, keeps the
+// previous one in use.
+func (r *certReloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if modTime := r.filesModTime(); modTime.Equal(r.modTime) {
+			continue
+		}
+		if err := r.load(); err != nil {
+			slog.Warn("Failed to reload TLS certificate, keeping the current one", "cert", r.certFile, "error", err)
+			continue
+		}
+		slog.Info("Reloaded TLS certificate", "cert", r.certFile, "notAfter", r.cert.Load().Leaf.NotAfter)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Trace exporters selectable with --trace-exporter.
+const (
+	traceExporterNone   = ""
+	traceExporterOTLP   = "otlp"
+	traceExporterStdout = "stdout"
+	traceExporterFile   = "file"
+)
+
+type tracingConfig struct {
+	Exporter    string
+	Endpoint    string  // OTLP/HTTP endpoint URL, the OTEL_EXPORTER_OTLP_* environment when empty
+	File        string  // file the spans are appended to by the file exporter
+	SampleRatio float64 // share of new traces recorded; traces continued from a request keep its decision
+	ServiceName string
+}
+
+// setupTracing installs the global tracer provider and the W3C trace context propagator. The
+// returned function flushes pending spans and stops the exporter.
+func setupTracing(ctx context.Context, cfg tracingConfig) (func(context.Context) error, error) {
+	if cfg.Exporter == traceExporterNone {
+		return func(context.Context) error { return nil }, nil
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("trace sample ratio %v is not between 0 and 1", cfg.SampleRatio)
+	}
+
+	var (
+		exporter sdktrace.SpanExporter
+		closer   io.Closer
+		err      error
+	)
+	switch cfg.Exporter {
+	case traceExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			endpoint, perr := url.Parse(cfg.Endpoint)
+			if perr != nil {
+				return nil, fmt.Errorf("invalid trace endpoint: %w", perr)
+			}
+			// like OTEL_EXPORTER_OTLP_ENDPOINT, a base URL gets the traces path appended
+			if endpoint.Path == "" || endpoint.Path == "/" {
+				endpoint.Path = "/v1/traces"
+			}
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint.String()))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	case traceExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case traceExporterFile:
+		if cfg.File == "" {
+			return nil, errors.New("the file trace exporter requires --trace-file")
+		}
+		f, ferr := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if ferr != nil {
+			return nil, ferr
+		}
+		closer = f
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, expected %q, %q or %q", cfg.Exporter, traceExporterOTLP, traceExporterStdout, traceExporterFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", cfg.Exporter, err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", cfg.ServiceName),
+		attribute.String("service.version", gitTag),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
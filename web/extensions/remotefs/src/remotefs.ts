@@ -223,6 +223,8 @@ export class RemoteFS implements FileSystemProvider {
         return FileSystemError.FileIsADirectory();
       case 'NO_PERMISSIONS':
         return FileSystemError.NoPermissions();
+      case 'QUOTA_EXCEEDED':
+        return FileSystemError.NoPermissions(data?.error ?? 'storage quota exceeded');
     }
 
     if (response?.status === 500) {